@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/query"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionAlbums is the CLI action for albums
+func actionAlbums(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	out, err := newOutput(ctx)
+	if err != nil {
+		return err
+	}
+
+	q, err := query.ParseArgs(cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	idx, err := ctx.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	// albums are worked out from every track, so that a query doesn't make them look incomplete
+	for _, a := range index.GroupAlbums(entries) {
+		if !q.IsEmpty() && len(q.Filter(a.Tracks)) == 0 {
+			continue
+		}
+		if err = out.write(albumRecord{a}); err != nil {
+			return err
+		}
+	}
+
+	return out.flush()
+}
+
+// albumRecord is an album worked out from the indexed tracks.
+type albumRecord struct {
+	index.Album
+}
+
+func (r albumRecord) text() string {
+	details := []string{fmt.Sprintf("%d track(s)", len(r.Tracks))}
+	if r.Discs > 1 {
+		details = append(details, fmt.Sprintf("%d discs", r.Discs))
+	}
+	details = append(details, formatDuration(r.Duration), "art: "+string(r.Art))
+	if missing := describeMissing(r.Album); missing != "" {
+		details = append(details, "missing "+missing)
+	}
+
+	return fmt.Sprintf("%s: %s", describeAlbum(r.Album), strings.Join(details, ", "))
+}
+
+func (r albumRecord) columns() []string {
+	return []string{"dir", "artist", "album", "year", "tracks", "discs", "duration", "art", "missing"}
+}
+
+func (r albumRecord) values() []string {
+	return []string{r.Dir, r.Artist, r.Title, positiveString(r.Year), strconv.Itoa(len(r.Tracks)),
+		positiveString(r.Discs), positiveString(int(r.Duration.Seconds() + 0.5)), string(r.Art), describeMissing(r.Album)}
+}
+
+// describeAlbum names an album and says where it is, e.g. "Artist - Album (1998) [Artist/Album]".
+func describeAlbum(a index.Album) string {
+	title := a.Title
+	if title == "" {
+		title = "(no album)"
+	}
+	artist := a.Artist
+	if artist == "" {
+		artist = "(no artist)"
+	}
+	year := ""
+	if a.Year > 0 {
+		year = fmt.Sprintf(" (%d)", a.Year)
+	}
+	return fmt.Sprintf("%s - %s%s [%s]", artist, title, year, a.Dir)
+}
+
+// describeMissing lists the missing tracks and discs of an album, e.g. "7, 9-12", or "disc 1: 3, disc 2" for an
+// album with more than one disc.
+func describeMissing(a index.Album) string {
+	missing := describePositions(a.MissingTracks, a.Discs > 1)
+	for _, d := range a.MissingDiscs {
+		missing = append(missing, fmt.Sprintf("disc %d", d))
+	}
+	return strings.Join(missing, ", ")
+}
+
+// describePositions lists track positions sorted by disc, collapsing runs of numbers into ranges. The numbers on
+// each disc are prefixed with it if there is more than one.
+func describePositions(positions []index.TrackPosition, multiDisc bool) []string {
+	var described []string
+	for i := 0; i < len(positions); {
+		disc := positions[i].Disc
+		var numbers []int
+		for ; i < len(positions) && positions[i].Disc == disc; i++ {
+			numbers = append(numbers, positions[i].Number)
+		}
+
+		if multiDisc {
+			described = append(described, fmt.Sprintf("disc %d: %s", disc, describeRanges(numbers)))
+		} else {
+			described = append(described, describeRanges(numbers))
+		}
+	}
+	return described
+}
+
+// describeRanges lists sorted numbers, collapsing runs of them into ranges like "3-7".
+func describeRanges(numbers []int) string {
+	var ranges []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+		if j > i {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", numbers[i], numbers[j]))
+		} else {
+			ranges = append(ranges, strconv.Itoa(numbers[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// formatDuration formats a duration like a track listing does: "3:07", or "1:02:03" if it is an hour or more.
+func formatDuration(d time.Duration) string {
+	seconds := int(d.Seconds() + 0.5)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/fileops"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// coverFileName is the name, without an extension, of the cover art files vir art extract writes.
+const coverFileName = "cover"
+
+// defaultArtMaxSize is the longest side, in pixels, of the images vir art embed writes unless told otherwise: large
+// enough for any screen a track is likely to be played on, and small enough for car stereos and portable players.
+const defaultArtMaxSize = 800
+
+// defaultArtQuality is the JPEG quality vir art embed re-encodes images at when it has to shrink them.
+const defaultArtQuality = 90
+
+// actionArtShow is the CLI action for art show
+func actionArtShow(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	_, dirs, byDir, err := indexedDirs(ctx, cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		fmt.Printf("%s: %s\n", dir, describeDirArt(byDir[dir]))
+		for _, e := range byDir[dir] {
+			fmt.Printf("  %s: %s\n", filepath.Base(e.RelFilename), describeTrackArt(e))
+		}
+	}
+
+	return nil
+}
+
+// coverExtraction is a cover file to write, as a path in the library, and the picture to write to it.
+type coverExtraction struct {
+	path  string
+	cover track.Picture
+}
+
+// actionArtExtract is the CLI action for art extract
+func actionArtExtract(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	idx, dirs, byDir, err := indexedDirs(ctx, cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	var extractions []coverExtraction
+	for _, dir := range dirs {
+		e, ok := commonCoverEntry(byDir[dir])
+		if !ok {
+			continue
+		}
+
+		pictures, err := track.ReadPictures(e.FullPath)
+		if err != nil {
+			return err
+		}
+		cover, ok := track.FrontCover(pictures)
+		if !ok || cover.Extension() == "" {
+			fmt.Printf("skip %s: can't tell what kind of image the cover art is\n", dir)
+			continue
+		}
+
+		path := filepath.Join(dir, coverFileName+cover.Extension())
+		if _, statErr := os.Stat(idx.FullPath(path)); statErr == nil && !cliCtx.Bool("force") {
+			fmt.Printf("skip %s: %s already exists\n", dir, path)
+			continue
+		}
+
+		fmt.Printf("%s: %s from %s\n", path, describePicture(cover.Info()), filepath.Base(e.RelFilename))
+		extractions = append(extractions, coverExtraction{path: path, cover: cover})
+	}
+
+	// cover files replaced with --force are kept in the journal, so that vir undo can put them back
+	plan := fileops.NewPlan("", "art extract")
+	for _, x := range extractions {
+		if err := plan.AddWriteFile(journalPath(idx.FullPath(x.path)), x.cover.Data); err != nil {
+			return err
+		}
+	}
+
+	_, err = runPlan(ctx, cliCtx, plan)
+	return err
+}
+
+// coverEmbedding is a cover file to embed, and the tracks to embed it in.
+type coverEmbedding struct {
+	dir     string
+	cover   track.Picture
+	entries []index.Entry
+}
+
+// actionArtEmbed is the CLI action for art embed
+func actionArtEmbed(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	idx, err := ctx.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	// work from what is actually on disk, since we're going to rewrite the files
+	if _, err = idx.Update(ctx.runCtx, index.ScanOptions{Jobs: ctx.jobs}); err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+	dirs, byDir := entriesByDir(entries, cliCtx.Args())
+
+	images := make(map[string][]string)
+	for fileEntry := range idx.ListSidecarFiles(ctx.runCtx) {
+		if fileEntry.Error != nil {
+			return fileEntry.Error
+		}
+		if coverFileRank(fileEntry.RelFilename) >= 0 {
+			dir := filepath.Dir(fileEntry.RelFilename)
+			images[dir] = append(images[dir], fileEntry.RelFilename)
+		}
+	}
+	if ctx.runCtx.Err() != nil {
+		return virErrors.ErrInterrupted("vir/cmd/vir.actionArtEmbed")
+	}
+
+	var embeddings []coverEmbedding
+	for _, dir := range dirs {
+		path, cover, err := bestCoverFile(idx, images[dir])
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+
+		original := cover.Info()
+		cover, shrunk, shrinkErr := cover.Shrink(cliCtx.Int("max-size"), cliCtx.Int("quality"))
+		if shrinkErr != nil {
+			fmt.Printf("skip %s: can't shrink %s: %s\n", dir, path, shrinkErr)
+			continue
+		}
+
+		x := coverEmbedding{dir: dir, cover: cover}
+		for _, e := range byDir[dir] {
+			if !e.Format.CanWriteTags() {
+				fmt.Printf("skip %s: writing tags to %s files is not supported\n", e.RelFilename, e.Format)
+				continue
+			}
+			existing, ok := e.FrontCover()
+			if ok && existing.Hash == cover.Info().Hash {
+				continue
+			}
+			if ok && existing.Type == track.PictureFrontCover && !cliCtx.Bool("force") {
+				fmt.Printf("skip %s: already has a front cover\n", e.RelFilename)
+				continue
+			}
+			x.entries = append(x.entries, e)
+		}
+		if len(x.entries) == 0 {
+			continue
+		}
+
+		description := describePicture(cover.Info())
+		if shrunk {
+			description = fmt.Sprintf("%s, shrunk from %dx%d", description, original.Width, original.Height)
+		}
+		fmt.Printf("embed %s (%s) in %d track(s)\n", path, description, len(x.entries))
+		embeddings = append(embeddings, x)
+	}
+
+	// the pictures the new covers replace are kept in the journal, so that vir undo can put them back
+	plan := fileops.NewPlan("", "art embed")
+	for _, x := range embeddings {
+		for _, e := range x.entries {
+			if err := plan.AddEmbedPicture(journalPath(e.FullPath), x.cover); err != nil {
+				return err
+			}
+		}
+	}
+
+	ran, err := runPlan(ctx, cliCtx, plan)
+	if !ran {
+		return err
+	}
+
+	// keep the index in step with the files we wrote, even if we didn't get through all of them
+	_, updateErr := idx.Update(ctx.runCtx, index.ScanOptions{Jobs: ctx.jobs})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// coverFileRank ranks an image file by how likely its name makes it to be an album's front cover: 0 for the names
+// taggers and rippers give front covers, 1 for folder images, which are often thumbnails, and 2 for any other image.
+// Images named after some other part of the packaging, and files that aren't images vir can read, rank -1.
+func coverFileRank(path string) int {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
+		return -1
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	for _, other := range []string{"back", "cd", "disc", "inlay", "inside", "tray", "booklet", "artist"} {
+		if strings.Contains(name, other) {
+			return -1
+		}
+	}
+
+	switch {
+	case name == "cover" || name == "front" || strings.HasPrefix(name, "cover") || strings.HasPrefix(name, "front"):
+		return 0
+	case name == "folder" || strings.HasPrefix(name, "albumart"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// bestCoverFile picks the image most likely to be the front cover from those in a directory, by the rank of its name
+// and then by its resolution, returning its path and the picture. The path is "" if none of them will do.
+func bestCoverFile(idx index.Index, paths []string) (string, track.Picture, virErrors.ScopedError) {
+	best, bestRank := "", -1
+	var bestPicture track.Picture
+	for _, path := range paths {
+		rank := coverFileRank(path)
+		if bestRank >= 0 && rank > bestRank {
+			continue
+		}
+
+		p, err := track.LoadPicture(idx.FullPath(path))
+		if err != nil {
+			return "", track.Picture{}, err
+		}
+		if p.Width == 0 || p.Height == 0 {
+			continue
+		}
+
+		if bestRank < 0 || rank < bestRank || p.Width*p.Height > bestPicture.Width*bestPicture.Height {
+			best, bestRank, bestPicture = path, rank, p
+		}
+	}
+	return best, bestPicture, nil
+}
+
+// indexedDirs loads the index and groups the indexed tracks by directory, as entriesByDir does.
+func indexedDirs(ctx *virContext, only []string) (index.Index, []string, map[string][]index.Entry, virErrors.ScopedError) {
+	idx, err := ctx.loadIndex()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	dirs, byDir := entriesByDir(entries, only)
+	return idx, dirs, byDir, nil
+}
+
+// entriesByDir groups tracks by directory, returning the sorted directories alongside. If any directories are given,
+// as paths in the library, only the tracks in or under them are included.
+func entriesByDir(entries []index.Entry, only []string) ([]string, map[string][]index.Entry) {
+	var dirs []string
+	byDir := make(map[string][]index.Entry)
+	for _, e := range entries {
+		dir := filepath.Dir(e.RelFilename)
+		if !inAnyDir(dir, only) {
+			continue
+		}
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], e)
+	}
+	sort.Strings(dirs)
+
+	return dirs, byDir
+}
+
+// inAnyDir reports whether dir is one of the given directories or under one of them, or whether none are given.
+func inAnyDir(dir string, only []string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, o := range only {
+		o = filepath.Clean(o)
+		if dir == o || strings.HasPrefix(dir, o+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonCoverEntry returns a track with the cover art shared by most of the given tracks.
+func commonCoverEntry(entries []index.Entry) (index.Entry, bool) {
+	counts := make(map[string]int)
+	first := make(map[string]index.Entry)
+	best := ""
+	for _, e := range entries {
+		cover, ok := e.FrontCover()
+		if !ok {
+			continue
+		}
+		if _, seen := first[cover.Hash]; !seen {
+			first[cover.Hash] = e
+		}
+		counts[cover.Hash]++
+		if best == "" || counts[cover.Hash] > counts[best] {
+			best = cover.Hash
+		}
+	}
+	if best == "" {
+		return index.Entry{}, false
+	}
+	return first[best], true
+}
+
+// describeDirArt says whether the tracks in a directory share the same cover art.
+func describeDirArt(entries []index.Entry) string {
+	covers := make(map[string]bool)
+	withArt := 0
+	for _, e := range entries {
+		if cover, ok := e.FrontCover(); ok {
+			covers[cover.Hash] = true
+			withArt++
+		}
+	}
+
+	switch {
+	case withArt == 0:
+		return "no embedded art"
+	case len(entries) == 1:
+		return "one track with a cover"
+	case len(covers) == 1 && withArt == len(entries):
+		return fmt.Sprintf("all %d tracks share the same cover", len(entries))
+	case len(covers) == 1:
+		return fmt.Sprintf("%d of %d tracks share the same cover, and the rest have none", withArt, len(entries))
+	default:
+		return fmt.Sprintf("%d different covers across %d tracks", len(covers), len(entries))
+	}
+}
+
+func describeTrackArt(e index.Entry) string {
+	if len(e.Pictures) == 0 {
+		return "no art"
+	}
+
+	descriptions := make([]string, len(e.Pictures))
+	for i, p := range e.Pictures {
+		descriptions[i] = describePicture(p)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+func describePicture(p track.PictureInfo) string {
+	size := "?x?"
+	if p.Width > 0 && p.Height > 0 {
+		size = fmt.Sprintf("%dx%d", p.Width, p.Height)
+	}
+	return fmt.Sprintf("%s, %s, %s, %d bytes", track.DescribePictureType(p.Type), p.MIME, size, p.Size)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/config"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// configHelp describes the settings there are for vir config get --help and vir config set --help.
+var configHelp = `Settings given with a flag or an environment variable win over the config file, and the config file
+   wins over vir's defaults. A * in a key stands for a name of your choosing, like a library's. Put -- before the
+   key to set a value that starts with a minus.
+
+   The settings are:
+     ` + strings.Replace(config.Usage(), "\n", "\n     ", -1)
+
+// actionConfigGet is the CLI action for config get
+func actionConfigGet(cliCtx *cli.Context) virErrors.ScopedError {
+	if cliCtx.NArg() != 1 {
+		return virErrors.ErrInvalidArguments("vir/cmd/vir.actionConfigGet", "expected the key of a setting")
+	}
+
+	c, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	value, isDefault, err := c.Get(cliCtx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if isDefault {
+		fmt.Printf("%s (default)\n", value)
+	} else {
+		fmt.Println(value)
+	}
+	return nil
+}
+
+// actionConfigList is the CLI action for config list
+func actionConfigList(_ *cli.Context) virErrors.ScopedError {
+	c, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	keys := c.Keys()
+	if len(keys) == 0 {
+		path, err := config.Path()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("nothing is set in %s\n", path)
+		return nil
+	}
+
+	for _, key := range keys {
+		value, _, err := c.Get(key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s = %s\n", key, value)
+	}
+	return nil
+}
+
+// actionConfigSet is the CLI action for config set
+func actionConfigSet(cliCtx *cli.Context) virErrors.ScopedError {
+	if cliCtx.NArg() < 1 {
+		return virErrors.ErrInvalidArguments("vir/cmd/vir.actionConfigSet", "expected the key of a setting and its value")
+	}
+
+	key, values := cliCtx.Args().First(), cliCtx.Args().Tail()
+	if err := config.Set(key, values); err != nil {
+		return err
+	}
+
+	fmt.Printf("set %s to %s\n", key, strings.Join(values, ", "))
+	return nil
+}
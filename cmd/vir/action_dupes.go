@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/dupes"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionDupes is the CLI action for dupes
+func actionDupes(ctx *virContext, _ *cli.Context) virErrors.ScopedError {
+	idx, err := ctx.loadIndex()
+
+	if err != nil {
+		return err
+	}
+
+	// the audio hashes are kept in the index, so only new and changed files are read for them
+	updateReport, err := idx.Update(ctx.runCtx, index.ScanOptions{
+		Jobs:      ctx.jobs,
+		HashAudio: true,
+		KeepGoing: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	groups, problems, err := dupes.Find(ctx.runCtx, entries, dupes.Options{Jobs: ctx.jobs})
+	if err != nil {
+		return err
+	}
+
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%d files):\n", g.Kind, len(g.Entries))
+		best := g.Best()
+		for _, e := range g.Entries {
+			marker := " "
+			if e.RelFilename == best.RelFilename {
+				marker = "*"
+			}
+			fmt.Printf("%s %10d bytes  %s  %s\n", marker, e.Size, describeStream(e.Stream), e.RelFilename)
+		}
+	}
+
+	return reportProblems(append(updateReport.Problems, problems...))
+}
+
+// describeStream describes the bitrate and kind of a stream in a fixed width, so that the paths after it line up.
+func describeStream(s track.StreamInfo) string {
+	if s.Bitrate == 0 {
+		return "   ? kbps         "
+	}
+
+	kind := "CBR"
+	switch {
+	case s.Lossless:
+		kind = "lossless"
+	case s.VBR:
+		kind = "VBR"
+	}
+
+	return fmt.Sprintf("%4d kbps %-8s", s.Bitrate, kind)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/query"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionFind is the CLI action for find
+func actionFind(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	out, err := newOutput(ctx)
+	if err != nil {
+		return err
+	}
+
+	q, err := query.ParseArgs(cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	idx, err := ctx.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range q.Filter(entries) {
+		if err = out.write(trackRecord{e}); err != nil {
+			return err
+		}
+	}
+
+	return out.flush()
+}
+
+// queryHelp describes the query language for vir find --help.
+const queryHelp = `A query is a list of terms, all of which a track has to match:
+
+     artist:"Boards of Canada" year:1995..2002 -genre:live missing:albumartist bitrate:<192
+
+   A word on its own is looked for in the title, artist, album and album artist. A minus in front of a term
+   matches the tracks it doesn't; put -- before the query so that it isn't taken for a flag.
+
+   Text keys match anywhere in the field, ignoring case, or the whole field with key:=value:
+     title artist album albumartist genre composer comment isrc path format encoder
+   Number keys take N, N..M, N.., ..M, <N, <=N, >N or >=N; durations are in seconds or minutes:seconds:
+     year track disc bpm bitrate samplerate channels duration
+   Flag keys take yes or no:
+     compilation lossless vbr damaged
+   missing:key matches tracks without a value for a text or number key, and missing:art those without pictures.`
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/query"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionIncomplete is the CLI action for incomplete
+func actionIncomplete(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	out, err := newOutput(ctx)
+	if err != nil {
+		return err
+	}
+
+	q, err := query.ParseArgs(cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	idx, err := ctx.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range index.GroupAlbums(entries) {
+		if a.IsComplete() || (!q.IsEmpty() && len(q.Filter(a.Tracks)) == 0) {
+			continue
+		}
+		if err = out.write(incompleteRecord{a}); err != nil {
+			return err
+		}
+	}
+
+	return out.flush()
+}
+
+// incompleteRecord is an album with tracks or discs missing, or with numbering that doesn't add up.
+type incompleteRecord struct {
+	index.Album
+}
+
+// text describes the album on one line, followed by a line for each kind of problem with it.
+func (r incompleteRecord) text() string {
+	multiDisc := r.Discs > 1
+	lines := []string{fmt.Sprintf("%s: %d track(s)", describeAlbum(r.Album), len(r.Tracks))}
+	add := func(what string, described []string) {
+		if len(described) > 0 {
+			lines = append(lines, fmt.Sprintf("  %s: %s", what, strings.Join(described, ", ")))
+		}
+	}
+
+	add("missing", describePositionsOf(r.MissingTracks, multiDisc))
+	for _, d := range r.DuplicateTracks {
+		names := make([]string, len(d.RelFilenames))
+		for i, path := range d.RelFilenames {
+			names[i] = filepath.Base(path)
+		}
+		add("duplicated "+describePosition(d.TrackPosition, multiDisc), names)
+	}
+	add("beyond the track total", describePositionsOf(r.ExtraTracks, multiDisc))
+	add("missing discs", describeDiscs(r.MissingDiscs, r.Discs))
+	add("beyond the disc total", describeDiscs(r.ExtraDiscs, 0))
+
+	return strings.Join(lines, "\n")
+}
+
+func (r incompleteRecord) columns() []string {
+	return []string{"dir", "artist", "album", "year", "tracks", "missing", "duplicated", "beyond-total",
+		"missing-discs", "beyond-disc-total"}
+}
+
+func (r incompleteRecord) values() []string {
+	multiDisc := r.Discs > 1
+	var duplicated []index.TrackPosition
+	for _, d := range r.DuplicateTracks {
+		duplicated = append(duplicated, d.TrackPosition)
+	}
+	return []string{r.Dir, r.Artist, r.Title, positiveString(r.Year), strconv.Itoa(len(r.Tracks)),
+		strings.Join(describePositions(r.MissingTracks, multiDisc), ", "),
+		strings.Join(describePositions(duplicated, multiDisc), ", "),
+		strings.Join(describePositions(r.ExtraTracks, multiDisc), ", "),
+		strings.Join(describeDiscs(r.MissingDiscs, 0), ", "),
+		strings.Join(describeDiscs(r.ExtraDiscs, 0), ", ")}
+}
+
+// describePositionsOf lists track positions like describePositions does, adding the track total of each disc the
+// tags give one for, e.g. "3-5 of 12".
+func describePositionsOf(positions []index.TrackPosition, multiDisc bool) []string {
+	var described []string
+	for i := 0; i < len(positions); {
+		j := i
+		for j < len(positions) && positions[j].Disc == positions[i].Disc {
+			j++
+		}
+
+		disc := describePositions(positions[i:j], multiDisc)[0]
+		if positions[i].Total > 0 {
+			disc += fmt.Sprintf(" of %d", positions[i].Total)
+		}
+		described = append(described, disc)
+		i = j
+	}
+	return described
+}
+
+// describePosition names a single track position, e.g. "track 3" or "disc 2 track 3".
+func describePosition(p index.TrackPosition, multiDisc bool) string {
+	if multiDisc {
+		return fmt.Sprintf("disc %d track %d", p.Disc, p.Number)
+	}
+	return fmt.Sprintf("track %d", p.Number)
+}
+
+// describeDiscs lists disc numbers, adding the number of discs if it is given.
+func describeDiscs(discs []int, of int) []string {
+	if len(discs) == 0 {
+		return nil
+	}
+	described := []string{describeRanges(discs)}
+	if of > 0 {
+		described[0] += fmt.Sprintf(" of %d", of)
+	}
+	return described
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/lint"
+	"github.com/ceralena/vir/query"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionLint is the CLI action for lint
+func actionLint(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	out, err := newOutput(ctx)
+	if err != nil {
+		return err
+	}
+
+	q, err := query.ParseArgs(cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	idx, err := ctx.loadIndex()
+
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	// the rules still see every track, so that the ones comparing the tracks in a directory aren't thrown by only
+	// being shown some of them
+	selected := make(map[string]bool)
+	for _, e := range q.Filter(entries) {
+		selected[e.RelFilename] = true
+	}
+
+	for _, f := range lint.Run(entries, lint.WithSeverities(lint.DefaultRules(), ctx.config.LintSeverities)) {
+		if !selected[f.RelFilename] {
+			continue
+		}
+		if err = out.write(findingRecord{f}); err != nil {
+			return err
+		}
+	}
+
+	return out.flush()
+}
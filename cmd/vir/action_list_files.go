@@ -1,30 +1,52 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/urfave/cli"
 
 	"github.com/ceralena/vir/index"
 	"github.com/ceralena/vir/virErrors"
 )
 
-func actionListFiles(ctx *virContext, _ *cli.Context) virErrors.ScopedError {
-	idx, err := index.LoadIndex(ctx.musicLibraryRoot)
+func actionListFiles(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	out, err := newOutput(ctx)
+	if err != nil {
+		return err
+	}
+
+	idx, err := ctx.loadIndex()
 
 	if err != nil {
 		return err
 	}
 
-	fileEntries := idx.ListMusicFiles()
+	fileEntries := idx.ListMusicFiles(ctx.runCtx)
+	if cliCtx.Bool("sidecars") {
+		fileEntries = idx.ListSidecarFiles(ctx.runCtx)
+	}
+	keepGoing := cliCtx.Bool("keep-going")
+	var problems []index.Problem
 
 	for fileEntry := range fileEntries {
-		if fileEntry.Error != nil {
+		if fileEntry.Error != nil && keepGoing {
+			problems = append(problems, fileEntry.Problem())
+			continue
+		} else if fileEntry.Error != nil {
+			// whatever was listed before the error still goes out
+			_ = out.flush()
 			fatal(fileEntry.Error)
 			break
 		}
-		fmt.Println(fileEntry.RelFilename)
+		if err = out.write(fileRecord{Path: fileEntry.RelFilename}); err != nil {
+			return err
+		}
+	}
+	if err = out.flush(); err != nil {
+		return err
+	}
+
+	if ctx.runCtx.Err() != nil {
+		return virErrors.ErrInterrupted("vir/cmd/vir.actionListFiles")
 	}
 
-	return nil
+	return reportProblems(problems)
 }
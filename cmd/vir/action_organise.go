@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/organise"
+	"github.com/ceralena/vir/query"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionOrganise is the CLI action for organise
+func actionOrganise(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	tmpl, err := organise.ParseTemplate(organiseTemplate(ctx, cliCtx))
+	if err != nil {
+		return err
+	}
+
+	q, err := query.ParseArgs(cliCtx.Args())
+	if err != nil {
+		return err
+	}
+
+	idx, err := ctx.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	// plan from what is actually on disk, not whatever was there when the index was last updated
+	scanOpts := index.ScanOptions{Jobs: ctx.jobs}
+	if _, err = idx.Update(ctx.runCtx, scanOpts); err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	var sidecars []string
+	for fileEntry := range idx.ListSidecarFiles(ctx.runCtx) {
+		if fileEntry.Error != nil {
+			return fileEntry.Error
+		}
+		sidecars = append(sidecars, fileEntry.RelFilename)
+	}
+	if ctx.runCtx.Err() != nil {
+		return virErrors.ErrInterrupted("vir/cmd/vir.actionOrganise")
+	}
+
+	// tracks only ever move within the root they are in, so each root gets a plan of its own
+	executed := false
+	roots := idx.Roots()
+	for _, root := range roots {
+		if len(roots) > 1 {
+			fmt.Printf("in %s:\n", root.Dir)
+		}
+
+		plan, skipped := organise.MakePlan(root.Dir, rootEntries(root, entries), rootPaths(root, sidecars), organise.Options{
+			Template: tmpl,
+			ASCII:    organiseASCII(ctx, cliCtx),
+			Query:    q,
+		})
+
+		for _, s := range skipped {
+			fmt.Printf("skip %s: %s\n", root.LibraryRelPath(s.RelFilename), s.Reason)
+		}
+
+		ran, planErr := runPlan(ctx, cliCtx, plan)
+		executed = executed || ran
+		if planErr != nil {
+			err = planErr
+			break
+		}
+	}
+	if !executed {
+		return err
+	}
+
+	// keep the index in step with the files we moved, even if we didn't get through all of them
+	_, updateErr := idx.Update(ctx.runCtx, scanOpts)
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// organiseASCII returns whether --ascii was given, or if it wasn't, what the config file says.
+func organiseASCII(ctx *virContext, cliCtx *cli.Context) bool {
+	if cliCtx.IsSet("ascii") {
+		return cliCtx.Bool("ascii")
+	}
+	return ctx.config.OrganiseASCII
+}
+
+// organiseTemplate returns the template given with --template, or the one in the config file with the name it gives,
+// or failing that the default template in the config file.
+func organiseTemplate(ctx *virContext, cliCtx *cli.Context) string {
+	if !cliCtx.IsSet("template") {
+		return ctx.config.OrganiseTemplate
+	}
+	if tmpl, ok := ctx.config.OrganiseTemplates[cliCtx.String("template")]; ok {
+		return tmpl
+	}
+	return cliCtx.String("template")
+}
+
+// rootEntries returns the entries for the tracks in a root, with their RelFilenames relative to it.
+func rootEntries(root index.Root, entries []index.Entry) []index.Entry {
+	var inRoot []index.Entry
+	for _, e := range entries {
+		if rel, ok := rootPath(root, e.RelFilename); ok {
+			e.RelFilename = rel
+			inRoot = append(inRoot, e)
+		}
+	}
+	return inRoot
+}
+
+// rootPaths returns the paths in the library that are in a root, relative to it.
+func rootPaths(root index.Root, paths []string) []string {
+	var inRoot []string
+	for _, path := range paths {
+		if rel, ok := rootPath(root, path); ok {
+			inRoot = append(inRoot, rel)
+		}
+	}
+	return inRoot
+}
+
+func rootPath(root index.Root, path string) (string, bool) {
+	if root.Label != "" && !strings.HasPrefix(path, root.Label+string(filepath.Separator)) {
+		return "", false
+	}
+	return root.RelPath(path), true
+}
@@ -8,13 +8,20 @@ import (
 )
 
 // actionRebuildIndex is the CLI action for rebuild-index
-func actionRebuildIndex(ctx *virContext, _ *cli.Context) virErrors.ScopedError {
-	idx, err := index.LoadIndex(ctx.musicLibraryRoot)
+func actionRebuildIndex(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	idx, err := ctx.loadIndex()
 
 	if err != nil {
 		return err
 	}
 
-	err = idx.Rebuild()
-	return err
+	report, err := idx.Rebuild(ctx.runCtx, index.ScanOptions{
+		Jobs:      ctx.jobs,
+		KeepGoing: cliCtx.Bool("keep-going"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return printUpdateReport(report)
 }
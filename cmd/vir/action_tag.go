@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/fileops"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/query"
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// tagSetFlags has a flag for every writable field, plus --query for bulk edits.
+func tagSetFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, f := range track.Fields {
+		flags = append(flags, cli.StringFlag{
+			Name:  string(f),
+			Usage: "set the " + string(f) + "; an empty value removes it",
+		})
+	}
+
+	flags = append(flags, cli.StringFlag{
+		Name:  "query, q",
+		Usage: "instead of naming files, edit every indexed track matching the query (see vir find)",
+	})
+
+	return append(flags, planFlags...)
+}
+
+// tagEdit is a single field to change, as given on the command line.
+type tagEdit struct {
+	field track.Field
+	value string
+}
+
+// tagChange is the set of edits that actually change a track.
+type tagChange struct {
+	fullPath string
+	before   track.Metadata
+	after    track.Metadata
+	fields   []track.Field
+}
+
+// actionTagSet is the CLI action for tag set
+func actionTagSet(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	edits, err := parseTagEdits(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	hasQuery := cliCtx.IsSet("query")
+	if hasQuery && cliCtx.NArg() > 0 {
+		return virErrors.ErrInvalidTrackSelection("vir/cmd/vir.actionTagSet", "give either files or --query, not both")
+	} else if !hasQuery && cliCtx.NArg() == 0 {
+		return virErrors.ErrInvalidTrackSelection("vir/cmd/vir.actionTagSet", "give the files to edit, or --query to select them from the index")
+	}
+
+	var changes []tagChange
+	var idx index.Index
+	if hasQuery {
+		var q *query.Query
+		if q, err = query.Parse(cliCtx.String("query")); err != nil {
+			return err
+		}
+		if q.IsEmpty() {
+			return virErrors.ErrInvalidTrackSelection("vir/cmd/vir.actionTagSet", "--query has to have at least one term")
+		}
+		idx, err = ctx.loadIndex()
+		if err != nil {
+			return err
+		}
+		changes, err = tagChangesForQuery(ctx, idx, q, edits, cliCtx.Bool("dry-run"))
+	} else {
+		changes, err = tagChangesForFiles(cliCtx.Args(), edits)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the journal keeps what the fields were before, so that vir undo can put them back
+	plan := fileops.NewPlan("", "tag set")
+	for _, c := range changes {
+		plan.AddSetTags(c.fullPath, c.before, c.after, c.fields)
+	}
+
+	ran, err := runPlan(ctx, cliCtx, plan)
+	if idx == nil || !ran {
+		return err
+	}
+
+	// keep the index in step with the files we wrote, even if we didn't get through all of them
+	_, updateErr := idx.Update(ctx.runCtx, index.ScanOptions{Jobs: ctx.jobs})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// parseTagEdits collects the field flags that were given, checking that each value parses.
+func parseTagEdits(cliCtx *cli.Context) ([]tagEdit, virErrors.ScopedError) {
+	var edits []tagEdit
+	for _, f := range track.Fields {
+		if !cliCtx.IsSet(string(f)) {
+			continue
+		}
+
+		value := cliCtx.String(string(f))
+		var m track.Metadata
+		if err := m.Set(f, value); err != nil {
+			return nil, virErrors.ErrInvalidTagValue("vir/cmd/vir.parseTagEdits", string(f), value, err)
+		}
+		edits = append(edits, tagEdit{f, value})
+	}
+
+	if len(edits) == 0 {
+		return nil, virErrors.ErrInvalidTrackSelection("vir/cmd/vir.parseTagEdits", "no fields to set")
+	}
+
+	return edits, nil
+}
+
+func tagChangesForFiles(paths []string, edits []tagEdit) ([]tagChange, virErrors.ScopedError) {
+	var changes []tagChange
+	for _, path := range paths {
+		tr, err := track.LoadTrackFromPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if !tr.Format.CanWriteTags() {
+			return nil, virErrors.ErrTrackTagWriteUnsupported("vir/cmd/vir.tagChangesForFiles", path, string(tr.Format))
+		}
+		if c, ok := makeTagChange(tr, edits); ok {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// tagChangesForQuery selects the tracks matching a query from an up-to-date index. A dry run mustn't change anything,
+// the index included, so it goes by the index as it is.
+func tagChangesForQuery(ctx *virContext, idx index.Index, q *query.Query, edits []tagEdit, dryRun bool) ([]tagChange, virErrors.ScopedError) {
+	if !dryRun {
+		if _, err := idx.Update(ctx.runCtx, index.ScanOptions{Jobs: ctx.jobs}); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []tagChange
+	for _, e := range q.Filter(entries) {
+		if !e.Format.CanWriteTags() {
+			fmt.Printf("skip %s: writing tags to %s files is not supported\n", e.RelFilename, e.Format)
+			continue
+		}
+		if c, ok := makeTagChange(&e.Track, edits); ok {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// makeTagChange applies the edits to a track, reporting false if none of them change anything.
+func makeTagChange(tr *track.Track, edits []tagEdit) (tagChange, bool) {
+	c := tagChange{fullPath: journalPath(tr.FullPath), before: tr.Metadata, after: tr.Metadata}
+
+	for _, edit := range edits {
+		// the values were checked by parseTagEdits
+		_ = c.after.Set(edit.field, edit.value)
+		if c.after.Get(edit.field) != c.before.Get(edit.field) {
+			c.fields = append(c.fields, edit.field)
+		}
+	}
+
+	return c, len(c.fields) > 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/fileops"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionUndo is the CLI action for undo
+func actionUndo(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	journal, err := fileops.OpenJournal()
+	if err != nil {
+		return err
+	}
+
+	if cliCtx.Bool("dry-run") {
+		batch, ops, err := fileops.CheckUndo(journal, cliCtx.Args().First())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("would undo batch %s (%s):\n", batch.ID, batch.Description)
+		fileops.PrintUndo(os.Stdout, ops)
+		return nil
+	}
+
+	batch, err := fileops.Undo(journal, cliCtx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("undid batch %s (%s)\n", batch.ID, batch.Description)
+	return ctx.updateIndexesFor(batch)
+}
+
+// updateIndexesFor brings the indexes of the libraries a batch touched files in up to date, so they don't go on
+// showing files as they were before an undo. Libraries that haven't been indexed yet are left that way.
+func (ctx *virContext) updateIndexesFor(batch *fileops.Batch) virErrors.ScopedError {
+	// a batch with a root is confined to it; one without works on files given by their full paths
+	paths := []string{batch.Root}
+	if batch.Root == "" {
+		paths = nil
+		for _, op := range batch.Ops {
+			paths = append(paths, op.From)
+		}
+	}
+
+	var libs []index.Library
+	if batch.Root != "" {
+		libs = append(libs, index.Library{Roots: []string{batch.Root}})
+	}
+	if lib, err := ctx.resolveLibrary(); err == nil {
+		libs = append(libs, lib)
+	}
+	for _, name := range ctx.config.LibraryNames() {
+		libs = append(libs, index.Library{Name: name, Roots: ctx.config.Libraries[name].Roots})
+	}
+
+	seen := make(map[string]bool)
+	for _, lib := range libs {
+		key := lib.Name + "\x00" + strings.Join(lib.Roots, "\x00")
+		if seen[key] || !coversAny(lib, paths) {
+			continue
+		}
+		seen[key] = true
+
+		idx, err := index.LoadLibrary(lib, ctx.indexOptions)
+		if err != nil {
+			return err
+		}
+		if _, err := idx.Entries(); err != nil {
+			// not indexed yet, which an undo is no reason to change
+			continue
+		}
+		if _, err := idx.Update(ctx.runCtx, index.ScanOptions{Jobs: ctx.jobs}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coversAny reports whether any of the paths is one of a library's roots or inside one.
+func coversAny(lib index.Library, paths []string) bool {
+	for _, root := range lib.Roots {
+		root = filepath.Clean(root)
+		for _, path := range paths {
+			path = filepath.Clean(path)
+			if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// actionJournal is the CLI action for journal
+func actionJournal(_ *virContext, _ *cli.Context) virErrors.ScopedError {
+	journal, err := fileops.OpenJournal()
+	if err != nil {
+		return err
+	}
+
+	batches, err := journal.Batches()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range batches {
+		status := ""
+		if b.Undone {
+			status = " (undone)"
+		} else if !b.Complete {
+			status = " (incomplete)"
+		}
+		where := ""
+		if b.Root != "" {
+			where = " in " + b.Root
+		}
+		fmt.Printf("%s  %s  %s: %d operation(s)%s%s\n",
+			b.ID, b.Time.Format("2006-01-02 15:04:05"), b.Description, len(b.Ops), where, status)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionUpdateIndex is the CLI action for update-index
+func actionUpdateIndex(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	idx, err := ctx.loadIndex()
+
+	if err != nil {
+		return err
+	}
+
+	report, err := idx.Update(ctx.runCtx, index.ScanOptions{
+		Jobs:         ctx.jobs,
+		HashContents: cliCtx.Bool("hash"),
+		KeepGoing:    cliCtx.Bool("keep-going"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return printUpdateReport(report)
+}
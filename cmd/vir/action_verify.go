@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// actionVerify is the CLI action for verify
+func actionVerify(ctx *virContext, cliCtx *cli.Context) virErrors.ScopedError {
+	idx, err := ctx.loadIndex()
+
+	if err != nil {
+		return err
+	}
+
+	// changed files lose their verification, so bring the index up to date first to check them again
+	updateReport, err := idx.Update(ctx.runCtx, index.ScanOptions{
+		Jobs:      ctx.jobs,
+		KeepGoing: cliCtx.Bool("keep-going"),
+	})
+	if err != nil {
+		return err
+	}
+
+	report, err := idx.Verify(ctx.runCtx, index.VerifyOptions{
+		Jobs:      ctx.jobs,
+		Recheck:   cliCtx.Bool("recheck"),
+		KeepGoing: cliCtx.Bool("keep-going"),
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err := idx.Entries()
+	if err != nil {
+		return err
+	}
+
+	damaged := 0
+	for _, e := range entries {
+		if e.Verification == nil || e.Verification.OK() {
+			continue
+		}
+		damaged++
+		for _, issue := range e.Verification.Issues {
+			fmt.Printf("%s: %s\n", e.RelFilename, describeStreamIssue(issue))
+		}
+	}
+
+	fmt.Printf("verified: %d, already verified: %d, unsupported: %d, damaged: %d\n",
+		report.Verified, report.Skipped, report.Unsupported, damaged)
+
+	return reportProblems(append(updateReport.Problems, report.Problems...))
+}
+
+func describeStreamIssue(issue track.StreamIssue) string {
+	if issue.Count > 1 {
+		return fmt.Sprintf("%s: %s (and %d more)", issue.Kind, issue.Message, issue.Count-1)
+	}
+	return fmt.Sprintf("%s: %s", issue.Kind, issue.Message)
+}
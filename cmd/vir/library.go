@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/config"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// loadIndex loads the index of the library vir was asked to work on: the one named with --library, or failing that
+// the directory given with --music-root, or failing that the default library in the config file.
+func (ctx *virContext) loadIndex() (index.Index, virErrors.ScopedError) {
+	lib, err := ctx.resolveLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return index.LoadLibrary(lib, ctx.indexOptions)
+}
+
+func (ctx *virContext) resolveLibrary() (index.Library, virErrors.ScopedError) {
+	if ctx.libraryName == "" && ctx.musicRoot != "" {
+		return index.Library{Roots: []string{ctx.musicRoot}}, nil
+	}
+
+	c := ctx.config
+	name := ctx.libraryName
+	if name == "" {
+		name = c.Library
+	}
+	if name == "" {
+		return index.Library{}, virErrors.ErrMusicLibraryRootNotGiven("vir/cmd/vir.resolveLibrary")
+	}
+
+	lib, ok := c.Libraries[name]
+	if !ok {
+		return index.Library{}, virErrors.ErrUnknownLibrary("vir/cmd/vir.resolveLibrary", name, c.LibraryNames())
+	}
+	return index.Library{Name: name, Roots: lib.Roots}, nil
+}
+
+// actionLibraries is the CLI action for libraries
+func actionLibraries(ctx *virContext, _ *cli.Context) virErrors.ScopedError {
+	c := ctx.config
+	names := c.LibraryNames()
+	if len(names) == 0 {
+		path, err := config.Path()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("no libraries are configured in %s\n", path)
+		return nil
+	}
+
+	for _, name := range names {
+		marker := " "
+		if name == c.Library {
+			marker = "*"
+		}
+		fmt.Printf("%s %s: %s\n", marker, name, strings.Join(c.Libraries[name].Roots, ", "))
+	}
+	return nil
+}
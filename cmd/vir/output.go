@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/lint"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// The output formats for commands that list things, given with --format. Anything else is taken to be a
+// text/template, which is run on each record in turn.
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatTSV  = "tsv"
+)
+
+// record is a single thing a command lists, like a file or a lint finding.
+//
+// The JSON format and templates work on the record itself, so its exported fields are what scripts get to see.
+type record interface {
+	// text is the line the text format prints.
+	text() string
+
+	// columns names the fields the CSV and TSV formats print, in order; values has them for this record.
+	columns() []string
+	values() []string
+}
+
+// output writes the records a command lists in the format chosen with --format.
+type output struct {
+	w      *bufio.Writer
+	format string
+	tmpl   *template.Template
+
+	csv         *csv.Writer
+	wroteHeader bool
+}
+
+// newOutput checks the format the user asked for, returning an output that writes to stdout.
+func newOutput(ctx *virContext) (*output, virErrors.ScopedError) {
+	o := &output{w: bufio.NewWriter(os.Stdout), format: ctx.format}
+
+	switch ctx.format {
+	case formatText, formatJSON:
+	case formatCSV, formatTSV:
+		o.csv = csv.NewWriter(o.w)
+		if ctx.format == formatTSV {
+			o.csv.Comma = '\t'
+		}
+	default:
+		if !strings.Contains(ctx.format, "{{") {
+			return nil, virErrors.ErrInvalidOutputFormat("vir/cmd/vir.newOutput", ctx.format,
+				fmt.Errorf("expected text, json, csv, tsv or a template"))
+		}
+		tmpl, err := template.New("format").Option("missingkey=error").Parse(ctx.format)
+		if err != nil {
+			return nil, virErrors.ErrInvalidOutputFormat("vir/cmd/vir.newOutput", ctx.format, err)
+		}
+		o.tmpl = tmpl
+	}
+
+	return o, nil
+}
+
+// write writes a record. CSV and TSV output starts with a header naming the columns of the first record.
+func (o *output) write(r record) virErrors.ScopedError {
+	var err error
+
+	switch {
+	case o.tmpl != nil:
+		// the writer is buffered, so an error here is nearly always from the template itself
+		if err = o.tmpl.Execute(o.w, r); err != nil {
+			return virErrors.ErrInvalidOutputFormat("vir/cmd/vir.output.write", o.format, err)
+		}
+		err = o.w.WriteByte('\n')
+	case o.csv != nil:
+		if !o.wroteHeader {
+			err = o.csv.Write(r.columns())
+			o.wroteHeader = true
+		}
+		if err == nil {
+			err = o.csv.Write(r.values())
+		}
+	case o.format == formatJSON:
+		err = json.NewEncoder(o.w).Encode(r)
+	default:
+		_, err = fmt.Fprintln(o.w, r.text())
+	}
+
+	if err != nil {
+		return virErrors.ErrOutputWriteFailed("vir/cmd/vir.output.write", err)
+	}
+	return nil
+}
+
+// flush writes out anything still buffered. It has to be called once the command has written every record.
+func (o *output) flush() virErrors.ScopedError {
+	var err error
+	if o.csv != nil {
+		o.csv.Flush()
+		err = o.csv.Error()
+	}
+	if err == nil {
+		err = o.w.Flush()
+	}
+	if err != nil {
+		return virErrors.ErrOutputWriteFailed("vir/cmd/vir.output.flush", err)
+	}
+	return nil
+}
+
+// fileRecord is a file listed by list-files.
+type fileRecord struct {
+	Path string
+}
+
+func (r fileRecord) text() string      { return r.Path }
+func (r fileRecord) columns() []string { return []string{"path"} }
+func (r fileRecord) values() []string  { return []string{r.Path} }
+
+// trackRecord is an indexed track, with all of its fields.
+type trackRecord struct {
+	index.Entry
+}
+
+func (r trackRecord) text() string { return r.RelFilename }
+
+func (r trackRecord) columns() []string {
+	return []string{"path", "title", "artist", "album", "albumartist", "track", "disc", "year", "genre", "format",
+		"duration", "bitrate"}
+}
+
+func (r trackRecord) values() []string {
+	return []string{r.RelFilename, r.Title, r.Artist, r.Album, r.AlbumArtist, positiveString(r.Number),
+		positiveString(r.DiscNumber), positiveString(r.Year), r.Genre, string(r.Format),
+		positiveString(int(r.Stream.Duration.Seconds() + 0.5)), positiveString(r.Stream.Bitrate)}
+}
+
+// findingRecord is a lint finding.
+type findingRecord struct {
+	lint.Finding
+}
+
+func (r findingRecord) text() string {
+	return fmt.Sprintf("%s: %s [%s] %s", r.RelFilename, r.Severity, r.RuleID, r.Message)
+}
+
+func (r findingRecord) columns() []string { return []string{"path", "severity", "rule", "message"} }
+
+func (r findingRecord) values() []string {
+	return []string{r.RelFilename, string(r.Severity), r.RuleID, r.Message}
+}
+
+// positiveString formats a number for CSV and TSV output, leaving it empty if the track doesn't have one.
+func positiveString(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
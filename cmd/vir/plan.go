@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/fileops"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// planFlags are shared by every command that changes files in the music library.
+var planFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "dry-run, n",
+		Usage: "only show what would be done",
+	},
+	cli.BoolFlag{
+		Name:  "yes, y",
+		Usage: "don't ask for confirmation",
+	},
+}
+
+// runPlan shows a plan and, unless this is a dry run or the user declines, executes it and records it in the
+// journal. It reports whether the plan was executed.
+func runPlan(ctx *virContext, cliCtx *cli.Context, plan *fileops.Plan) (bool, virErrors.ScopedError) {
+	plan.Print(os.Stdout)
+
+	if len(plan.Ops) == 0 {
+		fmt.Println("nothing to do")
+		return false, nil
+	}
+
+	if cliCtx.Bool("dry-run") {
+		return false, nil
+	}
+
+	if !cliCtx.Bool("yes") && !confirm(fmt.Sprintf("%s: carry out %d operation(s)?", plan.Description, len(plan.Ops))) {
+		return false, nil
+	}
+
+	journal, err := fileops.OpenJournal()
+	if err != nil {
+		return false, err
+	}
+
+	batch, err := fileops.Execute(ctx.runCtx, plan, journal)
+	if batch != nil {
+		fmt.Printf("recorded as batch %s; run vir undo %s to revert it\n", batch.ID, batch.ID)
+	}
+	if err != nil {
+		return batch != nil, err
+	}
+
+	return true, nil
+}
+
+// journalPath returns the absolute form of a path for a plan without a root, so that vir undo can find the file again
+// from wherever it is run.
+func journalPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
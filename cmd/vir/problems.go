@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/urfave/cli"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// keepGoingFlag is shared by every command that can skip unreadable files instead of giving up.
+var keepGoingFlag = cli.BoolFlag{
+	Name:  "keep-going, k",
+	Usage: "skip files that can't be read and report them at the end, instead of stopping at the first one",
+}
+
+// reportProblems prints a summary of the problems grouped by kind to stderr.
+//
+// It returns an error for the process to exit with if there were any problems.
+func reportProblems(problems []index.Problem) virErrors.ScopedError {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	byKind := make(map[index.ProblemKind][]index.Problem)
+	var kinds []string
+	for _, p := range problems {
+		if _, ok := byKind[p.Kind]; !ok {
+			kinds = append(kinds, string(p.Kind))
+		}
+		byKind[p.Kind] = append(byKind[p.Kind], p)
+	}
+	sort.Strings(kinds)
+
+	fmt.Fprintf(os.Stderr, "%d problem(s):\n", len(problems))
+	for _, kind := range kinds {
+		kindProblems := byKind[index.ProblemKind(kind)]
+		fmt.Fprintf(os.Stderr, "  %s (%d):\n", kind, len(kindProblems))
+		for _, p := range kindProblems {
+			fmt.Fprintf(os.Stderr, "    %s\n", p.Error)
+		}
+	}
+
+	return virErrors.ErrCompletedWithProblems("vir/cmd/vir.reportProblems", len(problems))
+}
+
+func printUpdateReport(report *index.UpdateReport) virErrors.ScopedError {
+	fmt.Printf("added: %d, changed: %d, removed: %d, unchanged: %d\n",
+		report.Added, report.Changed, report.Removed, report.Unchanged)
+
+	return reportProblems(report.Problems)
+}
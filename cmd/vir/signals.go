@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// interruptContext returns a context that is cancelled the first time vir is interrupted, so that long-running
+// operations can stop cleanly. A second interrupt kills vir straight away.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		cancel()
+	}()
+
+	return ctx
+}
@@ -3,18 +3,34 @@ package main
 import (
 	"github.com/urfave/cli"
 
+	"context"
 	"fmt"
 	"os"
 	"sort"
 	"syscall"
 
+	"github.com/ceralena/vir/config"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/organise"
 	"github.com/ceralena/vir/virErrors"
 )
 
+// Exit codes: a fatal error stopped vir, or vir finished but skipped some files.
+const (
+	exitFatal    = 1
+	exitProblems = 2
+)
+
 func fatal(err error) {
 	fmt.Println("error: " + err.Error())
 	// XXX(cera) - is this appropriate for all platforms, including windows?
-	syscall.Exit(1)
+	syscall.Exit(exitFatal)
+}
+
+// exitWithProblems is like fatal, but for runs that completed after skipping some files.
+func exitWithProblems(err error) {
+	fmt.Println(err.Error())
+	syscall.Exit(exitProblems)
 }
 
 func main() {
@@ -31,9 +47,35 @@ func runCliApp(args []string) {
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "music-root, mr",
-			Usage:  "music root directory",
+			Usage:  "music root directory, for a library that isn't in the config file",
 			EnvVar: "VIR_MUSIC_ROOT",
 		},
+		cli.StringFlag{
+			Name:   "library, l",
+			Usage:  "name of the library in the config file to work on, instead of the default one",
+			EnvVar: "VIR_LIBRARY",
+		},
+		cli.StringSliceFlag{
+			Name:   "include",
+			Usage:  "only look at files matching this glob pattern (may be repeated)",
+			EnvVar: "VIR_INCLUDE",
+		},
+		cli.StringSliceFlag{
+			Name:   "exclude",
+			Usage:  "ignore files and directories matching this glob pattern (may be repeated)",
+			EnvVar: "VIR_EXCLUDE",
+		},
+		cli.StringFlag{
+			Name:   "format",
+			Value:  formatText,
+			Usage:  "output format for list-files, find, lint, albums and incomplete: text, json (one object per line), csv, tsv or a Go template like '{{.Artist}} - {{.Title}}'",
+			EnvVar: "VIR_FORMAT",
+		},
+		cli.IntFlag{
+			Name:   "jobs, j",
+			Usage:  "number of files to read concurrently (default: one per CPU)",
+			EnvVar: "VIR_JOBS",
+		},
 	}
 
 	app.Commands = []cli.Command{
@@ -42,12 +84,201 @@ func runCliApp(args []string) {
 			Aliases: []string{"ls"},
 			Usage:   "list all music files",
 			Action:  makeAction(actionListFiles),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "sidecars",
+					Usage: "list the non-audio files alongside the music instead, like cover art and cue sheets",
+				},
+				keepGoingFlag,
+			},
+		},
+		{
+			Name:      "albums",
+			Usage:     "list the albums the indexed tracks make up, or those with tracks matching a query",
+			ArgsUsage: "[--] [query]",
+			Action:    makeAction(actionAlbums),
+		},
+		{
+			Name:  "art",
+			Usage: "inspect, extract and embed the cover art in tracks",
+			Subcommands: []cli.Command{
+				{
+					Name:      "embed",
+					Usage:     "embed the cover image file in each directory as the front cover of its tracks",
+					ArgsUsage: "[dirs...]",
+					Action:    makeAction(actionArtEmbed),
+					Flags: append([]cli.Flag{
+						cli.IntFlag{
+							Name:  "max-size",
+							Value: defaultArtMaxSize,
+							Usage: "shrink images with a side longer than this many pixels; 0 embeds them as they are",
+						},
+						cli.IntFlag{
+							Name:  "quality",
+							Value: defaultArtQuality,
+							Usage: "the JPEG quality (1-100) to re-encode shrunk images at",
+						},
+						cli.BoolFlag{
+							Name:  "force, f",
+							Usage: "replace the front cover of tracks that already have one; vir undo puts it back",
+						},
+					}, planFlags...),
+				},
+				{
+					Name:      "show",
+					Usage:     "show the embedded art of each indexed track, and whether each directory shares one cover",
+					ArgsUsage: "[dirs...]",
+					Action:    makeAction(actionArtShow),
+				},
+				{
+					Name:      "extract",
+					Usage:     "write the front cover shared by the tracks in each directory to a cover file there",
+					ArgsUsage: "[dirs...]",
+					Action:    makeAction(actionArtExtract),
+					Flags: append([]cli.Flag{
+						cli.BoolFlag{
+							Name:  "force, f",
+							Usage: "replace cover files that already exist; vir undo puts them back",
+						},
+					}, planFlags...),
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "show and change the settings in the config file, ~/.vir/config",
+			Subcommands: []cli.Command{
+				{
+					Name:        "get",
+					Usage:       "print a setting, or vir's default for it if the config file doesn't have it",
+					ArgsUsage:   "<key>",
+					Description: configHelp,
+					Action:      makeConfigAction(actionConfigGet),
+				},
+				{
+					Name:   "list",
+					Usage:  "list the settings in the config file",
+					Action: makeConfigAction(actionConfigList),
+				},
+				{
+					Name:        "set",
+					Usage:       "change a setting in the config file; a list takes any number of values",
+					ArgsUsage:   "[--] <key> <value>...",
+					Description: configHelp,
+					Action:      makeConfigAction(actionConfigSet),
+				},
+			},
+		},
+		{
+			Name:   "dupes",
+			Usage:  "find duplicate tracks in the index",
+			Action: makeAction(actionDupes),
+		},
+		{
+			Name:        "find",
+			Usage:       "list the indexed tracks matching a query",
+			ArgsUsage:   "[--] [query]",
+			Description: queryHelp,
+			Action:      makeAction(actionFind),
+		},
+		{
+			Name:      "incomplete",
+			Usage:     "report albums with missing, duplicated or extra track numbers, or missing discs",
+			ArgsUsage: "[--] [query]",
+			Action:    makeAction(actionIncomplete),
+		},
+		{
+			Name:   "journal",
+			Usage:  "list the batches of file operations vir has carried out",
+			Action: makeAction(actionJournal),
+		},
+		{
+			Name:   "libraries",
+			Usage:  "list the libraries in the config file, marking the default one with *",
+			Action: makeAction(actionLibraries),
+		},
+		{
+			Name:      "lint",
+			Usage:     "check the indexed tracks, or those matching a query, for incomplete or inconsistent tags",
+			ArgsUsage: "[--] [query]",
+			Action:    makeAction(actionLint),
+		},
+		{
+			Name:      "organise",
+			Usage:     "move tracks, or those matching a query, into directories named from their tags",
+			ArgsUsage: "[--] [query]",
+			Action:    makeAction(actionOrganise),
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "template, t",
+					Usage: "path template for each track, relative to the root directory it is in, or the name of one in the config file",
+					Value: organise.DefaultTemplate,
+				},
+				cli.BoolFlag{
+					Name:  "ascii",
+					Usage: "only use ASCII characters in file names; --ascii=false overrides organise.ascii in the config file",
+				},
+			}, planFlags...),
 		},
 		{
 			Name:    "rebuild-index",
 			Aliases: []string{"r"},
 			Usage:   "rebuild the vir index",
 			Action:  makeAction(actionRebuildIndex),
+			Flags:   []cli.Flag{keepGoingFlag},
+		},
+		{
+			Name:  "tag",
+			Usage: "edit the tags of tracks",
+			Subcommands: []cli.Command{
+				{
+					Name:      "set",
+					Usage:     "set tag fields on the given files, or on every indexed track matching --query",
+					ArgsUsage: "[files...]",
+					Action:    makeAction(actionTagSet),
+					Flags:     tagSetFlags(),
+				},
+			},
+		},
+		{
+			Name:      "undo",
+			Usage:     "revert the last batch of file operations, or the one with the given id",
+			ArgsUsage: "[batch-id]",
+			Description: "The files moved by organise, the tags written by tag set, the cover files written by art extract\n" +
+				"   and the pictures embedded by art embed are recorded in the journal, which vir journal lists;\n" +
+				"   nothing else vir does can be undone.",
+			Action: makeAction(actionUndo),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "only show what would be reverted",
+				},
+			},
+		},
+		{
+			Name:   "verify",
+			Usage:  "check the audio streams of the indexed tracks for damage, skipping those already checked",
+			Action: makeAction(actionVerify),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "recheck",
+					Usage: "check every track again, even those verified since they last changed",
+				},
+				keepGoingFlag,
+			},
+		},
+		{
+			Name:    "update-index",
+			Aliases: []string{"u"},
+			Usage:   "update the vir index with new, changed and removed files",
+			Action:  makeAction(actionUpdateIndex),
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "hash",
+					Usage: "also compare file contents, not just size and modification time",
+				},
+				keepGoingFlag,
+			},
 		},
 	}
 
@@ -56,7 +287,9 @@ func runCliApp(args []string) {
 
 	err := app.Run(args)
 
-	if err != nil {
+	if err != nil && virErrors.IsCompletedWithProblems(err) {
+		exitWithProblems(err)
+	} else if err != nil {
 		fatal(err)
 	}
 }
@@ -64,15 +297,62 @@ func runCliApp(args []string) {
 type virAction func(*virContext, *cli.Context) virErrors.ScopedError
 
 type virContext struct {
-	musicLibraryRoot string
+	// libraryName and musicRoot are the library given with --library and the root given with --music-root, if
+	// any; see loadIndex.
+	libraryName  string
+	musicRoot    string
+	indexOptions index.Options
+	jobs         int
+
+	// format is the output format given with --format; see newOutput.
+	format string
+
+	// config is the config file, for the settings that don't have a flag of their own.
+	config *config.Config
+
+	// runCtx is cancelled when the user interrupts vir.
+	runCtx context.Context
 }
 
 func makeAction(fn virAction) func(ctx *cli.Context) error {
 	return func(cliCtx *cli.Context) error {
+		conf, err := config.Load()
+		if err != nil {
+			return err
+		}
+
+		// a flag, or the environment variable standing in for it, wins over the config file
 		virCtx := &virContext{
-			cliCtx.GlobalString("music-root"),
+			libraryName: cliCtx.GlobalString("library"),
+			musicRoot:   cliCtx.GlobalString("music-root"),
+			indexOptions: index.Options{
+				Include: conf.Include,
+				Exclude: conf.Exclude,
+			},
+			jobs:   conf.Jobs,
+			format: cliCtx.GlobalString("format"),
+			config: conf,
+			runCtx: interruptContext(),
+		}
+		if cliCtx.GlobalIsSet("include") {
+			virCtx.indexOptions.Include = cliCtx.GlobalStringSlice("include")
+		}
+		if cliCtx.GlobalIsSet("exclude") {
+			virCtx.indexOptions.Exclude = cliCtx.GlobalStringSlice("exclude")
 		}
-		err := fn(virCtx, cliCtx)
+		if cliCtx.GlobalIsSet("jobs") {
+			virCtx.jobs = cliCtx.GlobalInt("jobs")
+		}
+
+		err = fn(virCtx, cliCtx)
 		return err
 	}
 }
+
+// makeConfigAction is makeAction for the config commands, which have to work even when the config file doesn't, so
+// that it can be put right.
+func makeConfigAction(fn func(*cli.Context) virErrors.ScopedError) func(ctx *cli.Context) error {
+	return func(cliCtx *cli.Context) error {
+		return fn(cliCtx)
+	}
+}
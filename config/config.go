@@ -0,0 +1,349 @@
+// Package config reads and writes vir's configuration file, ~/.vir/config
+//
+// The file is TOML, e.g.
+//
+//	library = "main"
+//	jobs = 4
+//	exclude = ["*.log", "Incoming"]
+//
+//	[libraries.main]
+//	roots = ["/mnt/music", "~/Music"]
+//
+//	[libraries.archive]
+//	roots = ["/mnt/archive"]
+//
+//	[organise.templates]
+//	classical = "{composer}/{album}/{track:02} {title}.{ext}"
+//
+//	[lint.severity]
+//	missing-art = "off"
+//
+// A name that isn't made up of only letters, digits, dashes and underscores has to be quoted, as in
+// [libraries."my.music"], both in the file and in the keys given to Set and Get.
+//
+// Settings given with a flag or an environment variable win over the file, and the file wins over vir's defaults.
+// Usage lists every setting it can have.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ceralena/vir/lint"
+	"github.com/ceralena/vir/organise"
+	"github.com/ceralena/vir/state"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// configFileName is the name of the config file in the directory vir keeps its state in.
+const configFileName = "config"
+
+// Config is vir's configuration, with vir's defaults for anything the file doesn't set.
+type Config struct {
+	// Library is the library commands work on when none is given with --library. It is empty if there is no default.
+	Library string
+
+	// Libraries are the named libraries, by name.
+	Libraries map[string]Library
+
+	// Include and Exclude are the patterns for the files in the library vir looks at, as in index.Options.
+	Include []string
+	Exclude []string
+
+	// Jobs is the number of files to read concurrently. Zero means one per CPU.
+	Jobs int
+
+	// OrganiseTemplate is the path template organise uses unless it is given another, and OrganiseTemplates are
+	// the templates it can be given by name.
+	OrganiseTemplate  string
+	OrganiseTemplates map[string]string
+
+	// OrganiseASCII limits the file names organise chooses to ASCII.
+	OrganiseASCII bool
+
+	// LintSeverities are the severities of the lint rules that have been changed, by rule ID.
+	LintSeverities map[string]lint.Severity
+
+	// values are the settings in the file, by key.
+	values map[string]interface{}
+}
+
+// Library is a named music library, made up of one or more root directories.
+type Library struct {
+	Roots []string
+}
+
+// Path returns the path of the config file.
+func Path() (string, virErrors.ScopedError) {
+	confRoot, err := state.ConfRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(confRoot, configFileName), nil
+}
+
+// Load reads the config file, checking that everything in it makes sense. A missing file is an empty config.
+func Load() (*Config, virErrors.ScopedError) {
+	path, values, err := readValues("vir/config.Load")
+	if err != nil {
+		return nil, err
+	}
+
+	c, decodeErr := decode(values)
+	if decodeErr != nil {
+		return nil, virErrors.ErrInvalidConfig("vir/config.Load", path, decodeErr)
+	}
+	return c, nil
+}
+
+// Set changes a setting in the config file, creating the file if needs be. A list takes every one of values, and
+// anything else exactly one.
+//
+// The file is written out afresh, so any comments in it are lost.
+func Set(key string, values []string) virErrors.ScopedError {
+	s, name, ok := findSetting(key)
+	if !ok {
+		return virErrors.ErrUnknownSetting("vir/config.Set", key)
+	}
+	key, _ = canonicalKey(key)
+
+	value, err := parseSettingValue(s.kind, values)
+	if err == nil {
+		err = s.validate(name, value)
+	}
+	if err != nil {
+		return virErrors.ErrInvalidSetting("vir/config.Set", key, err)
+	}
+
+	// read the file as it is, rather than with Load, so that a setting that is wrong can be put right
+	path, current, readErr := readValues("vir/config.Set")
+	if readErr != nil {
+		return readErr
+	}
+
+	current[key] = value
+	if _, err = decode(current); err != nil {
+		if sErr, ok := err.(settingError); ok && sErr.key == key {
+			return virErrors.ErrInvalidSetting("vir/config.Set", key, sErr.err)
+		}
+		return virErrors.ErrInvalidConfig("vir/config.Set", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeTOML(&buf, current); err != nil {
+		return virErrors.ErrInvalidConfig("vir/config.Set", path, err)
+	}
+	return writeConfigFile(path, buf.Bytes())
+}
+
+// Keys returns the keys of every setting in the config file, sorted.
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns a setting, as it would be written in the config file. A setting the file doesn't have is vir's
+// default for it, and isDefault is set.
+func (c *Config) Get(key string) (value string, isDefault bool, err virErrors.ScopedError) {
+	s, name, ok := findSetting(key)
+	if !ok {
+		return "", false, virErrors.ErrUnknownSetting("vir/config.Get", key)
+	}
+	key, _ = canonicalKey(key)
+
+	if v, ok := c.values[key]; ok {
+		return formatValue(v), false, nil
+	}
+
+	if s.def != nil {
+		if v, ok := s.def(name); ok {
+			return formatValue(v), true, nil
+		}
+	}
+	return "", false, virErrors.ErrSettingNotSet("vir/config.Get", key)
+}
+
+// LibraryNames returns the names of the configured libraries in alphabetical order.
+func (c *Config) LibraryNames() []string {
+	names := make([]string, 0, len(c.Libraries))
+	for name := range c.Libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readValues reads the settings in the config file, without checking them. A missing file has none.
+func readValues(scope string) (string, map[string]interface{}, virErrors.ScopedError) {
+	path, err := Path()
+	if err != nil {
+		return "", nil, err
+	}
+
+	b, readErr := ioutil.ReadFile(path)
+	if readErr != nil && os.IsNotExist(readErr) {
+		return path, make(map[string]interface{}), nil
+	} else if readErr != nil && os.IsPermission(readErr) {
+		return "", nil, virErrors.ErrPermissionDenied(scope, path, readErr)
+	} else if readErr != nil {
+		return "", nil, virErrors.ErrConfigReadFailed(scope, path, readErr)
+	}
+
+	values, parseErr := parseTOML(string(b))
+	if parseErr != nil {
+		return "", nil, virErrors.ErrInvalidConfig(scope, path, parseErr)
+	}
+	return path, values, nil
+}
+
+// writeConfigFile replaces the config file, by way of a temporary file so that it is never left half-written.
+func writeConfigFile(path string, b []byte) virErrors.ScopedError {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, b, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	if err != nil && os.IsPermission(err) {
+		return virErrors.ErrPermissionDenied("vir/config.Set", path, err)
+	} else if err != nil {
+		return virErrors.ErrConfigWriteFailed("vir/config.Set", path, err)
+	}
+	return nil
+}
+
+// parseSettingValue turns the values given on the command line into a value of the given kind.
+func parseSettingValue(k kind, values []string) (interface{}, error) {
+	if k == kindList {
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		return list, nil
+	}
+
+	if len(values) != 1 {
+		return nil, fmt.Errorf("has to be %s", k)
+	}
+
+	switch k {
+	case kindInt:
+		n, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("has to be %s", k)
+		}
+		return n, nil
+	case kindBool:
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("has to be %s", k)
+		}
+		return b, nil
+	default:
+		return values[0], nil
+	}
+}
+
+// decode fills in a Config from the settings in the file, rejecting anything it doesn't know or that doesn't make
+// sense.
+func decode(values map[string]interface{}) (*Config, error) {
+	c := &Config{
+		Libraries:         make(map[string]Library),
+		OrganiseTemplates: make(map[string]string),
+		LintSeverities:    make(map[string]lint.Severity),
+		values:            values,
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, name, ok := findSetting(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+
+		value := values[key]
+		if err := s.validate(name, value); err != nil {
+			return nil, settingError{key, err}
+		}
+		list, _ := stringList(value)
+
+		switch s.key {
+		case "library":
+			c.Library = value.(string)
+		case "libraries.*.roots":
+			roots, err := expandRoots(list)
+			if err != nil {
+				return nil, err
+			}
+			c.Libraries[name] = Library{Roots: roots}
+		case "include":
+			c.Include = list
+		case "exclude":
+			c.Exclude = list
+		case "jobs":
+			c.Jobs = int(value.(int64))
+		case "organise.template":
+			c.OrganiseTemplate = value.(string)
+		case "organise.templates.*":
+			c.OrganiseTemplates[name] = value.(string)
+		case "organise.ascii":
+			c.OrganiseASCII = value.(bool)
+		case "lint.severity.*":
+			c.LintSeverities[name] = lint.Severity(value.(string))
+		}
+	}
+
+	if _, ok := c.Libraries[c.Library]; c.Library != "" && !ok {
+		return nil, settingError{"library", fmt.Errorf("%q isn't one of the [libraries]", c.Library)}
+	}
+	if c.OrganiseTemplate == "" {
+		c.OrganiseTemplate = organise.DefaultTemplate
+	}
+
+	return c, nil
+}
+
+// settingError is what is wrong with one of the settings in the file.
+type settingError struct {
+	key string
+	err error
+}
+
+func (e settingError) Error() string {
+	return e.key + ": " + e.err.Error()
+}
+
+// expandRoots expands a ~ at the start of each of a library's roots to the user's home directory.
+func expandRoots(roots []string) ([]string, error) {
+	expanded := make([]string, len(roots))
+	for i, root := range roots {
+		if root == "~" || strings.HasPrefix(root, "~/") {
+			u, err := user.Current()
+			if err != nil {
+				return nil, err
+			}
+			root = filepath.Join(u.HomeDir, root[1:])
+		}
+		expanded[i] = filepath.Clean(root)
+	}
+	return expanded, nil
+}
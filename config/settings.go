@@ -0,0 +1,264 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceralena/vir/lint"
+	"github.com/ceralena/vir/organise"
+)
+
+// kind is the type of value a setting takes.
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindBool
+	kindList
+)
+
+// setting is one of the settings the config file can have.
+type setting struct {
+	// key is the setting's dotted name, where * stands for a name of the user's choosing, like a library's.
+	key   string
+	kind  kind
+	usage string
+
+	// def returns the setting's default, given the name * stands for, if it has one.
+	def func(name string) (interface{}, bool)
+
+	// check returns what is wrong with a value of the right kind, if anything.
+	check func(name string, value interface{}) error
+}
+
+// settings are all the settings the config file can have.
+var settings = []setting{
+	{
+		key:   "library",
+		kind:  kindString,
+		usage: "the library to work on when neither --library nor --music-root is given",
+		check: checkNotEmpty,
+	},
+	{
+		key:   "libraries.*.roots",
+		kind:  kindList,
+		usage: "the root directories of a library",
+		check: checkRoots,
+	},
+	{
+		key:   "include",
+		kind:  kindList,
+		usage: "only look at files matching these glob patterns, like --include",
+		def:   always([]interface{}{}),
+		check: checkPatterns,
+	},
+	{
+		key:   "exclude",
+		kind:  kindList,
+		usage: "ignore files and directories matching these glob patterns, like --exclude",
+		def:   always([]interface{}{}),
+		check: checkPatterns,
+	},
+	{
+		key:   "jobs",
+		kind:  kindInt,
+		usage: "the number of files to read concurrently, like --jobs; 0 is one per CPU",
+		def:   always(int64(0)),
+		check: checkJobs,
+	},
+	{
+		key:   "organise.template",
+		kind:  kindString,
+		usage: "the path template organise uses without --template",
+		def:   always(organise.DefaultTemplate),
+		check: checkTemplate,
+	},
+	{
+		key:   "organise.templates.*",
+		kind:  kindString,
+		usage: "a path template to use by name with organise --template",
+		check: checkTemplate,
+	},
+	{
+		key:   "organise.ascii",
+		kind:  kindBool,
+		usage: "only use ASCII characters in file names, like organise --ascii",
+		def:   always(false),
+	},
+	{
+		key:   "lint.severity.*",
+		kind:  kindString,
+		usage: "the severity of a lint rule's findings: info, warning, error, or off to turn it off",
+		def:   defaultSeverity,
+		check: checkSeverity,
+	},
+}
+
+// findSetting returns the setting a key is for, and the name * stands for in it.
+func findSetting(key string) (setting, string, bool) {
+	parts, ok := splitKey(key)
+	if !ok {
+		return setting{}, "", false
+	}
+
+	for _, s := range settings {
+		pattern := strings.Split(s.key, ".")
+		if len(pattern) != len(parts) {
+			continue
+		}
+
+		name, match := "", true
+		for i := range pattern {
+			if pattern[i] == "*" && parts[i] != "" {
+				name = parts[i]
+			} else if pattern[i] != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return s, name, true
+		}
+	}
+	return setting{}, "", false
+}
+
+// validate checks that a value is of the setting's kind, and makes sense for it.
+func (s setting) validate(name string, value interface{}) error {
+	ok := false
+	switch s.kind {
+	case kindString:
+		_, ok = value.(string)
+	case kindInt:
+		_, ok = value.(int64)
+	case kindBool:
+		_, ok = value.(bool)
+	case kindList:
+		_, ok = stringList(value)
+	}
+	if !ok {
+		return fmt.Errorf("has to be %s", s.kind)
+	}
+
+	if s.check == nil {
+		return nil
+	}
+	return s.check(name, value)
+}
+
+func (k kind) String() string {
+	switch k {
+	case kindInt:
+		return "a whole number"
+	case kindBool:
+		return "true or false"
+	case kindList:
+		return "a list of strings"
+	default:
+		return "a string"
+	}
+}
+
+// Usage describes every setting the config file can have, one per line.
+func Usage() string {
+	width := 0
+	for _, s := range settings {
+		if len(s.key) > width {
+			width = len(s.key)
+		}
+	}
+
+	lines := make([]string, len(settings))
+	for i, s := range settings {
+		lines[i] = fmt.Sprintf("%-*s  %s", width, s.key, s.usage)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func always(value interface{}) func(string) (interface{}, bool) {
+	return func(string) (interface{}, bool) {
+		return value, true
+	}
+}
+
+func defaultSeverity(name string) (interface{}, bool) {
+	for _, rule := range lint.DefaultRules() {
+		if rule.ID() == name {
+			return string(rule.Severity()), true
+		}
+	}
+	return nil, false
+}
+
+func checkNotEmpty(_ string, value interface{}) error {
+	if value.(string) == "" {
+		return fmt.Errorf("can't be empty")
+	}
+	return nil
+}
+
+func checkRoots(_ string, value interface{}) error {
+	roots, _ := stringList(value)
+	if len(roots) == 0 {
+		return fmt.Errorf("has to list at least one directory")
+	}
+	for _, root := range roots {
+		if root != "~" && !strings.HasPrefix(root, "~/") && !filepath.IsAbs(root) {
+			return fmt.Errorf("%q isn't an absolute path", root)
+		}
+	}
+	return nil
+}
+
+func checkPatterns(_ string, value interface{}) error {
+	patterns, _ := stringList(value)
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q isn't a valid glob pattern", pattern)
+		}
+	}
+	return nil
+}
+
+func checkJobs(_ string, value interface{}) error {
+	if value.(int64) < 0 {
+		return fmt.Errorf("can't be negative")
+	}
+	return nil
+}
+
+func checkTemplate(_ string, value interface{}) error {
+	if _, err := organise.ParseTemplate(value.(string)); err != nil {
+		// the template's error already says what is wrong with it, and where it came from is this setting
+		return fmt.Errorf("%s", strings.TrimPrefix(err.Error(), err.Scope()+": "))
+	}
+	return nil
+}
+
+func checkSeverity(name string, value interface{}) error {
+	if _, ok := defaultSeverity(name); !ok {
+		return fmt.Errorf("%s isn't a lint rule", name)
+	}
+	if !lint.Severity(value.(string)).IsValid() {
+		return fmt.Errorf("%q isn't one of info, warning, error or off", value)
+	}
+	return nil
+}
+
+// stringList returns a list value as strings, if it is a list of strings.
+func stringList(value interface{}) ([]string, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	strs := make([]string, len(list))
+	for i, v := range list {
+		if strs[i], ok = v.(string); !ok {
+			return nil, false
+		}
+	}
+	return strs, true
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML parses the config file, flattening its tables.
+//
+// The result is flat: each value is keyed by its full dotted name, e.g. "libraries.main.roots" for the roots key
+// in the [libraries.main] table, with any part of it that isn't a bare key quoted as it would be in the file, e.g.
+// `libraries."my.music".roots`. Arrays are []interface{}, and integers int64.
+func parseTOML(src string) (map[string]interface{}, error) {
+	var tree map[string]interface{}
+	if _, err := toml.Decode(src, &tree); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	flattenTOML(values, nil, tree)
+	return values, nil
+}
+
+// flattenTOML adds the values in a table, and the tables in it, to values by their full dotted names.
+func flattenTOML(values map[string]interface{}, parent []string, table map[string]interface{}) {
+	for name, value := range table {
+		key := append(append([]string(nil), parent...), name)
+		if sub, ok := value.(map[string]interface{}); ok {
+			flattenTOML(values, key, sub)
+		} else {
+			values[joinKey(key)] = value
+		}
+	}
+}
+
+// encodeTOML writes out values, keyed as parseTOML returns them, so that parseTOML reads them back the same. Each key
+// goes in the table named by everything before its last part, with the keys outside any table first.
+func encodeTOML(buf *bytes.Buffer, values map[string]interface{}) error {
+	var tables []string
+	byTable := make(map[string][]string)
+	names := make(map[string]string)
+	for key := range values {
+		parts, ok := splitKey(key)
+		if !ok {
+			return fmt.Errorf("invalid key %s", key)
+		}
+
+		table, name := joinKey(parts[:len(parts)-1]), joinKey(parts[len(parts)-1:])
+		if _, ok := byTable[table]; !ok {
+			tables = append(tables, table)
+		}
+		byTable[table] = append(byTable[table], key)
+		names[key] = name
+	}
+	sort.Strings(tables)
+
+	for i, table := range tables {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if table != "" {
+			fmt.Fprintf(buf, "[%s]\n", table)
+		}
+
+		keys := byTable[table]
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(buf, "%s = %s\n", names[key], formatValue(values[key]))
+		}
+	}
+	return nil
+}
+
+// splitKey splits a dotted key into its parts, unquoting any that are quoted. It reports false if key isn't a valid
+// dotted key.
+func splitKey(key string) ([]string, bool) {
+	// a key is written the same way in a table header, which the parser can take apart for us
+	var tree map[string]interface{}
+	if _, err := toml.Decode("["+key+"]", &tree); err != nil {
+		return nil, false
+	}
+
+	var parts []string
+	for len(tree) == 1 {
+		for name, sub := range tree {
+			parts = append(parts, name)
+			tree, _ = sub.(map[string]interface{})
+		}
+	}
+	if len(tree) != 0 || len(parts) == 0 {
+		return nil, false
+	}
+	return parts, true
+}
+
+// joinKey joins the parts of a dotted key, quoting those that can't be written bare.
+func joinKey(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		if isBareKey(part) {
+			quoted[i] = part
+		} else {
+			quoted[i] = quoteString(part)
+		}
+	}
+	return strings.Join(quoted, ".")
+}
+
+// canonicalKey returns a dotted key as parseTOML would key its value, with only the parts that need it quoted.
+func canonicalKey(key string) (string, bool) {
+	parts, ok := splitKey(key)
+	if !ok {
+		return "", false
+	}
+	return joinKey(parts), true
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// isBareKey reports whether s can be written as a key, or part of a dotted key, without quotes.
+func isBareKey(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isBareKeyChar(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// formatValue writes a value the way it would be in the config file.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return quoteString(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// quoteString writes s as a TOML basic string, escaping what TOML requires to be escaped.
+func quoteString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
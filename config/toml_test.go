@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]interface{}
+	}{
+		{
+			name: "tables and comments",
+			src: `# vir's config
+library = "main" # the default
+jobs = 4
+
+[libraries.main]
+roots = [
+	"/mnt/music",
+	"~/Music", # trailing comma
+]
+
+[lint.severity]
+missing-art = "off"
+`,
+			want: map[string]interface{}{
+				"library":                   "main",
+				"jobs":                      int64(4),
+				"libraries.main.roots":      []interface{}{"/mnt/music", "~/Music"},
+				"lint.severity.missing-art": "off",
+			},
+		},
+		{
+			name: "TOML escapes",
+			src:  `a = "tab\there \"quoted\" back\\slash \u00e9 \U0001F3B5"`,
+			want: map[string]interface{}{"a": "tab\there \"quoted\" back\\slash é 🎵"},
+		},
+		{
+			name: "literal strings",
+			src:  `a = 'C:\Music\n'`,
+			want: map[string]interface{}{"a": `C:\Music\n`},
+		},
+		{
+			name: "booleans",
+			src:  "a = true\nb = false",
+			want: map[string]interface{}{"a": true, "b": false},
+		},
+		{
+			name: "quoted keys",
+			src: `"odd key" = 1
+
+[libraries."my.music"]
+roots = ["/mnt/music"]
+
+[organise.templates.'by year']
+x = "{year}"
+`,
+			want: map[string]interface{}{
+				`"odd key"`:                      int64(1),
+				`libraries."my.music".roots`:     []interface{}{"/mnt/music"},
+				`organise.templates."by year".x`: "{year}",
+			},
+		},
+		{
+			name: "inline table",
+			src:  `lint = { severity = { missing-art = "off" } }`,
+			want: map[string]interface{}{"lint.severity.missing-art": "off"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.src)
+			if err != nil {
+				t.Fatalf("parseTOML: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLInvalid(t *testing.T) {
+	for _, src := range []string{
+		`a = truex`,
+		`a = True`,
+		`a = "\x41"`,
+		`a = "\q"`,
+		`a = "unclosed`,
+		`a = unquoted`,
+		"a = 1\na = 2",
+		"[t]\n[t]",
+		`[libraries.my.music`,
+		`a = 1 b = 2`,
+	} {
+		if values, err := parseTOML(src); err == nil {
+			t.Errorf("parseTOML(%q) = %v, want an error", src, values)
+		}
+	}
+}
+
+func TestEncodeTOMLRoundTrip(t *testing.T) {
+	values := map[string]interface{}{
+		"library":                          "my.music",
+		"jobs":                             int64(0),
+		"organise.ascii":                   true,
+		"exclude":                          []interface{}{`*\.log`, "tab\there", "bell\a"},
+		`libraries."my.music".roots`:       []interface{}{"/mnt/music"},
+		"libraries.main.roots":             []interface{}{`C:\Music`},
+		`organise.templates."by \"year\""`: "{year}/{album}",
+	}
+
+	var buf bytes.Buffer
+	if err := encodeTOML(&buf, values); err != nil {
+		t.Fatalf("encodeTOML: %s", err)
+	}
+	got, err := parseTOML(buf.String())
+	if err != nil {
+		t.Fatalf("parseTOML of\n%s: %s", buf.String(), err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("read back\n%#v\nfrom\n%s\nwant\n%#v", got, buf.String(), values)
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"jobs", "jobs", true},
+		{"libraries.main.roots", "libraries.main.roots", true},
+		{`libraries."main".roots`, "libraries.main.roots", true},
+		{`libraries."my.music".roots`, `libraries."my.music".roots`, true},
+		{`libraries.'my.music'.roots`, `libraries."my.music".roots`, true},
+		{"libraries.my music.roots", "", false},
+		{"", "", false},
+		{"a..b", "", false},
+		{"a]\nb = 1\n[c", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := canonicalKey(tt.key)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("canonicalKey(%q) = %q, %v; want %q, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFindSettingQuotedName(t *testing.T) {
+	s, name, ok := findSetting(`libraries."my.music".roots`)
+	if !ok || s.key != "libraries.*.roots" || name != "my.music" {
+		t.Errorf("findSetting = %q, %q, %v; want libraries.*.roots, my.music, true", s.key, name, ok)
+	}
+
+	c, err := decode(map[string]interface{}{
+		"library":                    "my.music",
+		`libraries."my.music".roots`: []interface{}{"/mnt/music"},
+	})
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if roots := c.Libraries["my.music"].Roots; !reflect.DeepEqual(roots, []string{"/mnt/music"}) {
+		t.Errorf("roots of my.music = %q", roots)
+	}
+}
@@ -0,0 +1,250 @@
+// Package dupes finds duplicate tracks in a vir index
+// tracks can be duplicates byte-for-byte, in their audio alone, or in their metadata alone
+package dupes
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/util"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// Kind is the way in which the tracks in a group are duplicates of each other.
+type Kind string
+
+// The kinds of duplicate groups, from most to least certain.
+const (
+	KindByteIdentical     Kind = "byte-identical"
+	KindAudioIdentical    Kind = "audio-identical"
+	KindMetadataIdentical Kind = "metadata-identical"
+)
+
+// Group is a set of tracks that are duplicates of each other, sorted by RelFilename.
+type Group struct {
+	Kind    Kind
+	Entries []index.Entry
+}
+
+// Best returns the copy in the group most worth keeping: a lossless one over a lossy one, then the one with the
+// highest bitrate, then the one with the highest sample rate. Ties go to the first.
+func (g Group) Best() index.Entry {
+	best := g.Entries[0]
+	for _, e := range g.Entries[1:] {
+		if better(e.Stream, best.Stream) {
+			best = e
+		}
+	}
+	return best
+}
+
+func better(a, b track.StreamInfo) bool {
+	switch {
+	case a.Lossless != b.Lossless:
+		return a.Lossless
+	case a.Bitrate != b.Bitrate:
+		return a.Bitrate > b.Bitrate
+	default:
+		return a.SampleRate > b.SampleRate
+	}
+}
+
+// Options controls how duplicates are found.
+type Options struct {
+	// Jobs is the number of files hashed concurrently. Zero or less means one per CPU.
+	Jobs int
+}
+
+// Find groups duplicate entries.
+//
+// Audio hashes come from the entries, so the index should have been updated with HashAudio set first. Content hashes
+// missing from the entries are computed on the fly, but only for files that share a size with another one; a file
+// that can't be hashed is reported as a problem and just left out of the byte-identical groups.
+//
+// A group is only reported once: an audio-identical group whose tracks are all byte-identical anyway is left out,
+// and so on.
+func Find(ctx context.Context, entries []index.Entry, opts Options) ([]Group, []index.Problem, virErrors.ScopedError) {
+	entries, problems, err := fillContentHashes(ctx, entries, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var groups []Group
+	reported := make(map[string]bool)
+
+	add := func(kind Kind, byKey map[string][]index.Entry) {
+		for _, members := range sortedGroups(byKey) {
+			id := groupID(members)
+			if reported[id] {
+				continue
+			}
+			reported[id] = true
+			groups = append(groups, Group{Kind: kind, Entries: members})
+		}
+	}
+
+	add(KindByteIdentical, groupBy(entries, func(e index.Entry) string {
+		if e.ContentHash == "" {
+			return ""
+		}
+		return strconv.FormatInt(e.Size, 10) + ":" + e.ContentHash
+	}))
+	add(KindAudioIdentical, groupBy(entries, func(e index.Entry) string { return e.AudioHash }))
+	add(KindMetadataIdentical, groupBy(entries, metadataKey))
+
+	return groups, problems, nil
+}
+
+// fillContentHashes returns a copy of entries with their content hashes filled in, along with the files that couldn't
+// be hashed, sorted by RelFilename.
+//
+// Only files sharing a size with another file can be byte-identical, so the others don't need a content hash.
+func fillContentHashes(ctx context.Context, entries []index.Entry, opts Options) ([]index.Entry, []index.Problem, virErrors.ScopedError) {
+	filled := make([]index.Entry, len(entries))
+	copy(filled, entries)
+
+	sizes := make(map[int64]int)
+	for _, e := range filled {
+		sizes[e.Size]++
+	}
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	work := make(chan int)
+	var problems []index.Problem
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if sizes[filled[i].Size] < 2 || filled[i].ContentHash != "" {
+					continue
+				}
+				if problem := fillContentHash(&filled[i]); problem != nil {
+					mu.Lock()
+					problems = append(problems, *problem)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	var err virErrors.ScopedError
+feed:
+	for i := range filled {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			err = virErrors.ErrInterrupted("vir/dupes.fillContentHashes")
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].RelFilename < problems[j].RelFilename })
+	return filled, problems, nil
+}
+
+func fillContentHash(e *index.Entry) *index.Problem {
+	h, err := util.HashFile(e.FullPath)
+	if err != nil && os.IsPermission(err) {
+		return &index.Problem{
+			RelFilename: e.RelFilename,
+			Kind:        index.ProblemPermissionDenied,
+			Error:       virErrors.ErrPermissionDenied("vir/dupes.fillContentHash", e.FullPath, err),
+		}
+	} else if err != nil {
+		return &index.Problem{
+			RelFilename: e.RelFilename,
+			Kind:        index.ProblemHashFailed,
+			Error:       virErrors.ErrFileHashFailed("vir/dupes.fillContentHash", e.FullPath, err),
+		}
+	}
+
+	e.ContentHash = h
+	return nil
+}
+
+// metadataKey is a normalised artist/album/title/number key, or empty if a track doesn't have enough metadata to
+// say anything useful about it.
+func metadataKey(e index.Entry) string {
+	if e.Title == "" || e.Artist == "" {
+		return ""
+	}
+	return strings.Join([]string{
+		normalise(e.Artist),
+		normalise(e.Album),
+		normalise(e.Title),
+		strconv.Itoa(e.Number),
+	}, "\x00")
+}
+
+// normalise lower-cases a string, drops punctuation and collapses whitespace,
+// so that trivially different spellings compare equal.
+func normalise(s string) string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsNumber(r) {
+				return r
+			}
+			return -1
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func groupBy(entries []index.Entry, key func(e index.Entry) string) map[string][]index.Entry {
+	byKey := make(map[string][]index.Entry)
+	for _, e := range entries {
+		if k := key(e); k != "" {
+			byKey[k] = append(byKey[k], e)
+		}
+	}
+	return byKey
+}
+
+// sortedGroups returns the groups with more than one member, ordered by their first member's path.
+// Entries come from the index sorted by path already, so each group is too.
+func sortedGroups(byKey map[string][]index.Entry) [][]index.Entry {
+	var groups [][]index.Entry
+	for _, members := range byKey {
+		if len(members) > 1 {
+			groups = append(groups, members)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0].RelFilename < groups[j][0].RelFilename
+	})
+	return groups
+}
+
+func groupID(members []index.Entry) string {
+	paths := make([]string, len(members))
+	for i, e := range members {
+		paths[i] = e.RelFilename
+	}
+	return strings.Join(paths, "\x00")
+}
@@ -0,0 +1,142 @@
+package fileops
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ceralena/vir/state"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// journalCacheKey is the state cache key the journal is persisted under.
+const journalCacheKey = "journal"
+
+// Batch is an executed plan, as recorded in the journal.
+//
+// Complete is false if the plan failed part of the way through; Ops still lists every operation in the plan, and
+// Undo works out which of them actually happened.
+type Batch struct {
+	ID          string
+	Description string
+	Root        string
+	Time        time.Time
+	Ops         []Op
+	Complete    bool
+	Undone      bool
+}
+
+// Journal is the persistent record of every plan vir has executed.
+type Journal struct {
+	stateCache state.Cache
+}
+
+// OpenJournal opens the journal in the vir state directory.
+func OpenJournal() (*Journal, virErrors.ScopedError) {
+	stateCache, err := state.GetStateCache()
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{stateCache: stateCache}, nil
+}
+
+// Batches returns every batch in the journal, oldest first.
+func (j *Journal) Batches() ([]Batch, virErrors.ScopedError) {
+	b, err := j.stateCache.Get(journalCacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if b == nil {
+		return nil, nil
+	}
+
+	var batches []Batch
+	if jsonErr := json.Unmarshal(b, &batches); jsonErr != nil {
+		return nil, virErrors.ErrJournalDecodeFailed("vir/fileops.Journal", jsonErr)
+	}
+
+	return batches, nil
+}
+
+// begin records a new batch for a plan that is about to be executed.
+func (j *Journal) begin(p *Plan) (*Batch, virErrors.ScopedError) {
+	batches, err := j.Batches()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	batch := &Batch{
+		ID:          newBatchID(now, batches),
+		Description: p.Description,
+		Root:        p.Root,
+		Time:        now,
+		Ops:         append([]Op(nil), p.Ops...),
+	}
+
+	return batch, j.write(append(batches, *batch))
+}
+
+// save replaces the recorded copy of a batch.
+func (j *Journal) save(batch *Batch) virErrors.ScopedError {
+	batches, err := j.Batches()
+	if err != nil {
+		return err
+	}
+
+	for i := range batches {
+		if batches[i].ID == batch.ID {
+			batches[i] = *batch
+			return j.write(batches)
+		}
+	}
+
+	return j.write(append(batches, *batch))
+}
+
+func (j *Journal) write(batches []Batch) virErrors.ScopedError {
+	b, jsonErr := json.Marshal(batches)
+	if jsonErr != nil {
+		return virErrors.ErrJournalEncodeFailed("vir/fileops.Journal", jsonErr)
+	}
+	return j.stateCache.Set(journalCacheKey, b)
+}
+
+// newBatchID makes a short, sortable ID from the time, adding a suffix if it is already taken.
+func newBatchID(now time.Time, batches []Batch) string {
+	base := now.UTC().Format("20060102-150405")
+
+	taken := make(map[string]bool, len(batches))
+	for _, b := range batches {
+		taken[b.ID] = true
+	}
+
+	id := base
+	for n := 2; taken[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
+
+// saveBackup keeps a copy of a file that is about to be replaced, under the hash of its contents.
+func (j *Journal) saveBackup(hash string, data []byte) virErrors.ScopedError {
+	return j.stateCache.Set(backupCacheKeyPrefix+hash, data)
+}
+
+// loadBackup reads back a copy kept by saveBackup.
+func (j *Journal) loadBackup(hash string) ([]byte, virErrors.ScopedError) {
+	data, err := j.stateCache.Get(backupCacheKeyPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, virErrors.ErrJournalBackupMissing("vir/fileops.Journal", hash)
+	}
+	return data, nil
+}
+
+func (j *Journal) hasBackup(hash string) bool {
+	data, err := j.stateCache.Get(backupCacheKeyPrefix + hash)
+	return err == nil && data != nil
+}
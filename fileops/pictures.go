@@ -0,0 +1,171 @@
+package fileops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// StoredPicture is an embedded picture as the journal keeps it, with its data in the journal's backups under Hash.
+type StoredPicture struct {
+	Type        int
+	MIME        string
+	Description string `json:",omitempty"`
+	Hash        string
+}
+
+// AddEmbedPicture adds the embedding of a picture in a file's tags to the plan, in place of any pictures of the same
+// type. Those pictures are kept in the journal's backups so that the embedding can be undone.
+func (p *Plan) AddEmbedPicture(path string, picture track.Picture) virErrors.ScopedError {
+	previous, err := picturesOfType(filepath.Join(p.Root, path), picture.Type)
+	if err != nil {
+		return err
+	}
+
+	op := Op{Kind: OpEmbedPicture, From: path, Picture: storedPicture(picture), picture: &picture}
+	for _, pic := range previous {
+		op.PreviousPictures = append(op.PreviousPictures, *storedPicture(pic))
+	}
+
+	p.Ops = append(p.Ops, op)
+	return nil
+}
+
+// embedPicture carries out the embedding of a picture, backing up the pictures it replaces first.
+func embedPicture(j *Journal, root string, op *Op) virErrors.ScopedError {
+	fullPath := filepath.Join(root, op.From)
+
+	if op.picture == nil {
+		// only plans being executed have the picture to embed
+		return virErrors.ErrTrackTagWriteFailed("vir/fileops.embedPicture", fullPath, fmt.Errorf("no picture to embed"))
+	}
+
+	previous, err := picturesOfType(fullPath, op.Picture.Type)
+	if err != nil {
+		return err
+	}
+	if !samePictures(previous, op.PreviousPictures) {
+		return virErrors.ErrTrackTagWriteFailed("vir/fileops.embedPicture", fullPath, fmt.Errorf("its pictures have changed since the plan was made"))
+	}
+	for i, pic := range previous {
+		if err := j.saveBackup(op.PreviousPictures[i].Hash, pic.Data); err != nil {
+			return err
+		}
+	}
+
+	if err := track.ReplacePictures(fullPath, op.Picture.Type, []track.Picture{*op.picture}); err != nil {
+		return err
+	}
+
+	fi, statErr := os.Lstat(fullPath)
+	if statErr != nil {
+		return virErrors.ErrTrackTagWriteFailed("vir/fileops.embedPicture", fullPath, statErr)
+	}
+	op.Size = fi.Size()
+	op.ModTime = fi.ModTime()
+
+	return nil
+}
+
+// revertEmbedPicture puts back the pictures an embedding replaced.
+func revertEmbedPicture(j *Journal, root string, op Op) virErrors.ScopedError {
+	var pictures []track.Picture
+	for _, stored := range op.PreviousPictures {
+		data, err := j.loadBackup(stored.Hash)
+		if err != nil {
+			return err
+		}
+		pictures = append(pictures, track.Picture{Type: stored.Type, MIME: stored.MIME, Description: stored.Description, Data: data})
+	}
+
+	return track.ReplacePictures(filepath.Join(root, op.From), op.Picture.Type, pictures)
+}
+
+// checkUndoEmbedPicture works out whether the embedding of a picture was carried out and can be reverted. An
+// embedding the journal has no record of the result of was interrupted, and the file's pictures say whether it
+// happened.
+func checkUndoEmbedPicture(j *Journal, root string, op Op) (bool, string) {
+	fullPath := filepath.Join(root, op.From)
+	fi, err := os.Lstat(fullPath)
+	if err != nil {
+		return false, fmt.Sprintf("%s no longer exists", op.From)
+	}
+
+	for _, stored := range op.PreviousPictures {
+		if !j.hasBackup(stored.Hash) {
+			return false, fmt.Sprintf("the backup of a picture in %s is missing", op.From)
+		}
+	}
+
+	if !op.ModTime.IsZero() {
+		if fi.Size() != op.Size || !fi.ModTime().Equal(op.ModTime) {
+			return false, fmt.Sprintf("%s has changed since its picture was embedded", op.From)
+		}
+		return true, ""
+	}
+
+	current, scopedErr := picturesOfType(fullPath, op.Picture.Type)
+	if scopedErr != nil {
+		return false, fmt.Sprintf("%s: %s", op.From, scopedErr)
+	}
+	switch {
+	case samePictures(current, []StoredPicture{*op.Picture}):
+		return true, ""
+	case samePictures(current, op.PreviousPictures):
+		// never embedded
+		return false, ""
+	default:
+		return false, fmt.Sprintf("%s has changed since its picture was embedded", op.From)
+	}
+}
+
+// describeEmbedPicture says what embedding a picture does.
+func describeEmbedPicture(op Op) string {
+	if len(op.PreviousPictures) > 0 {
+		return fmt.Sprintf("%s %s in %s, replacing %d picture(s)", op.Kind, op.Picture.MIME, op.From, len(op.PreviousPictures))
+	}
+	return fmt.Sprintf("%s %s in %s", op.Kind, op.Picture.MIME, op.From)
+}
+
+// describeUndoEmbedPicture says what undoing the embedding of a picture does.
+func describeUndoEmbedPicture(op Op) string {
+	if len(op.PreviousPictures) > 0 {
+		return fmt.Sprintf("restore %d picture(s) in %s", len(op.PreviousPictures), op.From)
+	}
+	return fmt.Sprintf("remove the embedded %s from %s", op.Picture.MIME, op.From)
+}
+
+func picturesOfType(fullPath string, pictureType int) ([]track.Picture, virErrors.ScopedError) {
+	pictures, err := track.ReadPictures(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var ofType []track.Picture
+	for _, p := range pictures {
+		if p.Type == pictureType {
+			ofType = append(ofType, p)
+		}
+	}
+	return ofType, nil
+}
+
+func storedPicture(p track.Picture) *StoredPicture {
+	return &StoredPicture{Type: p.Type, MIME: p.MIME, Description: p.Description, Hash: hashData(p.Data)}
+}
+
+// samePictures reports whether pictures read from a file are the ones stored in the journal, in the same order.
+func samePictures(pictures []track.Picture, stored []StoredPicture) bool {
+	if len(pictures) != len(stored) {
+		return false
+	}
+	for i := range pictures {
+		if hashData(pictures[i].Data) != stored[i].Hash {
+			return false
+		}
+	}
+	return true
+}
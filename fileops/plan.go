@@ -0,0 +1,183 @@
+// Package fileops provides reversible file operations for vir
+// every command that moves or rewrites files builds a Plan, which is recorded in a Journal when it is executed so it
+// can be undone
+package fileops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// OpKind is the kind of a file operation.
+type OpKind string
+
+// The kinds of operation a plan can contain. Each kind is carried out by execute, undone by revert after checkUndo
+// has made sure it is safe to, and described by describe and describeUndo.
+const (
+	OpMove         OpKind = "move"
+	OpSetTags      OpKind = "tag"
+	OpWriteFile    OpKind = "write"
+	OpEmbedPicture OpKind = "embed"
+)
+
+// Op is a single file operation. Paths are relative to the plan's root, or absolute if it doesn't have one.
+//
+// Size and ModTime describe the file once the operation is done, so that Undo can tell if it has changed since.
+// Tags and PreviousTags are only used by OpSetTags, which sets the tag fields in Tags and is undone by setting them
+// back to the values in PreviousTags; both are keyed by field name.
+// OpWriteFile writes data with the given Hash to To. Backup is the hash of the file it replaced, which is kept in the
+// journal's backups, or empty if there wasn't one.
+// OpEmbedPicture embeds Picture in the tags of From, in place of PreviousPictures.
+type Op struct {
+	Kind         OpKind
+	From         string            `json:",omitempty"`
+	To           string            `json:",omitempty"`
+	Size         int64             `json:",omitempty"`
+	ModTime      time.Time         `json:",omitempty"`
+	Tags         map[string]string `json:",omitempty"`
+	PreviousTags map[string]string `json:",omitempty"`
+	Hash         string            `json:",omitempty"`
+	Backup       string            `json:",omitempty"`
+
+	Picture          *StoredPicture  `json:",omitempty"`
+	PreviousPictures []StoredPicture `json:",omitempty"`
+
+	// data and picture are what OpWriteFile writes and OpEmbedPicture embeds; the journal only keeps their hashes
+	data    []byte
+	picture *track.Picture
+}
+
+// Plan is a list of operations to carry out in order on a music library. Root is empty for plans that work on files
+// given by their full paths.
+type Plan struct {
+	Root        string
+	Description string
+	Ops         []Op
+}
+
+// NewPlan starts an empty plan for the music library at root.
+// The description is what the plan is shown as in the journal, e.g. "organise".
+func NewPlan(root, description string) *Plan {
+	return &Plan{Root: root, Description: description}
+}
+
+// AddMove adds a file move to the plan.
+func (p *Plan) AddMove(from, to string) {
+	p.Ops = append(p.Ops, Op{Kind: OpMove, From: from, To: to})
+}
+
+// Print writes the plan out, one operation per line.
+func (p *Plan) Print(w io.Writer) {
+	for _, op := range p.Ops {
+		fmt.Fprintln(w, describe(op))
+	}
+}
+
+// describe says what an operation does, on one or more lines.
+func describe(op Op) string {
+	switch op.Kind {
+	case OpSetTags:
+		return describeTags(op.From, op.PreviousTags, op.Tags)
+	case OpWriteFile:
+		return describeWriteFile(op)
+	case OpEmbedPicture:
+		return describeEmbedPicture(op)
+	default:
+		return fmt.Sprintf("%s %s -> %s", op.Kind, op.From, op.To)
+	}
+}
+
+// Execute carries out a plan, recording it in the journal first so that even a plan that fails part of the way
+// through can be undone. Directories are created as needed, and any left empty by a move are removed.
+//
+// It stops at the first operation that fails, or before the next one once ctx is done, returning the batch it
+// recorded alongside the error.
+func Execute(ctx context.Context, p *Plan, j *Journal) (*Batch, virErrors.ScopedError) {
+	batch, err := j.begin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range batch.Ops {
+		op := &batch.Ops[i]
+
+		if ctx.Err() != nil {
+			_ = j.save(batch)
+			return batch, virErrors.ErrInterrupted("vir/fileops.Execute")
+		}
+
+		if err := execute(j, p.Root, op); err != nil {
+			// record how far we got before giving up
+			_ = j.save(batch)
+			return batch, err
+		}
+	}
+
+	batch.Complete = true
+	return batch, j.save(batch)
+}
+
+// execute carries out a single operation, filling in what Undo needs to know about its result.
+func execute(j *Journal, root string, op *Op) virErrors.ScopedError {
+	switch op.Kind {
+	case OpMove:
+		if err := move(root, op); err != nil {
+			return err
+		}
+		removeEmptyDirs(root, filepath.Dir(op.From))
+		return nil
+	case OpSetTags:
+		return setTags(root, op)
+	case OpWriteFile:
+		return writeFile(j, root, op)
+	case OpEmbedPicture:
+		return embedPicture(j, root, op)
+	default:
+		return virErrors.ErrUnknownFileOp("vir/fileops.execute", string(op.Kind))
+	}
+}
+
+// move carries out a move, filling in the size and modification time of the moved file.
+func move(root string, op *Op) virErrors.ScopedError {
+	from := filepath.Join(root, op.From)
+	to := filepath.Join(root, op.To)
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return virErrors.ErrFileMoveFailed("vir/fileops.move", op.From, op.To, err)
+	}
+
+	if _, err := os.Lstat(to); err == nil {
+		return virErrors.ErrFileMoveFailed("vir/fileops.move", op.From, op.To, os.ErrExist)
+	}
+
+	if err := os.Rename(from, to); err != nil {
+		return virErrors.ErrFileMoveFailed("vir/fileops.move", op.From, op.To, err)
+	}
+
+	fi, err := os.Lstat(to)
+	if err != nil {
+		return virErrors.ErrFileMoveFailed("vir/fileops.move", op.From, op.To, err)
+	}
+	op.Size = fi.Size()
+	op.ModTime = fi.ModTime()
+
+	return nil
+}
+
+// removeEmptyDirs removes relDir and its parents for as long as they are empty, stopping at the root.
+func removeEmptyDirs(root, relDir string) {
+	for relDir != "." && relDir != string(filepath.Separator) {
+		// this fails harmlessly if the directory isn't empty
+		if err := os.Remove(filepath.Join(root, relDir)); err != nil {
+			return
+		}
+		relDir = filepath.Dir(relDir)
+	}
+}
@@ -0,0 +1,108 @@
+package fileops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// AddSetTags adds an edit of a file's tags to the plan, which sets the given fields to their values in after.
+// Their values in before are kept in the journal so the edit can be undone.
+func (p *Plan) AddSetTags(path string, before, after track.Metadata, fields []track.Field) {
+	op := Op{Kind: OpSetTags, From: path, Tags: make(map[string]string), PreviousTags: make(map[string]string)}
+	for _, f := range fields {
+		op.Tags[string(f)] = after.Get(f)
+		op.PreviousTags[string(f)] = before.Get(f)
+	}
+	p.Ops = append(p.Ops, op)
+}
+
+// setTags carries out a tag edit, filling in the size and modification time of the rewritten file.
+func setTags(root string, op *Op) virErrors.ScopedError {
+	fullPath := filepath.Join(root, op.From)
+	if err := writeTags(fullPath, op.Tags); err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(fullPath)
+	if err != nil {
+		return virErrors.ErrTrackTagWriteFailed("vir/fileops.setTags", fullPath, err)
+	}
+	op.Size = fi.Size()
+	op.ModTime = fi.ModTime()
+
+	return nil
+}
+
+// writeTags sets the tag fields of a file to the given values, keyed by field name.
+func writeTags(fullPath string, tags map[string]string) virErrors.ScopedError {
+	var m track.Metadata
+	var fields []track.Field
+	for _, f := range track.Fields {
+		value, ok := tags[string(f)]
+		if !ok {
+			continue
+		}
+		if err := m.Set(f, value); err != nil {
+			return virErrors.ErrInvalidTagValue("vir/fileops.writeTags", string(f), value, err)
+		}
+		fields = append(fields, f)
+	}
+
+	return track.UpdateMetadata(fullPath, m, fields)
+}
+
+// checkUndoSetTags works out whether a tag edit was carried out and can be reverted. An edit the journal has no
+// record of the result of was interrupted, and the tags themselves say whether it happened.
+func checkUndoSetTags(root string, op Op) (bool, string) {
+	fullPath := filepath.Join(root, op.From)
+	fi, err := os.Lstat(fullPath)
+	if err != nil {
+		return false, fmt.Sprintf("%s no longer exists", op.From)
+	}
+
+	if !op.ModTime.IsZero() {
+		if fi.Size() != op.Size || !fi.ModTime().Equal(op.ModTime) {
+			return false, fmt.Sprintf("%s has changed since its tags were written", op.From)
+		}
+		return true, ""
+	}
+
+	tr, scopedErr := track.LoadTrackFromPath(fullPath)
+	if scopedErr != nil {
+		return false, fmt.Sprintf("%s: %s", op.From, scopedErr)
+	}
+	switch {
+	case tagsMatch(tr.Metadata, op.Tags):
+		return true, ""
+	case tagsMatch(tr.Metadata, op.PreviousTags):
+		// never written
+		return false, ""
+	default:
+		return false, fmt.Sprintf("%s has changed since its tags were written", op.From)
+	}
+}
+
+func tagsMatch(m track.Metadata, tags map[string]string) bool {
+	for field, value := range tags {
+		if m.Get(track.Field(field)) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// describeTags says what setting a file's tags from one set of values to another does, one field per line.
+func describeTags(path string, from, to map[string]string) string {
+	var lines []string
+	for _, f := range track.Fields {
+		if value, ok := to[string(f)]; ok {
+			lines = append(lines, fmt.Sprintf("%s %s: %s %q -> %q", OpSetTags, path, f, from[string(f)], value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
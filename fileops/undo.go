@@ -0,0 +1,167 @@
+package fileops
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// Undo reverts a batch from the journal: the one with the given ID, or the most recent one still in effect if id is
+// empty.
+//
+// Every operation is checked before anything is touched. If any file has changed since the batch was executed, or
+// something is now in the way, nothing is reverted and the conflicts are returned as an error.
+func Undo(j *Journal, id string) (*Batch, virErrors.ScopedError) {
+	batch, toRevert, err := CheckUndo(j, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range toRevert {
+		if err := revert(j, batch.Root, op); err != nil {
+			return nil, err
+		}
+	}
+
+	batch.Undone = true
+	return batch, j.save(batch)
+}
+
+// CheckUndo finds the batch Undo would revert and checks it in the same way, without touching anything. It returns
+// the operations that would be reverted, in the order they would be.
+func CheckUndo(j *Journal, id string) (*Batch, []Op, virErrors.ScopedError) {
+	batches, err := j.Batches()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batch := findBatch(batches, id)
+	if batch == nil && id == "" {
+		return nil, nil, virErrors.ErrNothingToUndo("vir/fileops.CheckUndo")
+	} else if batch == nil {
+		return nil, nil, virErrors.ErrJournalBatchNotFound("vir/fileops.CheckUndo", id)
+	} else if batch.Undone {
+		return nil, nil, virErrors.ErrJournalBatchAlreadyUndone("vir/fileops.CheckUndo", batch.ID)
+	}
+
+	var toRevert []Op
+	var conflicts []string
+
+	for i := len(batch.Ops) - 1; i >= 0; i-- {
+		op := batch.Ops[i]
+		done, conflict := checkUndo(j, batch.Root, op)
+		if conflict != "" {
+			conflicts = append(conflicts, conflict)
+		} else if done {
+			toRevert = append(toRevert, op)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, nil, virErrors.ErrUndoConflict("vir/fileops.CheckUndo", batch.ID, conflicts)
+	}
+
+	return batch, toRevert, nil
+}
+
+// PrintUndo writes out what reverting operations from CheckUndo does, one operation per line.
+func PrintUndo(w io.Writer, ops []Op) {
+	for _, op := range ops {
+		fmt.Fprintln(w, describeUndo(op))
+	}
+}
+
+// opPath returns the path of the file an operation works on, or the one it starts from for a move.
+func opPath(op Op) string {
+	if op.From != "" {
+		return op.From
+	}
+	return op.To
+}
+
+// describeUndo says what reverting an operation does.
+func describeUndo(op Op) string {
+	switch op.Kind {
+	case OpMove:
+		return describe(Op{Kind: OpMove, From: op.To, To: op.From})
+	case OpSetTags:
+		return describeTags(op.From, op.Tags, op.PreviousTags)
+	case OpWriteFile:
+		return describeUndoWriteFile(op)
+	case OpEmbedPicture:
+		return describeUndoEmbedPicture(op)
+	default:
+		return fmt.Sprintf("revert %s %s", op.Kind, opPath(op))
+	}
+}
+
+// revert undoes a single operation that checkUndo has found to be safe to undo.
+func revert(j *Journal, root string, op Op) virErrors.ScopedError {
+	switch op.Kind {
+	case OpMove:
+		reverse := Op{Kind: OpMove, From: op.To, To: op.From}
+		return execute(j, root, &reverse)
+	case OpSetTags:
+		return writeTags(filepath.Join(root, op.From), op.PreviousTags)
+	case OpWriteFile:
+		return revertWriteFile(j, root, op)
+	case OpEmbedPicture:
+		return revertEmbedPicture(j, root, op)
+	default:
+		return virErrors.ErrUnknownFileOp("vir/fileops.revert", string(op.Kind))
+	}
+}
+
+func findBatch(batches []Batch, id string) *Batch {
+	for i := len(batches) - 1; i >= 0; i-- {
+		if id == "" && !batches[i].Undone {
+			return &batches[i]
+		}
+		if id != "" && batches[i].ID == id {
+			return &batches[i]
+		}
+	}
+	return nil
+}
+
+// checkUndo works out whether an operation was carried out and can be reverted.
+// An operation that never happened (because its batch failed before reaching it) is neither done nor a conflict.
+func checkUndo(j *Journal, root string, op Op) (bool, string) {
+	switch op.Kind {
+	case OpMove:
+		return checkUndoMove(root, op)
+	case OpSetTags:
+		return checkUndoSetTags(root, op)
+	case OpWriteFile:
+		return checkUndoWriteFile(j, root, op)
+	case OpEmbedPicture:
+		return checkUndoEmbedPicture(j, root, op)
+	default:
+		return false, fmt.Sprintf("%s: this version of vir can't undo %q operations", opPath(op), op.Kind)
+	}
+}
+
+func checkUndoMove(root string, op Op) (bool, string) {
+	fromInfo, fromErr := os.Lstat(filepath.Join(root, op.From))
+	toInfo, toErr := os.Lstat(filepath.Join(root, op.To))
+
+	switch {
+	case toErr != nil && fromErr == nil:
+		// never moved
+		return false, ""
+	case toErr != nil:
+		return false, fmt.Sprintf("%s no longer exists", op.To)
+	case fromInfo != nil:
+		return false, fmt.Sprintf("%s is in the way of moving %s back", op.From, op.To)
+	case op.ModTime.IsZero():
+		// the batch was interrupted before this move was recorded, but it happened
+		return true, ""
+	case toInfo.Size() != op.Size || !toInfo.ModTime().Equal(op.ModTime):
+		return false, fmt.Sprintf("%s has changed since it was moved", op.To)
+	}
+
+	return true, ""
+}
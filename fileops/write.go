@@ -0,0 +1,179 @@
+package fileops
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// backupCacheKeyPrefix is what the state cache keys of the backups of replaced files start with, followed by the
+// hash of their contents.
+const backupCacheKeyPrefix = "backup-"
+
+// AddWriteFile adds the writing of a file to the plan. A file that is already there is replaced, and kept in the
+// journal's backups so that the write can be undone; a new file is just removed again.
+func (p *Plan) AddWriteFile(path string, data []byte) virErrors.ScopedError {
+	op := Op{Kind: OpWriteFile, To: path, Hash: hashData(data), data: data}
+
+	existing, err := ioutil.ReadFile(filepath.Join(p.Root, path))
+	if err == nil {
+		op.Backup = hashData(existing)
+	} else if !os.IsNotExist(err) {
+		return virErrors.ErrFileWriteFailed("vir/fileops.AddWriteFile", path, err)
+	}
+
+	p.Ops = append(p.Ops, op)
+	return nil
+}
+
+// writeFile carries out a file write, backing up the file it replaces first. The file is written alongside the old
+// one and renamed over it, so nothing is lost if the write fails.
+func writeFile(j *Journal, root string, op *Op) virErrors.ScopedError {
+	fullPath := filepath.Join(root, op.To)
+
+	if op.data == nil {
+		// only plans being executed have the data to write
+		return virErrors.ErrFileWriteFailed("vir/fileops.writeFile", op.To, fmt.Errorf("nothing to write"))
+	}
+
+	existing, err := ioutil.ReadFile(fullPath)
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		return virErrors.ErrFileWriteFailed("vir/fileops.writeFile", op.To, err)
+	case err == nil && hashData(existing) != op.Backup, err != nil && op.Backup != "":
+		return virErrors.ErrFileWriteFailed("vir/fileops.writeFile", op.To, fmt.Errorf("it has changed since the plan was made"))
+	case err == nil:
+		if err := j.saveBackup(op.Backup, existing); err != nil {
+			return err
+		}
+	}
+
+	if err := replaceFile(fullPath, op.data); err != nil {
+		return virErrors.ErrFileWriteFailed("vir/fileops.writeFile", op.To, err)
+	}
+
+	fi, err := os.Lstat(fullPath)
+	if err != nil {
+		return virErrors.ErrFileWriteFailed("vir/fileops.writeFile", op.To, err)
+	}
+	op.Size = fi.Size()
+	op.ModTime = fi.ModTime()
+
+	return nil
+}
+
+// revertWriteFile puts back the file a write replaced, or removes the file it created.
+func revertWriteFile(j *Journal, root string, op Op) virErrors.ScopedError {
+	fullPath := filepath.Join(root, op.To)
+
+	if op.Backup == "" {
+		if err := os.Remove(fullPath); err != nil {
+			return virErrors.ErrFileWriteFailed("vir/fileops.revertWriteFile", op.To, err)
+		}
+		return nil
+	}
+
+	data, err := j.loadBackup(op.Backup)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(fullPath, data); err != nil {
+		return virErrors.ErrFileWriteFailed("vir/fileops.revertWriteFile", op.To, err)
+	}
+	return nil
+}
+
+// checkUndoWriteFile works out whether a file write was carried out and can be reverted. A write the journal has
+// no record of the result of was interrupted, and what is in the file says whether it happened.
+func checkUndoWriteFile(j *Journal, root string, op Op) (bool, string) {
+	fullPath := filepath.Join(root, op.To)
+
+	fi, err := os.Lstat(fullPath)
+	if err != nil && os.IsNotExist(err) {
+		if op.ModTime.IsZero() && op.Backup == "" {
+			// never written
+			return false, ""
+		}
+		return false, fmt.Sprintf("%s no longer exists", op.To)
+	} else if err != nil {
+		return false, fmt.Sprintf("%s: %s", op.To, err)
+	}
+
+	if op.Backup != "" && !j.hasBackup(op.Backup) {
+		return false, fmt.Sprintf("the backup of %s is missing", op.To)
+	}
+
+	if !op.ModTime.IsZero() {
+		if fi.Size() != op.Size || !fi.ModTime().Equal(op.ModTime) {
+			return false, fmt.Sprintf("%s has changed since it was written", op.To)
+		}
+		return true, ""
+	}
+
+	data, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return false, fmt.Sprintf("%s: %s", op.To, err)
+	}
+	switch hashData(data) {
+	case op.Hash:
+		return true, ""
+	case op.Backup:
+		// never written
+		return false, ""
+	default:
+		return false, fmt.Sprintf("%s has changed since it was written", op.To)
+	}
+}
+
+// describeWriteFile says what writing a file does.
+func describeWriteFile(op Op) string {
+	if op.Backup != "" {
+		return fmt.Sprintf("replace %s", op.To)
+	}
+	return fmt.Sprintf("%s %s", op.Kind, op.To)
+}
+
+// describeUndoWriteFile says what undoing a file write does.
+func describeUndoWriteFile(op Op) string {
+	if op.Backup != "" {
+		return fmt.Sprintf("restore %s", op.To)
+	}
+	return fmt.Sprintf("remove %s", op.To)
+}
+
+// replaceFile writes data to a temporary file next to fullPath and renames it into place.
+func replaceFile(fullPath string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".vir-")
+	if err != nil {
+		return err
+	}
+
+	// on success the rename means there's nothing left to remove
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fullPath)
+}
+
+func hashData(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,298 @@
+package index
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArtStatus says how much of an album has embedded cover art.
+type ArtStatus string
+
+// The artwork an album can have, from least to most complete.
+const (
+	// ArtNone is an album without art on any of its tracks.
+	ArtNone ArtStatus = "none"
+	// ArtPartial is an album with the same cover on some of its tracks, and none on the rest.
+	ArtPartial ArtStatus = "partial"
+	// ArtMixed is an album whose tracks have different covers.
+	ArtMixed ArtStatus = "mixed"
+	// ArtComplete is an album with the same cover on every track.
+	ArtComplete ArtStatus = "complete"
+)
+
+// Album is a set of indexed tracks that make up one release, as worked out by GroupAlbums.
+//
+// Artist is the album artist, falling back to the most common track artist, or "Various Artists" for a compilation.
+// Dir is the directory the album is in, which for an album split into a directory per disc is the one above them.
+// Tracks are sorted by disc, then track number, then path.
+//
+// Discs is the number of discs, from the disc totals in the tags or failing that the highest disc number.
+//
+// The rest describe what is wrong with the numbering, and are left empty for the tracks without an album title:
+//   - MissingTracks lists the gaps in the numbering of each disc, up to its track total if the tags give one, or its
+//     highest track number if not.
+//   - DuplicateTracks lists the numbers used by more than one track on the same disc.
+//   - ExtraTracks lists the tracks numbered beyond the track total of their disc.
+//   - MissingDiscs lists the discs without any tracks at all, and ExtraDiscs the discs beyond the disc total.
+type Album struct {
+	Artist          string
+	Title           string
+	Dir             string
+	Year            int
+	Tracks          []Entry
+	Discs           int
+	Duration        time.Duration
+	MissingTracks   []TrackPosition  `json:",omitempty"`
+	DuplicateTracks []DuplicateTrack `json:",omitempty"`
+	ExtraTracks     []TrackPosition  `json:",omitempty"`
+	MissingDiscs    []int            `json:",omitempty"`
+	ExtraDiscs      []int            `json:",omitempty"`
+	Art             ArtStatus
+}
+
+// TrackPosition is the disc and number of a track. Total is the track total of the disc, if the tags give one.
+type TrackPosition struct {
+	Disc   int
+	Number int
+	Total  int `json:",omitempty"`
+}
+
+// DuplicateTrack is a track number used by more than one track on a disc.
+type DuplicateTrack struct {
+	TrackPosition
+	RelFilenames []string
+}
+
+// IsComplete reports whether the album has every track and disc its numbering says it should, and nothing else.
+func (a *Album) IsComplete() bool {
+	return len(a.MissingTracks) == 0 && len(a.DuplicateTracks) == 0 && len(a.ExtraTracks) == 0 &&
+		len(a.MissingDiscs) == 0 && len(a.ExtraDiscs) == 0
+}
+
+// discDirPattern matches the names given to directories holding one disc of an album, e.g. "CD1" or "Disc 02".
+var discDirPattern = regexp.MustCompile(`(?i)^(?:cd|dis[ck])[ _-]*(\d{1,2})$`)
+
+// GroupAlbums groups entries into albums by directory and album title, with the directories for each disc of an album
+// counting as the one above them. The album artist is left out, since it is often only on some of an album's tracks;
+// lint's inconsistent-album-artist rule flags those. Tracks without an album title are grouped by directory into
+// albums without a title, so that every entry belongs to exactly one album.
+//
+// Albums are sorted by artist, year and title, ignoring case.
+func GroupAlbums(entries []Entry) []Album {
+	type albumKey struct {
+		dir, title string
+	}
+
+	var keys []albumKey
+	byKey := make(map[albumKey][]Entry)
+	discOf := make(map[string]int)
+
+	for _, e := range entries {
+		dir := filepath.Dir(e.RelFilename)
+		if m := discDirPattern.FindStringSubmatch(filepath.Base(dir)); m != nil {
+			discOf[e.RelFilename], _ = strconv.Atoi(m[1])
+			dir = filepath.Dir(dir)
+		}
+
+		k := albumKey{dir: dir, title: strings.ToLower(e.Album)}
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], e)
+	}
+
+	albums := make([]Album, 0, len(keys))
+	for _, k := range keys {
+		albums = append(albums, makeAlbum(k.dir, byKey[k], discOf))
+	}
+
+	sort.SliceStable(albums, func(i, j int) bool {
+		a, b := albums[i], albums[j]
+		if !strings.EqualFold(a.Artist, b.Artist) {
+			return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+		}
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		if !strings.EqualFold(a.Title, b.Title) {
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}
+		return a.Dir < b.Dir
+	})
+
+	return albums
+}
+
+// makeAlbum works out everything about an album from its tracks. discOf has the disc numbers implied by the
+// directories of any tracks in a directory per disc.
+func makeAlbum(dir string, entries []Entry, discOf map[string]int) Album {
+	disc := func(e Entry) int {
+		switch {
+		case e.DiscNumber > 0:
+			return e.DiscNumber
+		case discOf[e.RelFilename] > 0:
+			return discOf[e.RelFilename]
+		default:
+			return 1
+		}
+	}
+
+	a := Album{Dir: dir, Tracks: entries}
+	sort.SliceStable(a.Tracks, func(i, j int) bool {
+		di, dj := disc(a.Tracks[i]), disc(a.Tracks[j])
+		if di != dj {
+			return di < dj
+		}
+		if a.Tracks[i].Number != a.Tracks[j].Number {
+			return a.Tracks[i].Number < a.Tracks[j].Number
+		}
+		return a.Tracks[i].RelFilename < a.Tracks[j].RelFilename
+	})
+
+	titles := make(map[string]int)
+	albumArtists := make(map[string]int)
+	artists := make(map[string]int)
+	years := make(map[int]int)
+	compilation := false
+
+	// the tracks with each number on each disc, and the most tracks the tags say each disc has
+	numbers := make(map[int]map[int][]string)
+	totals := make(map[int]int)
+	discTotal := 0
+
+	for _, e := range a.Tracks {
+		titles[e.Album]++
+		if e.AlbumArtist != "" {
+			albumArtists[e.AlbumArtist]++
+		}
+		if e.Artist != "" {
+			artists[e.Artist]++
+		}
+		if e.Year > 0 {
+			years[e.Year]++
+		}
+		compilation = compilation || e.Compilation
+		a.Duration += e.Stream.Duration
+
+		d := disc(e)
+		if numbers[d] == nil {
+			numbers[d] = make(map[int][]string)
+		}
+		if e.Number > 0 {
+			numbers[d][e.Number] = append(numbers[d][e.Number], e.RelFilename)
+		}
+		if e.TrackTotal > totals[d] {
+			totals[d] = e.TrackTotal
+		}
+		if e.DiscTotal > discTotal {
+			discTotal = e.DiscTotal
+		}
+	}
+
+	a.Discs = discTotal
+	for d := range numbers {
+		if d > a.Discs {
+			a.Discs = d
+		}
+	}
+
+	a.Title = mostCommon(titles)
+	switch {
+	case len(albumArtists) > 0:
+		a.Artist = mostCommon(albumArtists)
+	case compilation || len(artists) > 1:
+		a.Artist = "Various Artists"
+	case len(artists) == 1:
+		a.Artist = mostCommon(artists)
+	}
+	for y, n := range years {
+		if n > years[a.Year] || (n == years[a.Year] && y < a.Year) {
+			a.Year = y
+		}
+	}
+
+	// the tracks without an album title in a directory are only grouped together for the sake of it, so whatever
+	// numbering they have says nothing about what is missing
+	if a.Title != "" {
+		checkNumbering(&a, numbers, totals, discTotal)
+	}
+
+	a.Art = albumArt(a.Tracks)
+	return a
+}
+
+// checkNumbering fills in what is wrong with the numbering of an album's tracks and discs.
+func checkNumbering(a *Album, numbers map[int]map[int][]string, totals map[int]int, discTotal int) {
+	for d := 1; d <= a.Discs; d++ {
+		if _, ok := numbers[d]; !ok {
+			a.MissingDiscs = append(a.MissingDiscs, d)
+			continue
+		}
+		if discTotal > 0 && d > discTotal {
+			a.ExtraDiscs = append(a.ExtraDiscs, d)
+		}
+
+		total, highest := totals[d], 0
+		for n := range numbers[d] {
+			if n > highest {
+				highest = n
+			}
+		}
+		last := total
+		if last == 0 {
+			last = highest
+		}
+
+		for n := 1; n <= highest || n <= last; n++ {
+			pos := TrackPosition{Disc: d, Number: n, Total: total}
+			paths := numbers[d][n]
+			switch {
+			case len(paths) == 0 && n <= last:
+				a.MissingTracks = append(a.MissingTracks, pos)
+			case len(paths) > 1:
+				a.DuplicateTracks = append(a.DuplicateTracks, DuplicateTrack{TrackPosition: pos, RelFilenames: paths})
+			}
+			if len(paths) > 0 && total > 0 && n > total {
+				a.ExtraTracks = append(a.ExtraTracks, pos)
+			}
+		}
+	}
+}
+
+// albumArt works out how much of an album has the same embedded cover.
+func albumArt(entries []Entry) ArtStatus {
+	covers := make(map[string]bool)
+	withArt := 0
+	for _, e := range entries {
+		if cover, ok := e.FrontCover(); ok {
+			covers[cover.Hash] = true
+			withArt++
+		}
+	}
+
+	switch {
+	case withArt == 0:
+		return ArtNone
+	case len(covers) > 1:
+		return ArtMixed
+	case withArt < len(entries):
+		return ArtPartial
+	default:
+		return ArtComplete
+	}
+}
+
+// mostCommon returns the value that occurs most often, breaking ties alphabetically. It returns "" for no values.
+func mostCommon(counts map[string]int) string {
+	best, found := "", false
+	for k, n := range counts {
+		if !found || n > counts[best] || (n == counts[best] && k < best) {
+			best, found = k, true
+		}
+	}
+	return best
+}
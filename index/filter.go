@@ -0,0 +1,52 @@
+package index
+
+import (
+	"path/filepath"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// Options controls which files in the music library vir looks at.
+type Options struct {
+	// Include, if not empty, limits the library to files matching at least one of these glob patterns.
+	Include []string
+
+	// Exclude leaves out files and directories matching any of these glob patterns.
+	Exclude []string
+}
+
+// validate checks that every pattern is well-formed, so that a typo doesn't silently match nothing.
+func (opts Options) validate() virErrors.ScopedError {
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return virErrors.ErrInvalidGlobPattern("vir/index.Options", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func (opts Options) isExcluded(relPath string) bool {
+	return matchesAny(opts.Exclude, relPath)
+}
+
+func (opts Options) isIncluded(relPath string) bool {
+	return len(opts.Include) == 0 || matchesAny(opts.Include, relPath)
+}
+
+// matchesAny reports whether a path relative to the root it is in matches any of the patterns.
+// A pattern can match either the whole relative path or just its base name, so "*.log" matches logs anywhere.
+func matchesAny(patterns []string, relPath string) bool {
+	base := filepath.Base(relPath)
+	for _, pattern := range patterns {
+		// the patterns have already been validated, so we can ignore errors
+		if ok, _ := filepath.Match(pattern, relPath); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
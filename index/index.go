@@ -4,87 +4,116 @@
 package index
 
 import (
+	"context"
+
 	"github.com/ceralena/vir/state"
 	"github.com/ceralena/vir/track"
 	"github.com/ceralena/vir/util"
 	"github.com/ceralena/vir/virErrors"
 
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Index represents a Vir music library index.
 type Index interface {
-	Rebuild() virErrors.ScopedError
+	// Re-read every music file and replace the persisted index.
+	// If ctx is done before the scan finishes, the persisted index is left as it was.
+	Rebuild(ctx context.Context, opts ScanOptions) (*UpdateReport, virErrors.ScopedError)
+
+	// Re-read only the music files that are new or have changed since the index was last stored,
+	// and drop entries for files that no longer exist.
+	// If ctx is done before the scan finishes, the persisted index is left as it was.
+	Update(ctx context.Context, opts ScanOptions) (*UpdateReport, virErrors.ScopedError)
 
 	// Yield a full list of music files.
-	ListMusicFiles() <-chan MusicFileListEntry
+	// The walk stops and the channel is closed early once ctx is done, so a consumer can stop reading at any time
+	// by cancelling it.
+	ListMusicFiles(ctx context.Context) <-chan MusicFileListEntry
+
+	// Yield a full list of the files alongside the music that aren't audio, like cover art, cue sheets and logs.
+	// Cancellation works the same way as for ListMusicFiles.
+	ListSidecarFiles(ctx context.Context) <-chan MusicFileListEntry
+
+	// Entries returns every track in the persisted index, sorted by RelFilename.
+	Entries() ([]Entry, virErrors.ScopedError)
+
+	// Roots returns the root directories of the library, in the order they were given.
+	Roots() []Root
+
+	// FullPath returns the path of a file in the library, given its RelFilename.
+	FullPath(relPath string) string
+
+	// Check the audio stream of every indexed track that hasn't been verified since it last changed, storing the
+	// results with its entry. Results for the tracks checked so far are stored even if ctx is done or a track can't
+	// be read before the rest are checked.
+	Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, virErrors.ScopedError)
 }
 
-// MusicFileListEntry is a single entry from ListMusicFiles.
-// It can contain either a RelFilename or an Error.
+// MusicFileListEntry is a single entry from ListMusicFiles or ListSidecarFiles.
+// It can contain either a RelFilename (with the file's size, modification time and detected format) or an Error.
 type MusicFileListEntry struct {
 	RelFilename string
+	Size        int64
+	ModTime     time.Time
+	Format      track.Format
 	Error       virErrors.ScopedError
 }
 
 // LoadIndex loads a vir index from a given root directory.
-func LoadIndex(musicLibraryRoot string) (Index, virErrors.ScopedError) {
-	// check that the music dir actually exists
-	err := checkMusicLibraryRootExists(musicLibraryRoot)
-	if err != nil {
-		return nil, err
-	}
-
-	// initialize a state cache
-	stateCache, err := state.GetStateCache()
-	if err != nil {
-		return nil, err
-	}
-
-	// return an Index interface
-	return &index{musicLibraryRootDir: musicLibraryRoot, stateCache: stateCache}, nil
+func LoadIndex(musicLibraryRoot string, opts Options) (Index, virErrors.ScopedError) {
+	return LoadLibrary(Library{Roots: []string{musicLibraryRoot}}, opts)
 }
 
 type index struct {
-	musicLibraryRootDir string
-	stateCache          state.Cache
+	library    Library
+	roots      []Root
+	opts       Options
+	stateCache state.Cache
+
+	// legacyStateCache is where an index stored before vir knew about libraries is found, for a library given with
+	// --music-root. It is nil for every other library.
+	legacyStateCache state.Cache
 }
 
-func (idx *index) getFullPath(relPath string) string {
-	return filepath.Join(idx.musicLibraryRootDir, relPath)
+func (idx *index) Roots() []Root {
+	return append([]Root(nil), idx.roots...)
 }
 
-func (idx *index) Rebuild() virErrors.ScopedError {
-	err := idx.stateCache.Set("musicRoot", []byte(idx.musicLibraryRootDir))
-
-	if err != nil {
-		return err
+// rootOf returns the root a file is in, given its RelFilename.
+func (idx *index) rootOf(relPath string) Root {
+	if len(idx.roots) == 1 {
+		return idx.roots[0]
 	}
-
-	files := idx.ListMusicFiles()
-
-	for fileEntry := range files {
-		if fileEntry.Error != nil {
-			// FIXME(cera)- we shouldn't just give up here
-			// we'll actually get an orphaned goroutine if we do!
-			return fileEntry.Error
+	label := relPath
+	if i := strings.IndexRune(relPath, filepath.Separator); i >= 0 {
+		label = relPath[:i]
+	}
+	for _, root := range idx.roots {
+		if root.Label == label {
+			return root
 		}
+	}
+	return idx.roots[0]
+}
 
-		tr, err := track.LoadTrackFromPath(idx.getFullPath(fileEntry.RelFilename))
-		if err != nil {
-			// FIXME(cera)- we shouldn't just give up here
-			// we'll actually get an orphaned goroutine if we do!
-			return err
-		}
+func (idx *index) FullPath(relPath string) string {
+	root := idx.rootOf(relPath)
+	return filepath.Join(root.Dir, root.RelPath(relPath))
+}
 
-		// XXX(cera) - do something else here
-		fmt.Printf("%#v\n", tr)
-	}
+func (idx *index) Rebuild(ctx context.Context, opts ScanOptions) (*UpdateReport, virErrors.ScopedError) {
+	return idx.scan(ctx, nil, opts)
+}
 
-	return nil
+func (idx *index) Entries() ([]Entry, virErrors.ScopedError) {
+	si, err := idx.loadStoredIndex()
+	if err != nil {
+		return nil, err
+	}
+	return si.Entries, nil
 }
 
 func stripRootDirFromPath(rootDir, path string) string {
@@ -95,39 +124,109 @@ func stripRootDirFromPath(rootDir, path string) string {
 	return strippedPath
 }
 
-func (idx *index) ListMusicFiles() <-chan MusicFileListEntry {
+func (idx *index) ListMusicFiles(ctx context.Context) <-chan MusicFileListEntry {
+	return idx.listFiles(ctx, true)
+}
+
+func (idx *index) ListSidecarFiles(ctx context.Context) <-chan MusicFileListEntry {
+	return idx.listFiles(ctx, false)
+}
+
+// listFiles walks each of the library's roots in turn, yielding either the audio files or everything else.
+// Files and directories left out by the index Options are skipped either way.
+//
+// A file or directory that can't be read is reported as an entry with an Error, and the walk carries on;
+// it is up to the consumer whether to give up.
+func (idx *index) listFiles(ctx context.Context, audio bool) <-chan MusicFileListEntry {
 	ch := make(chan MusicFileListEntry)
 
 	go func() {
 		defer close(ch)
-		walkFunc := func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info.IsDir() {
+
+		// send an entry to the channel, unless nobody is listening any more
+		send := func(entry MusicFileListEntry) error {
+			select {
+			case ch <- entry:
 				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		for _, root := range idx.roots {
+			err := filepath.Walk(root.Dir, idx.walkFunc(ctx, root, audio, send))
+			if err != nil && ctx.Err() == nil {
+				_ = send(MusicFileListEntry{
+					Error: virErrors.ErrMusicLibraryWalkError("vir/index.listFiles", err),
+				})
 			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
 
-			// strip the path of the root prefix
-			// XXX(cera) - do we actually want to strip the root dir here, if we're just putting it back
-			// whenever we have to read or write with the file?
-			strippedPath := stripRootDirFromPath(idx.musicLibraryRootDir, path)
+// walkFunc returns the filepath.WalkFunc listFiles uses for one root. The index Options are matched against paths
+// relative to the root, so that they mean the same thing however many roots the library has.
+func (idx *index) walkFunc(ctx context.Context, root Root, audio bool, send func(MusicFileListEntry) error) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-			// send it to the channel
-			ch <- MusicFileListEntry{strippedPath, nil}
+		// strip the path of the root prefix
+		// XXX(cera) - do we actually want to strip the root dir here, if we're just putting it back
+		// whenever we have to read or write with the file?
+		strippedPath := ""
+		if path != root.Dir {
+			strippedPath = stripRootDirFromPath(root.Dir, path)
+		}
+
+		if strippedPath != "" && idx.opts.isExcluded(strippedPath) {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		err := filepath.Walk(idx.musicLibraryRootDir, walkFunc)
 		if err != nil {
-			ch <- MusicFileListEntry{
-				RelFilename: "",
-				Error:       virErrors.ErrMusicLibraryWalkError("vir/index.ListMusicFiles", err),
-			}
+			return send(MusicFileListEntry{
+				RelFilename: root.LibraryRelPath(strippedPath),
+				Error:       walkError(path, err),
+			})
+		}
+		if !info.Mode().IsRegular() || !idx.opts.isIncluded(strippedPath) {
+			return nil
 		}
-	}()
 
-	return ch
+		format, detectErr := track.DetectFormat(path)
+		if detectErr != nil {
+			return send(MusicFileListEntry{
+				RelFilename: root.LibraryRelPath(strippedPath),
+				Error:       detectErr,
+			})
+		}
+		if format.IsAudio() != audio {
+			return nil
+		}
+
+		return send(MusicFileListEntry{
+			RelFilename: root.LibraryRelPath(strippedPath),
+			Size:        info.Size(),
+			ModTime:     info.ModTime(),
+			Format:      format,
+		})
+	}
+}
+
+func walkError(path string, err error) virErrors.ScopedError {
+	if os.IsPermission(err) {
+		return virErrors.ErrPermissionDenied("vir/index.listFiles", path, err)
+	}
+	return virErrors.ErrMusicLibraryWalkError("vir/index.listFiles", err)
 }
 
 func checkMusicLibraryRootExists(musicLibraryRoot string) virErrors.ScopedError {
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceralena/vir/state"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// Library is a music library made up of one or more root directories, indexed together.
+type Library struct {
+	// Name is the name of the library in vir's config file, or "" for one given on the command line with
+	// --music-root. Each library's index is kept apart from every other library's.
+	Name string
+
+	// Roots are the directories the music is in.
+	Roots []string
+}
+
+// Root is one of the directories a library is made up of.
+//
+// Label is what the RelFilename of every file in it starts with, so that files with the same path in different
+// roots can be told apart. It is the base name of Dir, made unique with a number if needs be, or "" in a library with
+// only one root, whose RelFilenames are simply relative to it.
+type Root struct {
+	Label string
+	Dir   string
+}
+
+// RelPath returns the path of a file in the root relative to its directory, given its RelFilename.
+func (r Root) RelPath(relFilename string) string {
+	if r.Label == "" {
+		return relFilename
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(relFilename, r.Label), string(filepath.Separator))
+}
+
+// LibraryRelPath returns the RelFilename of a file in the root, given its path relative to the root's directory.
+func (r Root) LibraryRelPath(relPath string) string {
+	if r.Label == "" {
+		return relPath
+	}
+	return filepath.Join(r.Label, relPath)
+}
+
+// LoadLibrary loads the index of a library.
+func LoadLibrary(lib Library, opts Options) (Index, virErrors.ScopedError) {
+	if len(lib.Roots) == 0 {
+		return nil, virErrors.ErrMusicLibraryRootNotGiven("vir/index.LoadLibrary")
+	}
+
+	for _, root := range lib.Roots {
+		// check that the music dir actually exists
+		if err := checkMusicLibraryRootExists(root); err != nil {
+			return nil, err
+		}
+	}
+
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// each library has a state cache of its own
+	stateCache, err := state.GetLibraryStateCache(libraryKey(lib))
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index{library: lib, roots: makeRoots(lib.Roots), opts: opts, stateCache: stateCache}
+
+	// an index stored before vir knew about libraries has to be found where it was kept before
+	if lib.Name == "" && len(lib.Roots) == 1 {
+		idx.legacyStateCache, err = state.GetStateCache()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+// libraryKey is the key a library's state is kept under: its name, or for a library without one a hash of its root.
+// A name with characters the state cache can't have in a key, like the dot in "my.music", is hashed too.
+func libraryKey(lib Library) string {
+	if lib.Name != "" && isPlainName(lib.Name) {
+		return "named-" + lib.Name
+	}
+
+	h := sha1.New()
+	if lib.Name != "" {
+		h.Write([]byte(lib.Name))
+		return "name-" + hex.EncodeToString(h.Sum(nil))[:12]
+	}
+
+	for _, root := range lib.Roots {
+		fmt.Fprintf(h, "%s\x00", filepath.Clean(root))
+	}
+	return "root-" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// isPlainName reports whether a library name is made up of only letters, digits, dashes and underscores.
+func isPlainName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// makeRoots labels each of a library's root directories.
+func makeRoots(dirs []string) []Root {
+	roots := make([]Root, len(dirs))
+	if len(dirs) == 1 {
+		roots[0] = Root{Dir: dirs[0]}
+		return roots
+	}
+
+	used := make(map[string]bool)
+	for i, dir := range dirs {
+		base := filepath.Base(filepath.Clean(dir))
+		if base == "." || base == string(filepath.Separator) {
+			base = "root"
+		}
+
+		label := base
+		for n := 2; used[label]; n++ {
+			label = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[label] = true
+
+		roots[i] = Root{Label: label, Dir: dir}
+	}
+	return roots
+}
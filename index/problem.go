@@ -0,0 +1,43 @@
+package index
+
+import (
+	"github.com/ceralena/vir/virErrors"
+)
+
+// ProblemKind groups problems by what went wrong.
+type ProblemKind string
+
+// The kinds of problems a scan or a verification can run into.
+const (
+	ProblemWalkError          ProblemKind = "walk error"
+	ProblemPermissionDenied   ProblemKind = "permission denied"
+	ProblemMetadataLoadFailed ProblemKind = "metadata load failed"
+	ProblemHashFailed         ProblemKind = "hash failed"
+	ProblemVerifyFailed       ProblemKind = "verify failed"
+)
+
+// Problem is a file that had to be skipped by a scan or a verification.
+// RelFilename is empty if the problem wasn't specific to a single file.
+type Problem struct {
+	RelFilename string
+	Kind        ProblemKind
+	Error       virErrors.ScopedError
+}
+
+func newProblem(relFilename string, kind ProblemKind, err virErrors.ScopedError) *Problem {
+	if virErrors.IsPermissionDenied(err) {
+		kind = ProblemPermissionDenied
+	}
+	return &Problem{RelFilename: relFilename, Kind: kind, Error: err}
+}
+
+// Problem describes the error in a MusicFileListEntry. It must only be called on entries with an Error.
+func (e MusicFileListEntry) Problem() Problem {
+	return *newProblem(e.RelFilename, ProblemWalkError, e.Error)
+}
+
+type problemsByRelFilename []Problem
+
+func (p problemsByRelFilename) Len() int           { return len(p) }
+func (p problemsByRelFilename) Less(i, j int) bool { return p[i].RelFilename < p[j].RelFilename }
+func (p problemsByRelFilename) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,229 @@
+package index
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/util"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// ScanOptions controls how Rebuild and Update read the music library.
+type ScanOptions struct {
+	// Jobs is the number of files read concurrently. Zero or less means one per CPU.
+	Jobs int
+
+	// HashContents compares a hash of each file's contents as well as its size and modification time.
+	// It is much slower, but catches edits that preserve both.
+	HashContents bool
+
+	// HashAudio fills in the audio hash of every entry that doesn't have one yet. The hash is kept along with the
+	// rest of the entry for as long as the file's size and modification time don't change, so only new and changed
+	// files are read for it.
+	HashAudio bool
+
+	// KeepGoing skips files that can't be read, reporting them as Problems, rather than giving up on the first one.
+	KeepGoing bool
+}
+
+func (opts ScanOptions) jobs() int {
+	return jobCount(opts.Jobs)
+}
+
+// jobCount is the number of workers to use for a Jobs option, which means one per CPU if it is zero or less.
+func jobCount(jobs int) int {
+	if jobs > 0 {
+		return jobs
+	}
+	return runtime.NumCPU()
+}
+
+type scanStatus int
+
+const (
+	scanAdded scanStatus = iota
+	scanChanged
+	scanUnchanged
+)
+
+// scanResult is the outcome of scanning a single music file: an entry, a problem, or both for an entry whose audio
+// couldn't be hashed.
+type scanResult struct {
+	entry   Entry
+	status  scanStatus
+	problem *Problem
+}
+
+// scan walks the music library and stores a new index, reusing any entry from previous that is still current.
+//
+// Files are read by a pool of workers. Unless opts.KeepGoing is set, the first problem stops the walk and the
+// workers, and nothing is stored. Nothing is stored if parent is done before the scan finishes, either.
+func (idx *index) scan(parent context.Context, previous []Entry, opts ScanOptions) (*UpdateReport, virErrors.ScopedError) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	known := make(map[string]Entry, len(previous))
+	for _, e := range previous {
+		known[e.RelFilename] = e
+	}
+
+	files := idx.ListMusicFiles(ctx)
+	results := make(chan scanResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileEntry := range files {
+				res := idx.scanFile(fileEntry, known, opts)
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := &UpdateReport{}
+	var entries []Entry
+	var scanErr virErrors.ScopedError
+	seen := make(map[string]bool, len(known))
+
+	for res := range results {
+		if scanErr != nil {
+			// we're only draining so the workers can exit
+			continue
+		}
+
+		if res.problem != nil && !opts.KeepGoing {
+			scanErr = res.problem.Error
+			cancel()
+			continue
+		} else if res.problem != nil {
+			// a skipped file is left out of the index, so if it was indexed before it counts as removed
+			report.Problems = append(report.Problems, *res.problem)
+			if res.entry.RelFilename == "" {
+				continue
+			}
+		}
+
+		seen[res.entry.RelFilename] = true
+		entries = append(entries, res.entry)
+
+		switch res.status {
+		case scanAdded:
+			report.Added++
+		case scanChanged:
+			report.Changed++
+		case scanUnchanged:
+			report.Unchanged++
+		}
+	}
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	if parent.Err() != nil {
+		return nil, virErrors.ErrInterrupted("vir/index.scan")
+	}
+
+	for relFilename := range known {
+		if !seen[relFilename] {
+			report.Removed++
+		}
+	}
+	sort.Sort(problemsByRelFilename(report.Problems))
+
+	err := idx.saveStoredIndex(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// scanFile produces the index entry for a single file, only reading its tags if the known entry is out of date.
+//
+// known is shared between workers and must not be modified while they are running.
+func (idx *index) scanFile(fileEntry MusicFileListEntry, known map[string]Entry, opts ScanOptions) scanResult {
+	if fileEntry.Error != nil {
+		problem := fileEntry.Problem()
+		return scanResult{problem: &problem}
+	}
+
+	fullPath := idx.FullPath(fileEntry.RelFilename)
+	old, seen := known[fileEntry.RelFilename]
+
+	var contentHash string
+	if opts.HashContents {
+		var hashErr error
+		contentHash, hashErr = util.HashFile(fullPath)
+		if hashErr != nil {
+			err := virErrors.ErrFileHashFailed("vir/index.scanFile", fullPath, hashErr)
+			if os.IsPermission(hashErr) {
+				err = virErrors.ErrPermissionDenied("vir/index.scanFile", fullPath, hashErr)
+			}
+			return scanResult{problem: newProblem(fileEntry.RelFilename, ProblemHashFailed, err)}
+		}
+	}
+
+	if seen && isEntryCurrent(old, fileEntry, contentHash) {
+		if contentHash != "" {
+			old.ContentHash = contentHash
+		}
+		res := scanResult{entry: old, status: scanUnchanged}
+		if (opts.HashContents || opts.HashAudio) && old.AudioHash == "" {
+			return withAudioHash(res)
+		}
+		return res
+	}
+
+	tr, err := track.LoadTrackFromPath(fullPath)
+	if err != nil {
+		return scanResult{problem: newProblem(fileEntry.RelFilename, ProblemMetadataLoadFailed, err)}
+	}
+
+	res := scanResult{
+		entry: Entry{
+			RelFilename: fileEntry.RelFilename,
+			Size:        fileEntry.Size,
+			ModTime:     fileEntry.ModTime,
+			ContentHash: contentHash,
+			Track:       *tr,
+		},
+		status: scanAdded,
+	}
+	if seen {
+		res.status = scanChanged
+	}
+
+	if opts.HashContents || opts.HashAudio {
+		return withAudioHash(res)
+	}
+	return res
+}
+
+// withAudioHash fills in the audio hash of a scanned entry. An entry whose audio can't be hashed is kept without a
+// hash, along with the problem.
+func withAudioHash(res scanResult) scanResult {
+	audioHash, err := track.AudioHash(res.entry.FullPath)
+	if err != nil {
+		res.problem = newProblem(res.entry.RelFilename, ProblemHashFailed, err)
+		return res
+	}
+
+	res.entry.AudioHash = audioHash
+	return res
+}
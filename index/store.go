@@ -0,0 +1,179 @@
+package index
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ceralena/vir/state"
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// indexCacheKey is the state cache key the index is persisted under.
+const indexCacheKey = "index"
+
+// Entry is a single track in the persisted index.
+//
+// ContentHash and AudioHash are only filled in by scans with HashContents set.
+// AudioHash is empty for formats track.AudioHash doesn't support.
+// Verification is filled in by Verify, and dropped again whenever a scan finds the file has changed.
+type Entry struct {
+	RelFilename  string
+	Size         int64
+	ModTime      time.Time
+	ContentHash  string              `json:",omitempty"`
+	AudioHash    string              `json:",omitempty"`
+	Verification *track.Verification `json:",omitempty"`
+	track.Track
+}
+
+// entryFormat is bumped whenever what Entry holds about the music files themselves changes, so that entries stored
+// before then are read again by the next Update rather than trusted with new fields left empty or stale values.
+const entryFormat = 10
+
+// storedIndex is the on-disk representation of an index.
+//
+// Version is the state version that wrote it; anything else has to go through migrateStoredIndex before use.
+// EntryFormat is the entryFormat it was written with, which is 0 for indexes from before it existed.
+// MusicRoots are the roots of the library it is for; indexes from before libraries could have more than one root
+// have MusicRoot instead.
+type storedIndex struct {
+	Version     string
+	EntryFormat int
+	MusicRoots  []string
+	MusicRoot   string `json:",omitempty"`
+	Entries     []Entry
+}
+
+func (idx *index) loadStoredIndex() (*storedIndex, virErrors.ScopedError) {
+	si, err := idx.readStoredIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if si == nil {
+		return nil, virErrors.ErrIndexNotBuilt("vir/index.loadStoredIndex")
+	}
+
+	return si, nil
+}
+
+// readStoredIndex is like loadStoredIndex, but returns nil without an error if no index has been stored yet.
+func (idx *index) readStoredIndex() (*storedIndex, virErrors.ScopedError) {
+	si, err := decodeStoredIndex(idx.stateCache)
+	if err != nil {
+		return nil, err
+	}
+
+	if si == nil && idx.legacyStateCache != nil {
+		// the one index kept before vir knew about libraries could have been for any root, so it only counts if it
+		// was for this one; it moves to where this library's index is kept the next time it is saved
+		si, err = decodeStoredIndex(idx.legacyStateCache)
+		if err != nil {
+			return nil, err
+		}
+		if si != nil && !sameRoots(si.MusicRoots, idx.library.Roots) {
+			si = nil
+		}
+	}
+
+	if si == nil {
+		return nil, nil
+	}
+
+	if !sameRoots(si.MusicRoots, idx.library.Roots) {
+		return nil, virErrors.ErrIndexRootMismatch("vir/index.readStoredIndex", strings.Join(si.MusicRoots, ", "),
+			strings.Join(idx.library.Roots, ", "))
+	}
+
+	return si, nil
+}
+
+// decodeStoredIndex reads the index kept in a state cache, returning nil without an error if there isn't one.
+func decodeStoredIndex(stateCache state.Cache) (*storedIndex, virErrors.ScopedError) {
+	b, err := stateCache.Get(indexCacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if b == nil {
+		return nil, nil
+	}
+
+	si := &storedIndex{}
+	if jsonErr := json.Unmarshal(b, si); jsonErr != nil {
+		return nil, virErrors.ErrIndexDecodeFailed("vir/index.readStoredIndex", jsonErr)
+	}
+
+	err = migrateStoredIndex(si)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(si.MusicRoots) == 0 && si.MusicRoot != "" {
+		si.MusicRoots, si.MusicRoot = []string{si.MusicRoot}, ""
+	}
+
+	return si, nil
+}
+
+func sameRoots(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *index) saveStoredIndex(entries []Entry) virErrors.ScopedError {
+	sort.Sort(entriesByRelFilename(entries))
+
+	si := &storedIndex{
+		Version:     state.Version(),
+		EntryFormat: entryFormat,
+		MusicRoots:  idx.library.Roots,
+		Entries:     entries,
+	}
+
+	b, jsonErr := json.Marshal(si)
+	if jsonErr != nil {
+		return virErrors.ErrIndexEncodeFailed("vir/index.saveStoredIndex", jsonErr)
+	}
+
+	return idx.stateCache.Set(indexCacheKey, b)
+}
+
+// migrateStoredIndex brings an index written by an older state version up to date in place.
+//
+// There has only ever been one version, so all we can do for now is reject anything unknown.
+func migrateStoredIndex(si *storedIndex) virErrors.ScopedError {
+	switch si.Version {
+	case state.Version():
+		return nil
+	default:
+		return virErrors.ErrIndexVersionUnsupported("vir/index.migrateStoredIndex", si.Version)
+	}
+}
+
+// staleEntries returns the entries of an index written with an older entryFormat, marked so that isEntryCurrent
+// never accepts them. They are still worth passing to scan, so that it reports them as changed rather than added.
+func staleEntries(entries []Entry) []Entry {
+	stale := make([]Entry, len(entries))
+	for i, e := range entries {
+		e.ModTime = time.Time{}
+		stale[i] = e
+	}
+	return stale
+}
+
+type entriesByRelFilename []Entry
+
+func (e entriesByRelFilename) Len() int           { return len(e) }
+func (e entriesByRelFilename) Less(i, j int) bool { return e[i].RelFilename < e[j].RelFilename }
+func (e entriesByRelFilename) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
@@ -0,0 +1,52 @@
+package index
+
+import (
+	"context"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// UpdateReport counts what happened to the tracks in the index during an Update.
+//
+// Problems lists the files that were skipped, and those kept without an audio hash because their audio couldn't be
+// hashed, sorted by RelFilename. It can only be non-empty for a scan with KeepGoing set. A skipped file that was in
+// the index before is dropped from it, and counted in Removed as well.
+type UpdateReport struct {
+	Added     int
+	Changed   int
+	Removed   int
+	Unchanged int
+	Problems  []Problem
+}
+
+func (idx *index) Update(ctx context.Context, opts ScanOptions) (*UpdateReport, virErrors.ScopedError) {
+	si, err := idx.readStoredIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []Entry
+	if si != nil && si.EntryFormat < entryFormat {
+		previous = staleEntries(si.Entries)
+	} else if si != nil {
+		previous = si.Entries
+	}
+
+	return idx.scan(ctx, previous, opts)
+}
+
+// isEntryCurrent reports whether a stored entry still describes the file found on disk.
+//
+// contentHash is only compared when it is set and the entry already has a hash of its own;
+// an entry indexed without hashing just has its hash filled in.
+func isEntryCurrent(old Entry, fileEntry MusicFileListEntry, contentHash string) bool {
+	if old.Size != fileEntry.Size || !old.ModTime.Equal(fileEntry.ModTime) {
+		return false
+	}
+
+	if contentHash != "" && old.ContentHash != "" {
+		return contentHash == old.ContentHash
+	}
+
+	return true
+}
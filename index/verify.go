@@ -0,0 +1,145 @@
+package index
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ceralena/vir/track"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// VerifyOptions controls how Verify checks the indexed tracks.
+type VerifyOptions struct {
+	// Jobs is the number of files checked concurrently. Zero or less means one per CPU.
+	Jobs int
+
+	// Recheck checks every track again, even those verified since they last changed.
+	Recheck bool
+
+	// KeepGoing skips files that can't be read, reporting them as Problems, rather than giving up on the first one.
+	KeepGoing bool
+}
+
+// VerifyReport counts what happened to the tracks in the index during a Verify.
+//
+// Verified is the number of tracks checked, and Skipped the number that had already been verified since they last
+// changed. Unsupported is the number in formats track.VerifyStream can't check.
+// Problems lists the files that couldn't be checked, sorted by RelFilename. It can only be non-empty with KeepGoing
+// set.
+type VerifyReport struct {
+	Verified    int
+	Skipped     int
+	Unsupported int
+	Problems    []Problem
+}
+
+// verifyResult is the outcome of checking the entry at an index: either a verification or a problem.
+type verifyResult struct {
+	i            int
+	verification *track.Verification
+	problem      *Problem
+}
+
+func (idx *index) Verify(parent context.Context, opts VerifyOptions) (*VerifyReport, virErrors.ScopedError) {
+	si, err := idx.loadStoredIndex()
+	if err != nil {
+		return nil, err
+	}
+	entries := si.Entries
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	report := &VerifyReport{}
+	var todo []int
+	for i, e := range entries {
+		switch {
+		case !e.Format.CanVerifyStream():
+			report.Unsupported++
+		case e.Verification != nil && !opts.Recheck:
+			report.Skipped++
+		default:
+			todo = append(todo, i)
+		}
+	}
+
+	work := make(chan int)
+	go func() {
+		defer close(work)
+		for _, i := range todo {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan verifyResult)
+	var wg sync.WaitGroup
+	for i := 0; i < jobCount(opts.Jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				res := verifyEntry(i, entries[i])
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var verifyErr virErrors.ScopedError
+	for res := range results {
+		if verifyErr != nil {
+			// we're only draining so the workers can exit
+			continue
+		}
+
+		if res.problem != nil && !opts.KeepGoing {
+			verifyErr = res.problem.Error
+			cancel()
+			continue
+		} else if res.problem != nil {
+			report.Problems = append(report.Problems, *res.problem)
+			continue
+		}
+
+		entries[res.i].Verification = res.verification
+		report.Verified++
+	}
+
+	if verifyErr == nil && parent.Err() != nil {
+		verifyErr = virErrors.ErrInterrupted("vir/index.Verify")
+	}
+	sort.Sort(problemsByRelFilename(report.Problems))
+
+	// every verification we finished is still good, so keep them even if we're giving up
+	if report.Verified > 0 {
+		if err := idx.saveStoredIndex(entries); err != nil {
+			return nil, err
+		}
+	}
+
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+	return report, nil
+}
+
+func verifyEntry(i int, e Entry) verifyResult {
+	v, err := track.VerifyStream(e.FullPath)
+	if err != nil {
+		return verifyResult{i: i, problem: newProblem(e.RelFilename, ProblemVerifyFailed, err)}
+	}
+	return verifyResult{i: i, verification: v}
+}
@@ -0,0 +1,128 @@
+// Package lint checks the tags in a vir index for problems
+// it provides a pluggable set of rules over the tracks in each directory of a music library
+package lint
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/ceralena/vir/index"
+)
+
+// Severity is how much a finding matters.
+type Severity string
+
+// The severities a finding can have, from least to most serious.
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// SeverityOff turns a rule off when given to WithSeverities. No finding ever has it.
+const SeverityOff Severity = "off"
+
+// IsValid reports whether s is one of the severities, or SeverityOff.
+func (s Severity) IsValid() bool {
+	switch s {
+	case SeverityInfo, SeverityWarning, SeverityError, SeverityOff:
+		return true
+	default:
+		return false
+	}
+}
+
+// Finding is a single problem found by a rule.
+type Finding struct {
+	RuleID      string
+	Severity    Severity
+	RelFilename string
+	Message     string
+}
+
+// Rule is a single check.
+//
+// Rules are given the tracks of one directory at a time, since that is the closest thing vir has to an album;
+// rules about individual tracks just look at each of them in turn.
+type Rule interface {
+	// ID is a short, stable, kebab-case name for the rule.
+	ID() string
+
+	// Severity is the severity of the rule's findings.
+	Severity() Severity
+
+	// Check returns the findings for the tracks in a directory. It does not need to fill in RuleID or Severity.
+	Check(dir string, entries []index.Entry) []Finding
+}
+
+// WithSeverities returns the rules with the severities of some of them changed, by rule ID.
+// Rules given SeverityOff are left out altogether.
+func WithSeverities(rules []Rule, severities map[string]Severity) []Rule {
+	var changed []Rule
+	for _, rule := range rules {
+		severity, ok := severities[rule.ID()]
+		switch {
+		case !ok:
+			changed = append(changed, rule)
+		case severity != SeverityOff:
+			changed = append(changed, severityRule{rule, severity})
+		}
+	}
+	return changed
+}
+
+// severityRule is a Rule with its severity changed.
+type severityRule struct {
+	Rule
+	severity Severity
+}
+
+func (r severityRule) Severity() Severity { return r.severity }
+
+// Run checks the entries of an index against each of the rules.
+// Findings are sorted by path, then rule ID.
+func Run(entries []index.Entry, rules []Rule) []Finding {
+	var findings []Finding
+
+	dirs, byDir := groupByDir(entries)
+	for _, dir := range dirs {
+		for _, rule := range rules {
+			for _, f := range rule.Check(dir, byDir[dir]) {
+				f.RuleID = rule.ID()
+				f.Severity = rule.Severity()
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	sort.Stable(findingsByPath(findings))
+	return findings
+}
+
+// groupByDir groups entries by the directory they are in, returning the sorted directory names alongside.
+func groupByDir(entries []index.Entry) ([]string, map[string][]index.Entry) {
+	var dirs []string
+	byDir := make(map[string][]index.Entry)
+
+	for _, e := range entries {
+		dir := filepath.Dir(e.RelFilename)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], e)
+	}
+
+	sort.Strings(dirs)
+	return dirs, byDir
+}
+
+type findingsByPath []Finding
+
+func (f findingsByPath) Len() int { return len(f) }
+func (f findingsByPath) Less(i, j int) bool {
+	if f[i].RelFilename != f[j].RelFilename {
+		return f[i].RelFilename < f[j].RelFilename
+	}
+	return f[i].RuleID < f[j].RuleID
+}
+func (f findingsByPath) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
@@ -0,0 +1,385 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ceralena/vir/index"
+)
+
+// DefaultRules returns the rules vir checks when none are chosen explicitly.
+func DefaultRules() []Rule {
+	return []Rule{
+		missingFieldRule("missing-title", "title", func(e index.Entry) bool { return e.Title == "" }),
+		missingFieldRule("missing-artist", "artist", func(e index.Entry) bool { return e.Artist == "" }),
+		missingFieldRule("missing-album", "album", func(e index.Entry) bool { return e.Album == "" }),
+		missingFieldRule("missing-track-number", "track number", func(e index.Entry) bool { return e.Number < 0 }),
+		trackRule{"track-number-out-of-range", SeverityError, checkTrackNumberRange},
+		dirRule{"duplicate-track-number", SeverityError, checkDuplicateTrackNumbers},
+		dirRule{"inconsistent-album", SeverityWarning, consistencyCheck("album", func(e index.Entry) string { return e.Album })},
+		dirRule{"inconsistent-artist", SeverityWarning, checkInconsistentArtist},
+		dirRule{"inconsistent-album-artist", SeverityWarning, checkInconsistentAlbumArtist},
+		trackRule{"id3v1-only", SeverityWarning, checkID3v1Only},
+		dirRule{"mixed-tag-versions", SeverityInfo, checkMixedTagVersions},
+		trackRule{"low-bitrate", SeverityWarning, checkLowBitrate},
+		dirRule{"inconsistent-encoding", SeverityWarning, checkInconsistentEncoding},
+		trackRule{"damaged-stream", SeverityError, checkDamagedStream},
+		trackRule{"missing-art", SeverityWarning, checkMissingArt},
+		trackRule{"undersized-art", SeverityWarning, checkUndersizedArt},
+		dirRule{"inconsistent-art", SeverityWarning, checkInconsistentArt},
+	}
+}
+
+// minArtSize is the smallest width and height of cover art that isn't flagged as undersized.
+const minArtSize = 500
+
+// lowBitrate is the average bitrate in kbps below which lossy tracks are flagged.
+const lowBitrate = 128
+
+// trackRule is a Rule that looks at each track on its own.
+type trackRule struct {
+	id       string
+	severity Severity
+	check    func(e index.Entry) (string, bool)
+}
+
+func (r trackRule) ID() string         { return r.id }
+func (r trackRule) Severity() Severity { return r.severity }
+
+func (r trackRule) Check(_ string, entries []index.Entry) []Finding {
+	var findings []Finding
+	for _, e := range entries {
+		if msg, failed := r.check(e); failed {
+			findings = append(findings, Finding{RelFilename: e.RelFilename, Message: msg})
+		}
+	}
+	return findings
+}
+
+// dirRule is a Rule that needs to compare the tracks in a directory with each other.
+type dirRule struct {
+	id       string
+	severity Severity
+	check    func(entries []index.Entry) []Finding
+}
+
+func (r dirRule) ID() string         { return r.id }
+func (r dirRule) Severity() Severity { return r.severity }
+
+func (r dirRule) Check(_ string, entries []index.Entry) []Finding {
+	return r.check(entries)
+}
+
+func missingFieldRule(id, field string, missing func(e index.Entry) bool) Rule {
+	return trackRule{id, SeverityWarning, func(e index.Entry) (string, bool) {
+		return "no " + field + " in tags", missing(e)
+	}}
+}
+
+func checkTrackNumberRange(e index.Entry) (string, bool) {
+	if e.Number < 0 {
+		// missing-track-number covers this
+		return "", false
+	}
+	if e.Number == 0 {
+		return "track number is 0", true
+	}
+	if e.TrackTotal > 0 && e.Number > e.TrackTotal {
+		return fmt.Sprintf("track number %d is more than the total of %d", e.Number, e.TrackTotal), true
+	}
+	return "", false
+}
+
+func checkDuplicateTrackNumbers(entries []index.Entry) []Finding {
+	// tracks on different discs of a set can share a number
+	type position struct{ disc, number int }
+
+	byPosition := make(map[position][]index.Entry)
+	for _, e := range entries {
+		if e.Number >= 0 {
+			pos := position{e.DiscNumber, e.Number}
+			byPosition[pos] = append(byPosition[pos], e)
+		}
+	}
+
+	var findings []Finding
+	for pos, dupes := range byPosition {
+		if len(dupes) < 2 {
+			continue
+		}
+
+		msg := fmt.Sprintf("track number %d is used by %d tracks in this directory", pos.number, len(dupes))
+		if pos.disc > 0 {
+			msg = fmt.Sprintf("track number %d on disc %d is used by %d tracks in this directory", pos.number, pos.disc, len(dupes))
+		}
+		for _, e := range dupes {
+			findings = append(findings, Finding{RelFilename: e.RelFilename, Message: msg})
+		}
+	}
+	return findings
+}
+
+// consistencyCheck flags tracks whose field differs from the most common value of that field in the directory.
+// Tracks without the field at all are left to the missing-* rules.
+func consistencyCheck(field string, value func(e index.Entry) string) func(entries []index.Entry) []Finding {
+	return func(entries []index.Entry) []Finding {
+		counts := make(map[string]int)
+		for _, e := range entries {
+			if v := value(e); v != "" {
+				counts[v]++
+			}
+		}
+		if len(counts) < 2 {
+			return nil
+		}
+
+		common := mostCommon(counts)
+
+		var findings []Finding
+		for _, e := range entries {
+			if v := value(e); v != "" && v != common {
+				findings = append(findings, Finding{
+					RelFilename: e.RelFilename,
+					Message:     fmt.Sprintf("%s %q differs from %q used by most tracks in this directory", field, v, common),
+				})
+			}
+		}
+		return findings
+	}
+}
+
+// checkInconsistentArtist flags tracks by a different artist to the rest of the directory. The tracks of a
+// compilation or of an album with guest artists are expected to differ, so a directory whose tracks have an album
+// artist is left to inconsistent-album-artist, and one of tracks flagged as part of a compilation isn't checked at all.
+func checkInconsistentArtist(entries []index.Entry) []Finding {
+	compilation := false
+	for _, e := range entries {
+		if e.AlbumArtist != "" {
+			return nil
+		}
+		compilation = compilation || e.Compilation
+	}
+
+	if compilation {
+		return nil
+	}
+	return consistencyCheck("artist", func(e index.Entry) string { return e.Artist })(entries)
+}
+
+// checkInconsistentAlbumArtist flags tracks of an album whose album artist differs from the one most of the album's
+// tracks in the directory have, including tracks without one. Albums without an album artist on any track aren't
+// checked.
+func checkInconsistentAlbumArtist(entries []index.Entry) []Finding {
+	var albums []string
+	byAlbum := make(map[string][]index.Entry)
+	for _, e := range entries {
+		album := strings.ToLower(e.Album)
+		if _, ok := byAlbum[album]; !ok {
+			albums = append(albums, album)
+		}
+		byAlbum[album] = append(byAlbum[album], e)
+	}
+
+	var findings []Finding
+	for _, album := range albums {
+		counts := make(map[string]int)
+		named := make(map[string]int)
+		for _, e := range byAlbum[album] {
+			counts[e.AlbumArtist]++
+			if e.AlbumArtist != "" {
+				named[e.AlbumArtist]++
+			}
+		}
+		if len(counts) < 2 {
+			continue
+		}
+
+		// a tie between tracks with an album artist and tracks without one goes to the album artist
+		common := mostCommon(named)
+		if counts[""] > counts[common] {
+			common = ""
+		}
+		for _, e := range byAlbum[album] {
+			switch {
+			case e.AlbumArtist == common:
+			case e.AlbumArtist == "":
+				findings = append(findings, Finding{
+					RelFilename: e.RelFilename,
+					Message:     fmt.Sprintf("no album artist, unlike the %d other track(s) of the album with %q", counts[common], common),
+				})
+			case common == "":
+				findings = append(findings, Finding{
+					RelFilename: e.RelFilename,
+					Message:     fmt.Sprintf("album artist %q, unlike the %d other track(s) of the album without one", e.AlbumArtist, counts[common]),
+				})
+			default:
+				findings = append(findings, Finding{
+					RelFilename: e.RelFilename,
+					Message:     fmt.Sprintf("album artist %q differs from %q used by most tracks of the album", e.AlbumArtist, common),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func checkID3v1Only(e index.Entry) (string, bool) {
+	return "only has an ID3v1 tag", strings.HasPrefix(e.TagVersion, "1.")
+}
+
+func checkMixedTagVersions(entries []index.Entry) []Finding {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.TagVersion]++
+	}
+	if len(counts) < 2 {
+		return nil
+	}
+
+	common := mostCommon(counts)
+
+	var findings []Finding
+	for _, e := range entries {
+		if e.TagVersion != common {
+			findings = append(findings, Finding{
+				RelFilename: e.RelFilename,
+				Message:     fmt.Sprintf("tag version %s differs from %s used by most tracks in this directory", describeTagVersion(e.TagVersion), describeTagVersion(common)),
+			})
+		}
+	}
+	return findings
+}
+
+func describeTagVersion(version string) string {
+	switch {
+	case version == "":
+		return "(no tag)"
+	case version[0] >= '0' && version[0] <= '9':
+		return "ID3v" + version
+	default:
+		return version
+	}
+}
+
+func checkLowBitrate(e index.Entry) (string, bool) {
+	if e.Stream.Lossless || e.Stream.Bitrate == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("average bitrate of %d kbps is below %d kbps", e.Stream.Bitrate, lowBitrate), e.Stream.Bitrate < lowBitrate
+}
+
+// checkInconsistentEncoding flags tracks encoded differently to most of the tracks in the directory, like a single
+// lossy track among lossless ones or a CBR track among VBR ones, which is a sign of a transcode or of a track that
+// came from another source.
+func checkInconsistentEncoding(entries []index.Entry) []Finding {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		if enc := describeEncoding(e); enc != "" {
+			counts[enc]++
+		}
+	}
+	if len(counts) < 2 {
+		return nil
+	}
+
+	common := mostCommon(counts)
+
+	var findings []Finding
+	for _, e := range entries {
+		if enc := describeEncoding(e); enc != "" && enc != common {
+			findings = append(findings, Finding{
+				RelFilename: e.RelFilename,
+				Message:     fmt.Sprintf("encoding %s differs from %s used by most tracks in this directory", enc, common),
+			})
+		}
+	}
+	return findings
+}
+
+// describeEncoding describes the format of a track and whether it is lossless, VBR or CBR, e.g. "MP3 VBR". It is
+// empty if vir couldn't read the track's stream.
+func describeEncoding(e index.Entry) string {
+	format := strings.ToUpper(string(e.Format))
+	switch {
+	case e.Stream.Bitrate == 0:
+		return ""
+	case e.Stream.Lossless:
+		return "lossless " + format
+	case e.Stream.VBR:
+		return format + " VBR"
+	default:
+		return format + " CBR"
+	}
+}
+
+// checkDamagedStream reports the damage vir verify found in a track. Tracks that haven't been verified pass.
+func checkDamagedStream(e index.Entry) (string, bool) {
+	if e.Verification == nil || e.Verification.OK() {
+		return "", false
+	}
+
+	kinds := make([]string, len(e.Verification.Issues))
+	for i, issue := range e.Verification.Issues {
+		kinds[i] = string(issue.Kind)
+	}
+	return "audio stream is damaged: " + strings.Join(kinds, ", "), true
+}
+
+func checkMissingArt(e index.Entry) (string, bool) {
+	return "no embedded cover art", len(e.Pictures) == 0
+}
+
+func checkUndersizedArt(e index.Entry) (string, bool) {
+	cover, ok := e.FrontCover()
+	if !ok || cover.Width == 0 || cover.Height == 0 {
+		// missing-art covers the first, and we can't tell for the second
+		return "", false
+	}
+	return fmt.Sprintf("cover art is only %dx%d, smaller than %dx%d", cover.Width, cover.Height, minArtSize, minArtSize),
+		cover.Width < minArtSize || cover.Height < minArtSize
+}
+
+// checkInconsistentArt flags tracks whose cover art differs from the one most tracks in the directory share.
+// Tracks without any art are left to missing-art.
+func checkInconsistentArt(entries []index.Entry) []Finding {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		if cover, ok := e.FrontCover(); ok {
+			counts[cover.Hash]++
+		}
+	}
+	if len(counts) < 2 {
+		return nil
+	}
+
+	common := mostCommon(counts)
+
+	var findings []Finding
+	for _, e := range entries {
+		if cover, ok := e.FrontCover(); ok && cover.Hash != common {
+			findings = append(findings, Finding{
+				RelFilename: e.RelFilename,
+				Message:     "cover art differs from the one used by most tracks in this directory",
+			})
+		}
+	}
+	return findings
+}
+
+// mostCommon returns the key with the highest count, breaking ties by sort order so the result is stable.
+func mostCommon(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	best := keys[0]
+	for _, k := range keys[1:] {
+		if counts[k] > counts[best] {
+			best = k
+		}
+	}
+	return best
+}
@@ -0,0 +1,147 @@
+// Package organise moves the tracks in a music library into a directory structure derived from their tags
+package organise
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ceralena/vir/fileops"
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/query"
+)
+
+// Skip is a file that can't be organised, and why.
+type Skip struct {
+	RelFilename string
+	Reason      string
+}
+
+// Options controls how a library is organised.
+type Options struct {
+	Template *Template
+
+	// ASCII limits file names to ASCII, for players that can't cope with anything else.
+	ASCII bool
+
+	// Query, if set, limits the tracks that are moved to those it matches. The rest stay where they are.
+	Query *query.Query
+}
+
+// MakePlan works out where each indexed track should go, and which sidecar files should go with them.
+// It returns a plan of moves sorted by source path, along with the files that have to be left where they are.
+//
+// Nothing is ever overwritten: tracks and sidecar files that would land on an existing file or on each other are
+// skipped. Sidecar files only follow their tracks if every track in their directory is going to the same new
+// directory.
+func MakePlan(root string, entries []index.Entry, sidecars []string, opts Options) (*fileops.Plan, []Skip) {
+	var moves []move
+	var skipped []Skip
+	skip := func(relFilename, reason string) {
+		skipped = append(skipped, Skip{RelFilename: relFilename, Reason: reason})
+	}
+
+	targets := make(map[string][]string)
+	targetOf := make(map[string]string)
+
+	for _, e := range entries {
+		if opts.Query != nil && !opts.Query.Match(e) {
+			continue
+		}
+		if e.Title == "" {
+			skip(e.RelFilename, "no title in tags")
+			continue
+		}
+
+		to, err := opts.Template.render(e, opts.ASCII)
+		if err != nil {
+			skip(e.RelFilename, err.Error())
+			continue
+		}
+
+		targetOf[e.RelFilename] = to
+		targets[to] = append(targets[to], e.RelFilename)
+	}
+
+	// every directory a track in srcDir is going to, with tracks that stay put going to srcDir
+	destDirs := make(map[string]map[string]bool)
+	for _, e := range entries {
+		srcDir := filepath.Dir(e.RelFilename)
+		if destDirs[srcDir] == nil {
+			destDirs[srcDir] = make(map[string]bool)
+		}
+
+		to, ok := targetOf[e.RelFilename]
+		if ok && to != e.RelFilename {
+			if reason := collision(root, to, targets[to]); reason != "" {
+				skip(e.RelFilename, reason)
+				ok = false
+			}
+		}
+
+		if !ok || to == e.RelFilename {
+			destDirs[srcDir][srcDir] = true
+			continue
+		}
+
+		destDirs[srcDir][filepath.Dir(to)] = true
+		moves = append(moves, move{from: e.RelFilename, to: to})
+	}
+
+	// sidecars from different directories can be headed for the same place, like two albums' cover.jpg going
+	// into one directory, so they are checked against each other as well as the tracks
+	sidecarTargetOf := make(map[string]string)
+	for _, sidecar := range sidecars {
+		dirs := destDirs[filepath.Dir(sidecar)]
+		if len(dirs) != 1 {
+			continue
+		}
+		for destDir := range dirs {
+			to := filepath.Join(destDir, filepath.Base(sidecar))
+			if to == sidecar {
+				continue
+			}
+			sidecarTargetOf[sidecar] = to
+			targets[to] = append(targets[to], sidecar)
+		}
+	}
+
+	for _, sidecar := range sidecars {
+		to, ok := sidecarTargetOf[sidecar]
+		if !ok {
+			continue
+		}
+		if reason := collision(root, to, targets[to]); reason != "" {
+			skip(sidecar, reason)
+			continue
+		}
+		moves = append(moves, move{from: sidecar, to: to})
+	}
+
+	sort.Slice(moves, func(i, j int) bool { return moves[i].from < moves[j].from })
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].RelFilename < skipped[j].RelFilename })
+
+	plan := fileops.NewPlan(root, "organise")
+	for _, m := range moves {
+		plan.AddMove(m.from, m.to)
+	}
+
+	return plan, skipped
+}
+
+type move struct {
+	from string
+	to   string
+}
+
+// collision explains why a file can't be moved to a target, or returns an empty string if it can.
+// sources are all of the files headed for the target.
+func collision(root, to string, sources []string) string {
+	if len(sources) > 1 {
+		return "more than one file would be moved to " + to
+	}
+	if _, err := os.Lstat(filepath.Join(root, to)); err == nil {
+		return to + " already exists"
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package organise
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kennygrant/sanitize"
+)
+
+// windowsReservedNames can't be used as file names on windows, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitise makes a tag value safe to use as part of a file name on this platform, replacing anything that isn't
+// allowed with an underscore. Path separators are never allowed, so a tag can't reach outside its component.
+//
+// If ascii is set, accented letters are transliterated and anything else outside ASCII is replaced too.
+func sanitise(value string, ascii bool) string {
+	if ascii {
+		value = sanitize.Accents(value)
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == filepath.Separator || r < 0x20 || r == 0x7f:
+			return '_'
+		case runtime.GOOS == "windows" && strings.ContainsRune(`<>:"\|?*`, r):
+			return '_'
+		case runtime.GOOS == "darwin" && r == ':':
+			return '_'
+		case ascii && r > 0x7e:
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// maxComponentLen is the longest a path component can be, in bytes, on most filesystems.
+const maxComponentLen = 255
+
+// renderedPart is a piece of a rendered path component: literal text from the template, or the value of a field.
+// fromTags is set for the values that are text from the tags.
+type renderedPart struct {
+	text     string
+	fromTags bool
+}
+
+// tidy joins the parts of a rendered path component and cleans it up: runs of whitespace are collapsed, separators
+// the template left dangling next to empty fields are trimmed, names too long for the filesystem are shortened, and
+// names the platform can't have are made safe. Only whitespace is trimmed from the values from the tags.
+//
+// fileName is set for the last component, whose extension is kept when it is shortened.
+func tidy(parts []renderedPart, fileName bool) string {
+	texts := make([]string, len(parts))
+	for i, p := range parts {
+		texts[i] = p.text
+	}
+	for i := 0; i < len(parts); i++ {
+		if !trimPart(&texts[i], parts[i], strings.TrimLeft) {
+			break
+		}
+	}
+	for i := len(parts) - 1; i >= 0; i-- {
+		if !trimPart(&texts[i], parts[i], strings.TrimRight) {
+			break
+		}
+	}
+	component := strings.Join(strings.Fields(strings.Join(texts, "")), " ")
+	component = truncateComponent(component, fileName)
+
+	if runtime.GOOS == "windows" {
+		component = strings.TrimRight(component, " .")
+		base := strings.ToUpper(strings.SplitN(component, ".", 2)[0])
+		if windowsReservedNames[base] {
+			component = "_" + component
+		}
+	}
+
+	// "." and ".." would take us somewhere else entirely
+	if component != "" && strings.Trim(component, ".") == "" {
+		component = strings.Replace(component, ".", "_", -1)
+	}
+
+	return component
+}
+
+// trimPart trims one end of a part's text: whitespace, and the separators too if it's from the template rather than
+// the tags. It reports whether nothing was left, so that trimming should go on into the next part.
+func trimPart(text *string, p renderedPart, trim func(s, cutset string) string) bool {
+	cutset := " -_"
+	if p.fromTags {
+		cutset = " "
+	}
+	*text = trim(*text, cutset)
+	return *text == ""
+}
+
+// truncateComponent shortens a component to maxComponentLen bytes without splitting a character, keeping the
+// extension of a file name.
+func truncateComponent(component string, fileName bool) string {
+	if len(component) <= maxComponentLen {
+		return component
+	}
+
+	ext := ""
+	if fileName {
+		ext = filepath.Ext(component)
+		if len(ext) >= maxComponentLen {
+			ext = ""
+		}
+	}
+
+	stem := component[:len(component)-len(ext)]
+	cut := maxComponentLen - len(ext)
+	for cut > 0 && !utf8.RuneStart(stem[cut]) {
+		cut--
+	}
+	return strings.TrimRight(stem[:cut], " ") + ext
+}
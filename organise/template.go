@@ -0,0 +1,187 @@
+package organise
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// DefaultTemplate is the layout vir organises a library into unless told otherwise.
+const DefaultTemplate = "{albumartist}/{year} - {album}/{disc}{track:02} {title}.{ext}"
+
+// fields are the values a template can refer to.
+// A field that a track doesn't have renders as an empty string, and the separators around it are tidied away.
+var fields = map[string]func(e index.Entry) string{
+	"albumartist": func(e index.Entry) string { return orDefault(albumArtist(e), "Unknown Artist") },
+	"artist":      func(e index.Entry) string { return orDefault(e.Artist, "Unknown Artist") },
+	"album":       func(e index.Entry) string { return orDefault(e.Album, "Unknown Album") },
+	"title":       func(e index.Entry) string { return e.Title },
+	"track":       func(e index.Entry) string { return positiveNumber(e.Number) },
+	"disc":        discPrefix,
+	"year":        func(e index.Entry) string { return positiveNumber(e.Year) },
+	"genre":       func(e index.Entry) string { return e.Genre },
+	"composer":    func(e index.Entry) string { return e.Composer },
+	"ext": func(e index.Entry) string {
+		return strings.ToLower(strings.TrimPrefix(filepath.Ext(e.RelFilename), "."))
+	},
+}
+
+// tagTextFields are the fields whose values are text from the tags. Anything in them is kept when a component is
+// tidied, other than whitespace: a title like "-ism" starts with a dash on purpose.
+var tagTextFields = map[string]bool{
+	"albumartist": true, "artist": true, "album": true, "title": true, "genre": true, "composer": true,
+}
+
+// Template is a parsed path template, like DefaultTemplate.
+//
+// Fields are written in braces, optionally with a zero-padded width: {track:02}.
+// Directories are always separated with a forward slash, whatever the platform.
+type Template struct {
+	components [][]templatePart
+}
+
+// templatePart is either literal text, or a field with an optional width.
+type templatePart struct {
+	literal string
+	field   string
+	width   int
+}
+
+// ParseTemplate parses a path template.
+func ParseTemplate(tmpl string) (*Template, virErrors.ScopedError) {
+	t := &Template{}
+
+	for _, component := range strings.Split(tmpl, "/") {
+		parts, err := parseComponent(component)
+		if err != nil {
+			return nil, virErrors.ErrInvalidOrganiseTemplate("vir/organise.ParseTemplate", tmpl, err)
+		}
+		t.components = append(t.components, parts)
+	}
+
+	return t, nil
+}
+
+func parseComponent(component string) ([]templatePart, error) {
+	var parts []templatePart
+
+	for component != "" {
+		open := strings.IndexByte(component, '{')
+		if open < 0 {
+			parts = append(parts, templatePart{literal: component})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, templatePart{literal: component[:open]})
+		}
+
+		end := strings.IndexByte(component[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", component)
+		}
+
+		part, err := parseField(component[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+
+		component = component[open+end+1:]
+	}
+
+	return parts, nil
+}
+
+func parseField(spec string) (templatePart, error) {
+	name, width := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, width = spec[:i], spec[i+1:]
+	}
+
+	if _, ok := fields[name]; !ok {
+		return templatePart{}, fmt.Errorf("unknown field {%s}", name)
+	}
+
+	part := templatePart{field: name}
+	if width != "" {
+		w, err := strconv.Atoi(width)
+		if err != nil || w < 0 {
+			return templatePart{}, fmt.Errorf("invalid width in {%s}", spec)
+		}
+		part.width = w
+	}
+
+	return part, nil
+}
+
+// render produces the relative path for an entry, sanitising every component for the platform.
+// Directory components that come out empty are dropped; an empty file name is an error.
+func (t *Template) render(e index.Entry, ascii bool) (string, error) {
+	var components []string
+
+	for i, parts := range t.components {
+		rendered := make([]renderedPart, len(parts))
+		for j, p := range parts {
+			if p.field == "" {
+				rendered[j] = renderedPart{text: p.literal}
+				continue
+			}
+
+			value := sanitise(fields[p.field](e), ascii)
+			if p.width > 0 && value != "" && len(value) < p.width {
+				value = strings.Repeat("0", p.width-len(value)) + value
+			}
+			rendered[j] = renderedPart{text: value, fromTags: tagTextFields[p.field]}
+		}
+
+		component := tidy(rendered, i == len(t.components)-1)
+		if component == "" && i == len(t.components)-1 {
+			return "", fmt.Errorf("the template gives an empty file name")
+		} else if component == "" {
+			continue
+		}
+		components = append(components, component)
+	}
+
+	return filepath.Join(components...), nil
+}
+
+// albumArtist is the album artist of a track, falling back to the track artist since most single-artist albums don't
+// bother tagging both. Compilations without an album artist are filed under "Various Artists".
+func albumArtist(e index.Entry) string {
+	switch {
+	case e.AlbumArtist != "":
+		return e.AlbumArtist
+	case e.Compilation:
+		return "Various Artists"
+	default:
+		return e.Artist
+	}
+}
+
+// discPrefix renders the disc number followed by a dash, ready to go in front of the track number. It is empty
+// unless the track is part of a set of more than one disc, so that single-disc albums aren't cluttered with it.
+func discPrefix(e index.Entry) string {
+	if e.DiscNumber <= 0 || e.DiscTotal == 1 || (e.DiscTotal == 0 && e.DiscNumber == 1) {
+		return ""
+	}
+	return strconv.Itoa(e.DiscNumber) + "-"
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func positiveNumber(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,258 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ceralena/vir/index"
+	"github.com/ceralena/vir/virErrors"
+)
+
+// Parse parses a query. An empty query matches every track.
+func Parse(s string) (*Query, virErrors.ScopedError) {
+	q := &Query{source: s}
+
+	tokens, err := tokenize(s)
+	if err == nil {
+		for _, tok := range tokens {
+			var t term
+			if t, err = parseTerm(tok); err != nil {
+				break
+			}
+			q.terms = append(q.terms, t)
+		}
+	}
+	if err != nil {
+		return nil, virErrors.ErrInvalidQuery("vir/query.Parse", s, err)
+	}
+
+	return q, nil
+}
+
+// ParseArgs parses a query given as command line arguments, which are joined with spaces.
+//
+// The shell has already taken the quotes off an argument like artist:"Boards of Canada", so an argument with a space
+// in it but no quotes is taken to be a single term, and quoted again.
+func ParseArgs(args []string) (*Query, virErrors.ScopedError) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+		if !strings.ContainsAny(arg, " \t") || strings.ContainsRune(arg, '"') {
+			continue
+		}
+		if colon := strings.IndexByte(arg, ':'); colon >= 0 {
+			parts[i] = arg[:colon+1] + `"` + arg[colon+1:] + `"`
+		} else {
+			parts[i] = `"` + arg + `"`
+		}
+	}
+	return Parse(strings.Join(parts, " "))
+}
+
+// token is a term as written, with its quotes removed.
+type token struct {
+	negated bool
+	key     string
+	value   string
+	hasKey  bool
+}
+
+// tokenize splits a query into its terms. A minus at the start of a term negates it, and the first colon outside
+// quotes separates the key from the value.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var tok token
+		if runes[i] == '-' {
+			tok.negated = true
+			i++
+		}
+
+		var text []rune
+		quoted, inQuotes := false, false
+		for ; i < len(runes) && (inQuotes || !unicode.IsSpace(runes[i])); i++ {
+			switch r := runes[i]; {
+			case r == '"':
+				quoted, inQuotes = true, !inQuotes
+			case r == ':' && !inQuotes && !quoted && !tok.hasKey:
+				tok.key, tok.hasKey = string(text), true
+				text = nil
+			default:
+				text = append(text, r)
+			}
+		}
+		if inQuotes {
+			return nil, fmt.Errorf("unclosed quote")
+		}
+
+		tok.value = string(text)
+		if !tok.hasKey && tok.value == "" && !quoted {
+			return nil, fmt.Errorf("a minus has to be followed by a term")
+		}
+		tokens = append(tokens, tok)
+	}
+
+	return tokens, nil
+}
+
+func parseTerm(tok token) (term, error) {
+	t := term{negated: tok.negated}
+
+	if !tok.hasKey {
+		t.match = func(e index.Entry) bool {
+			for _, name := range wordKeys {
+				if containsFold(keys[name].text(e), tok.value) {
+					return true
+				}
+			}
+			return false
+		}
+		return t, nil
+	}
+
+	name := strings.ToLower(tok.key)
+	if name == "missing" {
+		var err error
+		t.match, err = parseMissing(tok.value)
+		return t, err
+	}
+
+	k, ok := keys[name]
+	if !ok {
+		return t, fmt.Errorf("unknown key %q", tok.key)
+	}
+
+	var err error
+	switch {
+	case k.text != nil:
+		t.match = parseText(k.text, tok.value)
+	case k.number != nil:
+		parseNumber := k.parseNumber
+		if parseNumber == nil {
+			parseNumber = strconv.Atoi
+		}
+		t.match, err = parseRange(k.number, parseNumber, tok.value)
+	default:
+		t.match, err = parseFlag(k.flag, tok.value)
+	}
+	if err != nil {
+		return t, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return t, nil
+}
+
+// parseText matches a text field containing the value, or equal to it if the value starts with an equals sign.
+func parseText(field func(e index.Entry) string, value string) func(e index.Entry) bool {
+	if strings.HasPrefix(value, "=") {
+		return func(e index.Entry) bool { return strings.EqualFold(field(e), value[1:]) }
+	}
+	return func(e index.Entry) bool { return containsFold(field(e), value) }
+}
+
+// parseRange matches a number field against a number, a range or a comparison.
+func parseRange(field func(e index.Entry) int, parseNumber func(s string) (int, error), value string) (func(e index.Entry) bool, error) {
+	lo, hi := 1, int(^uint(0)>>1)
+	var err error
+
+	bound := func(s string) int {
+		n, parseErr := parseNumber(s)
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("invalid number %q", s)
+		}
+		return n
+	}
+
+	switch {
+	case strings.Contains(value, ".."):
+		i := strings.Index(value, "..")
+		if value[:i] == "" && value[i+2:] == "" {
+			return nil, fmt.Errorf("a range needs at least one end")
+		}
+		if value[:i] != "" {
+			lo = bound(value[:i])
+		}
+		if value[i+2:] != "" {
+			hi = bound(value[i+2:])
+		}
+	case strings.HasPrefix(value, "<="):
+		hi = bound(value[2:])
+	case strings.HasPrefix(value, ">="):
+		lo = bound(value[2:])
+	case strings.HasPrefix(value, "<"):
+		hi = bound(value[1:]) - 1
+	case strings.HasPrefix(value, ">"):
+		lo = bound(value[1:]) + 1
+	default:
+		lo = bound(value)
+		hi = lo
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e index.Entry) bool {
+		n := field(e)
+		return n > 0 && n >= lo && n <= hi
+	}, nil
+}
+
+// parseSeconds parses a duration in seconds, or in minutes and seconds like 3:30.
+func parseSeconds(s string) (int, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return strconv.Atoi(s)
+	}
+
+	minutes, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.Atoi(s[i+1:])
+	if err != nil || seconds >= 60 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return minutes*60 + seconds, nil
+}
+
+func parseFlag(field func(e index.Entry) bool, value string) (func(e index.Entry) bool, error) {
+	var want bool
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		want = true
+	case "no", "false", "0":
+		want = false
+	default:
+		return nil, fmt.Errorf("expected yes or no, not %q", value)
+	}
+	return func(e index.Entry) bool { return field(e) == want }, nil
+}
+
+// parseMissing matches tracks without a value for a text or number key, or without embedded art.
+func parseMissing(name string) (func(e index.Entry) bool, error) {
+	name = strings.ToLower(name)
+	if name == "art" {
+		return func(e index.Entry) bool { return len(e.Pictures) == 0 }, nil
+	}
+
+	k, ok := keys[name]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unknown key %q after missing:", name)
+	case k.text != nil:
+		return func(e index.Entry) bool { return k.text(e) == "" }, nil
+	case k.number != nil:
+		return func(e index.Entry) bool { return k.number(e) <= 0 }, nil
+	default:
+		return nil, fmt.Errorf("%s is a yes or no key, so it can't be missing", name)
+	}
+}
@@ -0,0 +1,118 @@
+// Package query selects tracks from the index with a small query language, e.g.
+//
+//	artist:"Boards of Canada" year:1995..2002 -genre:live missing:albumartist bitrate:<192
+//
+// A query is a list of terms separated by spaces, all of which a track has to match. A term is either a word, which
+// matches tracks with it in their title, artist, album or album artist, or key:value. Putting a minus in front of a
+// term matches the tracks it doesn't. Double quotes keep spaces in a word or value.
+//
+// How a value matches depends on its key:
+//
+//   - Text keys, like artist or path, match tracks that have the value anywhere in the field, ignoring case. With an
+//     equals sign, as in artist:=Low, the whole field has to match.
+//   - Number keys, like year or bitrate, take a number, a range such as 1995..2002, 1995.. or ..2002, or a
+//     comparison such as <192 or >=2000. Tracks without a value for the key never match. Durations are given in
+//     seconds or as minutes:seconds.
+//   - Flag keys, like compilation or lossless, take yes or no.
+//   - missing:key matches tracks that don't have a value for a text or number key. missing:art matches tracks
+//     without any embedded pictures.
+package query
+
+import (
+	"strings"
+
+	"github.com/ceralena/vir/index"
+)
+
+// Query is a parsed query.
+type Query struct {
+	source string
+	terms  []term
+}
+
+// term is a single condition of a query.
+type term struct {
+	negated bool
+	match   func(e index.Entry) bool
+}
+
+// String returns the query as it was given.
+func (q *Query) String() string {
+	return q.source
+}
+
+// IsEmpty reports whether the query has no terms, and so matches every track.
+func (q *Query) IsEmpty() bool {
+	return len(q.terms) == 0
+}
+
+// Match reports whether an entry matches every term of the query.
+func (q *Query) Match(e index.Entry) bool {
+	for _, t := range q.terms {
+		if t.match(e) == t.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the entries that match the query, in the order they were given.
+func (q *Query) Filter(entries []index.Entry) []index.Entry {
+	var matched []index.Entry
+	for _, e := range entries {
+		if q.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// key is something a term can match tracks on. Exactly one of text, number and flag is set.
+type key struct {
+	text func(e index.Entry) string
+
+	// number returns 0 or less if the track doesn't have a value
+	number      func(e index.Entry) int
+	parseNumber func(s string) (int, error)
+
+	flag func(e index.Entry) bool
+}
+
+var keys = map[string]key{
+	"title":       {text: func(e index.Entry) string { return e.Title }},
+	"artist":      {text: func(e index.Entry) string { return e.Artist }},
+	"album":       {text: func(e index.Entry) string { return e.Album }},
+	"albumartist": {text: func(e index.Entry) string { return e.AlbumArtist }},
+	"genre":       {text: func(e index.Entry) string { return e.Genre }},
+	"composer":    {text: func(e index.Entry) string { return e.Composer }},
+	"comment":     {text: func(e index.Entry) string { return e.Comment }},
+	"isrc":        {text: func(e index.Entry) string { return e.ISRC }},
+	"path":        {text: func(e index.Entry) string { return e.RelFilename }},
+	"format":      {text: func(e index.Entry) string { return string(e.Format) }},
+	"encoder":     {text: func(e index.Entry) string { return e.Stream.Encoder }},
+
+	"year":       {number: func(e index.Entry) int { return e.Year }},
+	"track":      {number: func(e index.Entry) int { return e.Number }},
+	"disc":       {number: func(e index.Entry) int { return e.DiscNumber }},
+	"bpm":        {number: func(e index.Entry) int { return e.BPM }},
+	"bitrate":    {number: func(e index.Entry) int { return e.Stream.Bitrate }},
+	"samplerate": {number: func(e index.Entry) int { return e.Stream.SampleRate }},
+	"channels":   {number: func(e index.Entry) int { return e.Stream.Channels }},
+	"duration": {
+		number:      func(e index.Entry) int { return int(e.Stream.Duration.Seconds() + 0.5) },
+		parseNumber: parseSeconds,
+	},
+
+	"compilation": {flag: func(e index.Entry) bool { return e.Compilation }},
+	"lossless":    {flag: func(e index.Entry) bool { return e.Stream.Lossless }},
+	"vbr":         {flag: func(e index.Entry) bool { return e.Stream.VBR }},
+	"damaged":     {flag: func(e index.Entry) bool { return e.Verification != nil && !e.Verification.OK() }},
+}
+
+// wordKeys are the keys a word without a key is looked for in.
+var wordKeys = []string{"title", "artist", "album", "albumartist"}
+
+// containsFold reports whether substr is within s, ignoring case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
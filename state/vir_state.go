@@ -2,19 +2,29 @@
 package state
 
 import (
-	"github.com/ceralena/go-cacheh"
-
+	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+
+	"github.com/ceralena/go-cacheh"
+	"github.com/kennygrant/sanitize"
 
 	"github.com/ceralena/vir/virErrors"
-	"strings"
 )
 
 const stateVersion = "v1.0.0"
 
 const virConfRootDir = ".vir"
 
+// Version is the version of the state vir persists.
+// Anything stored with a different version must be migrated or rebuilt before it is used.
+func Version() string {
+	return stateVersion
+}
+
 func getCacheKeyPrefix() string {
 	return strings.Replace(stateVersion, ".", "-", -1) + "-"
 }
@@ -34,6 +44,11 @@ func getVirConfRoot() (string, virErrors.ScopedError) {
 	return filepath.Join(home, virConfRootDir), nil
 }
 
+// ConfRoot returns the directory vir keeps its state and configuration in, ~/.vir.
+func ConfRoot() (string, virErrors.ScopedError) {
+	return getVirConfRoot()
+}
+
 // Cache provides a simple persistent caching interface.
 type Cache interface {
 	Get(key string) ([]byte, virErrors.ScopedError)
@@ -43,6 +58,10 @@ type Cache interface {
 
 type cache struct {
 	cacheh.Cache
+
+	// dir and keyPrefix say which file the cache keeps a key's value in, so that Set can replace it safely.
+	dir       string
+	keyPrefix string
 }
 
 func (c *cache) Get(key string) ([]byte, virErrors.ScopedError) {
@@ -56,8 +75,11 @@ func (c *cache) Get(key string) ([]byte, virErrors.ScopedError) {
 
 }
 
+// Set stores a value. The cache itself would truncate the file and write the value into it, so a crash part of the
+// way through would leave a truncated index or journal behind; instead the value is written to a temporary file in
+// the same directory, synced to disk and renamed over the old one.
 func (c *cache) Set(key string, value []byte) virErrors.ScopedError {
-	err := c.Cache.Set(key, value)
+	err := c.replaceFile(c.keyPrefix+key, value)
 
 	if err != nil {
 		return virErrors.ErrCacheOperationFailed("vir/state.Cache", "Set", key, err)
@@ -78,6 +100,16 @@ func (c *cache) Delete(key string) virErrors.ScopedError {
 
 // GetStateCache provides a consistent state cache for vir state.
 func GetStateCache() (Cache, virErrors.ScopedError) {
+	return newStateCache(getCacheKeyPrefix())
+}
+
+// GetLibraryStateCache provides a state cache for the state of one music library, so that it is kept apart from
+// every other library's. The library key may only contain letters, digits, dashes and underscores.
+func GetLibraryStateCache(libraryKey string) (Cache, virErrors.ScopedError) {
+	return newStateCache(getCacheKeyPrefix() + "library-" + libraryKey + "-")
+}
+
+func newStateCache(keyPrefix string) (Cache, virErrors.ScopedError) {
 	confRoot, err := getVirConfRoot()
 	if err != nil {
 		return nil, err
@@ -93,5 +125,39 @@ func GetStateCache() (Cache, virErrors.ScopedError) {
 
 	// scope our cache keys to be prefixed by the music library root dir
 	// FIXME(cera) - do not hard-code forward slash as filesep here
-	return &cache{c.WithKeyPrefix(getCacheKeyPrefix())}, nil
+	return &cache{Cache: c.WithKeyPrefix(keyPrefix), dir: confRoot, keyPrefix: keyPrefix}, nil
+}
+
+// replaceFile writes the file the cache keeps a key's value in by way of a temporary file, the way
+// track.UpdateMetadata rewrites music files.
+func (c *cache) replaceFile(name string, value []byte) error {
+	// the same check the cache makes on its keys, since they become file names
+	if sanitize.BaseName(name) != name {
+		return fmt.Errorf("%s is an unsafe key", name)
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, "."+name+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	// on success the rename means there's nothing left to remove
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(value)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		// the mode the cache creates its files with
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(c.dir, name))
 }
@@ -0,0 +1,158 @@
+package track
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Field is a metadata field that can be set by name and written back to a file with UpdateMetadata.
+type Field string
+
+// The fields vir can write.
+//
+// FieldTrack and FieldDisc cover both the position and the total, written as "3/12". FieldYear is the release date,
+// which is Date if it is set and Year otherwise.
+const (
+	FieldTitle       Field = "title"
+	FieldArtist      Field = "artist"
+	FieldAlbum       Field = "album"
+	FieldAlbumArtist Field = "albumartist"
+	FieldTrack       Field = "track"
+	FieldDisc        Field = "disc"
+	FieldYear        Field = "year"
+	FieldGenre       Field = "genre"
+	FieldComposer    Field = "composer"
+	FieldComment     Field = "comment"
+	FieldBPM         Field = "bpm"
+	FieldCompilation Field = "compilation"
+	FieldISRC        Field = "isrc"
+)
+
+// Fields lists every Field in the order they are usually shown.
+var Fields = []Field{
+	FieldTitle, FieldArtist, FieldAlbum, FieldAlbumArtist, FieldTrack, FieldDisc, FieldYear,
+	FieldGenre, FieldComposer, FieldComment, FieldBPM, FieldCompilation, FieldISRC,
+}
+
+// IsValid reports whether f is one of Fields.
+func (f Field) IsValid() bool {
+	for _, field := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Get formats a field of m the way Set parses it. Fields the track doesn't have are empty.
+func (m Metadata) Get(f Field) string {
+	switch f {
+	case FieldTitle:
+		return m.Title
+	case FieldArtist:
+		return m.Artist
+	case FieldAlbum:
+		return m.Album
+	case FieldAlbumArtist:
+		return m.AlbumArtist
+	case FieldTrack:
+		return formatNumberPair(m.Number, m.TrackTotal)
+	case FieldDisc:
+		if m.DiscNumber <= 0 {
+			return ""
+		}
+		return formatNumberPair(m.DiscNumber, m.DiscTotal)
+	case FieldYear:
+		if m.Date != "" {
+			return m.Date
+		}
+		return positiveInt(m.Year)
+	case FieldGenre:
+		return m.Genre
+	case FieldComposer:
+		return m.Composer
+	case FieldComment:
+		return m.Comment
+	case FieldBPM:
+		return positiveInt(m.BPM)
+	case FieldCompilation:
+		if m.Compilation {
+			return "1"
+		}
+		return ""
+	case FieldISRC:
+		return m.ISRC
+	}
+	return ""
+}
+
+// Set parses value into a field of m. An empty value clears the field.
+func (m *Metadata) Set(f Field, value string) error {
+	var err error
+
+	switch f {
+	case FieldTitle:
+		m.Title = value
+	case FieldArtist:
+		m.Artist = value
+	case FieldAlbum:
+		m.Album = value
+	case FieldAlbumArtist:
+		m.AlbumArtist = value
+	case FieldTrack:
+		m.Number, m.TrackTotal = -1, 0
+		if value != "" {
+			m.Number, m.TrackTotal, err = parseNumberPair("track number", "TRCK", value)
+		}
+	case FieldDisc:
+		m.DiscNumber, m.DiscTotal = 0, 0
+		if value != "" {
+			m.DiscNumber, m.DiscTotal, err = parseNumberPair("disc number", "TPOS", value)
+		}
+	case FieldYear:
+		m.Year, m.Date = 0, value
+		if value != "" {
+			m.Year, err = parseYear("TDRC", value)
+		}
+	case FieldGenre:
+		m.Genre = value
+	case FieldComposer:
+		m.Composer = value
+	case FieldComment:
+		m.Comment = value
+	case FieldBPM:
+		m.BPM = 0
+		if value != "" {
+			m.BPM, err = parseBPM("TBPM", value)
+		}
+	case FieldCompilation:
+		m.Compilation = false
+		if value != "" {
+			m.Compilation, err = parseFlag("compilation flag", "TCMP", value)
+		}
+	case FieldISRC:
+		m.ISRC = strings.ToUpper(value)
+	default:
+		return fmt.Errorf("unknown field %q", f)
+	}
+
+	return err
+}
+
+func formatNumberPair(n, total int) string {
+	if n < 0 {
+		return ""
+	}
+	if total > 0 {
+		return fmt.Sprintf("%d/%d", n, total)
+	}
+	return strconv.Itoa(n)
+}
+
+func positiveInt(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,226 @@
+package track
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FLAC metadata block types.
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+	flacBlockPicture       = 6
+)
+
+// flacMagicLen is the length of the "fLaC" marker at the start of every FLAC stream.
+const flacMagicLen = 4
+
+// flacStreamInfo is the content of the mandatory STREAMINFO block.
+// totalSamples is 0 if the encoder didn't know it, e.g. when encoding from a pipe.
+type flacStreamInfo struct {
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	totalSamples  int64
+	md5           [16]byte
+}
+
+// flacMetadata is everything vir reads from the metadata blocks at the start of a FLAC file.
+// comment is nil if the file has no VORBIS_COMMENT block. audioStart is where the first audio frame begins.
+type flacMetadata struct {
+	streamInfo flacStreamInfo
+	comment    *vorbisComment
+	pictures   []Picture
+	audioStart int64
+}
+
+// readFLAC reads the metadata blocks of a FLAC file, skipping the kinds vir has no use for.
+func readFLAC(r io.ReaderAt, size int64) (*flacMetadata, error) {
+	magic := make([]byte, flacMagicLen)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+	if string(magic) != "fLaC" {
+		return nil, fmt.Errorf("not a FLAC stream")
+	}
+
+	fm := &flacMetadata{}
+	sawStreamInfo := false
+
+	offset := int64(flacMagicLen)
+	for {
+		header := make([]byte, 4)
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, fmt.Errorf("truncated FLAC metadata: %s", err)
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+
+		if offset+length > size {
+			return nil, fmt.Errorf("FLAC metadata block %d runs past the end of the file", blockType)
+		}
+
+		switch blockType {
+		case flacBlockStreamInfo, flacBlockVorbisComment, flacBlockPicture:
+			block := make([]byte, length)
+			if _, err := r.ReadAt(block, offset); err != nil {
+				return nil, err
+			}
+			if err := fm.parseBlock(blockType, block); err != nil {
+				return nil, err
+			}
+			if blockType == flacBlockStreamInfo {
+				sawStreamInfo = true
+			}
+		}
+
+		offset += length
+		if last {
+			break
+		}
+	}
+
+	if !sawStreamInfo {
+		return nil, fmt.Errorf("no STREAMINFO block in FLAC metadata")
+	}
+
+	fm.audioStart = offset
+	return fm, nil
+}
+
+func (fm *flacMetadata) parseBlock(blockType byte, block []byte) error {
+	switch blockType {
+	case flacBlockStreamInfo:
+		si, err := parseFLACStreamInfo(block)
+		if err != nil {
+			return err
+		}
+		fm.streamInfo = si
+	case flacBlockVorbisComment:
+		// there should only be one, but if there are more the first wins
+		if fm.comment != nil {
+			return nil
+		}
+		c, err := parseVorbisComment(block)
+		if err != nil {
+			return err
+		}
+		fm.comment = c
+	case flacBlockPicture:
+		p, err := parseFLACPicture(block)
+		if err != nil {
+			return err
+		}
+		fm.pictures = append(fm.pictures, p)
+	}
+	return nil
+}
+
+func parseFLACStreamInfo(b []byte) (flacStreamInfo, error) {
+	if len(b) < 34 {
+		return flacStreamInfo{}, fmt.Errorf("truncated FLAC STREAMINFO block")
+	}
+
+	// after the block and frame sizes: 20 bits of sample rate, 3 of channels - 1, 5 of bits per sample - 1 and
+	// 36 of total samples
+	packed := binary.BigEndian.Uint64(b[10:18])
+	si := flacStreamInfo{
+		sampleRate:    int(packed >> 44),
+		channels:      int(packed>>41&0x7) + 1,
+		bitsPerSample: int(packed>>36&0x1f) + 1,
+		totalSamples:  int64(packed & 0xfffffffff),
+	}
+	copy(si.md5[:], b[18:34])
+
+	if si.sampleRate == 0 {
+		return flacStreamInfo{}, fmt.Errorf("invalid sample rate in FLAC STREAMINFO block")
+	}
+
+	return si, nil
+}
+
+// parseFLACPicture parses a PICTURE block. Ogg files embed the same structure, base64-encoded, in their comments.
+func parseFLACPicture(b []byte) (Picture, error) {
+	var p Picture
+
+	pictureType, b, err := flacUint32(b)
+	if err != nil {
+		return p, err
+	}
+	p.Type = int(pictureType)
+
+	if p.MIME, b, err = flacString(b); err != nil {
+		return p, err
+	}
+	if p.Description, b, err = flacString(b); err != nil {
+		return p, err
+	}
+
+	// width, height, colour depth and palette size
+	if len(b) < 16 {
+		return p, fmt.Errorf("truncated FLAC PICTURE block")
+	}
+	p.Width = int(binary.BigEndian.Uint32(b[0:4]))
+	p.Height = int(binary.BigEndian.Uint32(b[4:8]))
+	b = b[16:]
+
+	data, _, err := flacString(b)
+	if err != nil {
+		return p, err
+	}
+	p.Data = []byte(data)
+
+	return p, nil
+}
+
+func flacUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, fmt.Errorf("truncated FLAC PICTURE block")
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+// flacString reads a string or byte array prefixed with its big-endian 32-bit length.
+func flacString(b []byte) (string, []byte, error) {
+	n, b, err := flacUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(n) > uint64(len(b)) {
+		return "", nil, fmt.Errorf("truncated FLAC PICTURE block")
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+// readFLACMetadata reads the tags of a FLAC file from its Vorbis comment, and its stream info from STREAMINFO.
+func readFLACMetadata(r io.ReaderAt, size int64, tr *Track) error {
+	fm, err := readFLAC(r, size)
+	if err != nil {
+		return err
+	}
+
+	tr.Stream = StreamInfo{
+		Duration:   samplesDuration(fm.streamInfo.totalSamples, fm.streamInfo.sampleRate),
+		SampleRate: fm.streamInfo.sampleRate,
+		Channels:   fm.streamInfo.channels,
+		Lossless:   true,
+	}
+	tr.Stream.Bitrate = averageBitrate(size-fm.audioStart, tr.Stream.Duration)
+	tr.pictures = fm.pictures
+
+	if fm.comment == nil {
+		tr.Number = -1
+		tr.Errata = append(tr.Errata, "no track number (TRACKNUMBER) in tags")
+		return nil
+	}
+
+	tr.Stream.Encoder = fm.comment.vendor
+	tr.pictures = append(tr.pictures, fm.comment.pictures()...)
+	tr.TagVersion = TagVorbisComment
+	tr.Metadata, tr.Errata = metadataFromVorbisComment(fm.comment, tr.Errata)
+	return nil
+}
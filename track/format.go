@@ -0,0 +1,167 @@
+package track
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// Format is the container format of an audio file.
+type Format string
+
+// The audio formats vir can recognise.
+const (
+	FormatUnknown Format = ""
+	FormatMP3     Format = "mp3"
+	FormatFLAC    Format = "flac"
+	FormatOgg     Format = "ogg"
+	FormatMP4     Format = "mp4"
+	FormatWAV     Format = "wav"
+)
+
+// IsAudio reports whether the format is a known audio format.
+func (f Format) IsAudio() bool {
+	return f != FormatUnknown
+}
+
+// describeFormat names a format for messages.
+func describeFormat(f Format) string {
+	if f == FormatUnknown {
+		return "unrecognised"
+	}
+	return string(f)
+}
+
+// formatsByExtension maps lower-case file extensions to the format we expect to find in them.
+var formatsByExtension = map[string]Format{
+	".mp3":  FormatMP3,
+	".mp2":  FormatMP3,
+	".flac": FormatFLAC,
+	".ogg":  FormatOgg,
+	".oga":  FormatOgg,
+	".opus": FormatOgg,
+	".m4a":  FormatMP4,
+	".m4b":  FormatMP4,
+	".wav":  FormatWAV,
+}
+
+// sniffLen is how much of the start of a file we need to recognise its format.
+const sniffLen = 12
+
+// id3v2PaddingLen is how far past an ID3v2 tag we look for the start of the audio, since some taggers pad the tag
+// with more zeros than its size says.
+const id3v2PaddingLen = 1024
+
+// DetectFormat works out the audio format of a file from its magic bytes and its extension.
+//
+// Formats with unambiguous magic bytes are recognised whatever the file is called. Raw MPEG audio and generic MP4
+// containers are only recognised with a matching extension, because their magic alone is too weak to go on.
+// A file that is named like an audio file but doesn't look like one is FormatUnknown.
+//
+// ID3v2 tags turn up in front of FLAC and WAV files as well as MP3s, so the format of a file starting with one is
+// worked out from what comes after it.
+func DetectFormat(fullPath string) (Format, virErrors.ScopedError) {
+	f, err := os.Open(fullPath)
+	if err != nil && os.IsPermission(err) {
+		return FormatUnknown, virErrors.ErrPermissionDenied("vir/track.DetectFormat", fullPath, err)
+	} else if err != nil {
+		return FormatUnknown, virErrors.ErrTrackFormatDetectionFailed("vir/track.DetectFormat", fullPath, err)
+	}
+	defer f.Close()
+
+	byExtension := formatsByExtension[strings.ToLower(filepath.Ext(fullPath))]
+
+	offset, err := id3v2PrefixSize(f)
+	if err != nil {
+		return FormatUnknown, virErrors.ErrTrackFormatDetectionFailed("vir/track.DetectFormat", fullPath, err)
+	}
+	if offset == 0 {
+		header := make([]byte, sniffLen)
+		n, err := f.ReadAt(header, 0)
+		if err != nil && err != io.EOF {
+			return FormatUnknown, virErrors.ErrTrackFormatDetectionFailed("vir/track.DetectFormat", fullPath, err)
+		}
+		return sniffFormat(header[:n], byExtension), nil
+	}
+
+	behind := make([]byte, id3v2PaddingLen+sniffLen)
+	n, err := f.ReadAt(behind, offset)
+	if err != nil && err != io.EOF {
+		return FormatUnknown, virErrors.ErrTrackFormatDetectionFailed("vir/track.DetectFormat", fullPath, err)
+	}
+	return sniffBehindID3v2(behind[:n], byExtension), nil
+}
+
+func sniffFormat(header []byte, byExtension Format) Format {
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return FormatOgg
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return FormatWAV
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		brand := string(header[8:12])
+		if brand == "M4A " || brand == "M4B " || byExtension == FormatMP4 {
+			return FormatMP4
+		}
+	case len(header) >= 2 && isMPEGFrameSync(header) && byExtension == FormatMP3:
+		return FormatMP3
+	}
+
+	return FormatUnknown
+}
+
+// sniffBehindID3v2 works out the format of a file from what follows its ID3v2 tag. An MPEG frame is enough to go on
+// there whatever the file is called, and so is an MP3 extension, as long as nothing else is there instead: MP3
+// encoders sometimes leave junk between the tag and the first frame.
+func sniffBehindID3v2(behind []byte, byExtension Format) Format {
+	start := 0
+	for start < id3v2PaddingLen && start < len(behind) && behind[start] == 0 {
+		start++
+	}
+	header := behind[start:]
+	if len(header) > sniffLen {
+		header = header[:sniffLen]
+	}
+
+	switch format := sniffFormat(header, byExtension); {
+	case format != FormatUnknown:
+		return format
+	case len(header) >= 2 && isMPEGFrameSync(header):
+		return FormatMP3
+	case byExtension == FormatMP3:
+		return FormatMP3
+	default:
+		return FormatUnknown
+	}
+}
+
+// id3v2PrefixSize returns the size of the ID3v2 tags at the start of a file, or 0 if it doesn't start with one.
+// There is usually only one, but some taggers add a new tag in front of the old one rather than replacing it.
+func id3v2PrefixSize(r io.ReaderAt) (int64, error) {
+	var offset int64
+	for {
+		header := make([]byte, 10)
+		n, err := r.ReadAt(header, offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		size := id3v2Size(header[:n])
+		if size == 0 {
+			return offset, nil
+		}
+		offset += size
+	}
+}
+
+// isMPEGFrameSync reports whether b starts with the 11 set bits of an MPEG audio frame header. The reserved layer,
+// which ADTS uses to mark AAC audio, doesn't count.
+func isMPEGFrameSync(b []byte) bool {
+	return b[0] == 0xff && b[1]&0xe0 == 0xe0 && b[1]&0x06 != 0
+}
@@ -0,0 +1,70 @@
+package track
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// AudioHash returns the hex-encoded SHA-1 of just the audio data in a file, leaving out its tags, so that copies of a
+// track that only differ in their tags have the same hash.
+//
+// Only MP3 and FLAC files are supported so far; for anything else the hash is empty.
+func AudioHash(fullPath string) (string, virErrors.ScopedError) {
+	format, err := DetectFormat(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if format != FormatMP3 && format != FormatFLAC {
+		return "", nil
+	}
+
+	f, openErr := os.Open(fullPath)
+	if openErr != nil {
+		return "", audioHashError(fullPath, openErr)
+	}
+	defer f.Close()
+
+	fi, statErr := f.Stat()
+	if statErr != nil {
+		return "", audioHashError(fullPath, statErr)
+	}
+
+	start, end, regionErr := audioRegion(f, format, fi.Size())
+	if regionErr != nil {
+		return "", audioHashError(fullPath, regionErr)
+	}
+
+	h := sha1.New()
+	if _, copyErr := io.Copy(h, io.NewSectionReader(f, start, end-start)); copyErr != nil {
+		return "", audioHashError(fullPath, copyErr)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// audioRegion returns the byte range of a file that holds the audio, leaving out its tags.
+func audioRegion(f io.ReaderAt, format Format, size int64) (int64, int64, error) {
+	if format == FormatFLAC {
+		r, flacSize, err := skipID3v2Prefix(f, format, size)
+		if err != nil {
+			return 0, 0, err
+		}
+		fm, err := readFLAC(r, flacSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		return size - flacSize + fm.audioStart, size, nil
+	}
+	return mp3AudioRegion(f, size)
+}
+
+func audioHashError(fullPath string, err error) virErrors.ScopedError {
+	if os.IsPermission(err) {
+		return virErrors.ErrPermissionDenied("vir/track.AudioHash", fullPath, err)
+	}
+	return virErrors.ErrFileHashFailed("vir/track.AudioHash", fullPath, err)
+}
@@ -0,0 +1,187 @@
+package track
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// readID3Metadata reads the tags of an MP3 file, preferring an ID3v2 tag at the start of the file to an ID3v1 tag at
+// the end.
+func readID3Metadata(r io.ReaderAt, size int64, tr *Track) error {
+	v2Tag, err := readID3v2Tag(io.NewSectionReader(r, 0, size))
+	if err != nil && v2Tag == nil {
+		return err
+	} else if err != nil {
+		tr.Errata = append(tr.Errata, err.Error())
+	}
+
+	if v2Tag != nil {
+		tr.TagVersion = fmt.Sprintf("2.%d.%d", v2Tag.version, v2Tag.revision)
+		tr.Metadata, tr.Errata = metadataFromID3v2(v2Tag, tr.Errata)
+		tr.pictures = v2Tag.pictures()
+		return nil
+	}
+
+	v1Tag, err := readID3v1Tag(r, size)
+	if err != nil {
+		return err
+	}
+
+	if v1Tag != nil {
+		tr.TagVersion = v1Tag.version()
+		tr.Metadata, tr.Errata = metadataFromID3v1(v1Tag, tr.Errata)
+		return nil
+	}
+
+	tr.Number = -1
+	tr.Errata = append(tr.Errata, "no track number (TRCK) in tags")
+	return nil
+}
+
+func metadataFromID3v2(t *id3v2Tag, errata []string) (Metadata, []string) {
+	m := Metadata{
+		Title:       clean(t.text("TIT2")),
+		Artist:      clean(t.text("TPE1")),
+		Album:       clean(t.text("TALB")),
+		AlbumArtist: clean(t.text("TPE2")),
+		Composer:    clean(t.text("TCOM")),
+		Comment:     t.comment(),
+		ISRC:        t.text("TSRC"),
+		MusicBrainz: MusicBrainzIDs{
+			RecordingID:    t.uniqueFileID("http://musicbrainz.org"),
+			TrackID:        t.userText("MusicBrainz Release Track Id"),
+			AlbumID:        t.userText("MusicBrainz Album Id"),
+			ArtistID:       t.userText("MusicBrainz Artist Id"),
+			AlbumArtistID:  t.userText("MusicBrainz Album Artist Id"),
+			ReleaseGroupID: t.userText("MusicBrainz Release Group Id"),
+		},
+	}
+
+	var genres []string
+	for _, g := range t.textValues("TCON") {
+		if g = parseGenre(g); g != "" {
+			genres = append(genres, g)
+		}
+	}
+	m.Genre = strings.Join(genres, "; ")
+
+	var err error
+	if trck := t.text("TRCK"); trck == "" {
+		m.Number = -1
+		errata = append(errata, "no track number (TRCK) in tags")
+	} else if m.Number, m.TrackTotal, err = parseNumberPair("track number", "TRCK", trck); err != nil {
+		errata = append(errata, err.Error())
+	}
+
+	if tpos := t.text("TPOS"); tpos != "" {
+		m.DiscNumber, m.DiscTotal, err = parseNumberPair("disc number", "TPOS", tpos)
+		if err != nil {
+			errata = append(errata, err.Error())
+		}
+		if m.DiscNumber < 0 {
+			m.DiscNumber = 0
+		}
+	}
+
+	// v2.4 replaced TYER and friends with TDRC, but plenty of taggers use whichever they like
+	dateFrame, date := "TDRC", t.text("TDRC")
+	if date == "" {
+		dateFrame, date = "TYER", t.text("TYER")
+	}
+	if date != "" {
+		m.Date = date
+		if m.Year, err = parseYear(dateFrame, date); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if bpm := t.text("TBPM"); bpm != "" {
+		if m.BPM, err = parseBPM("TBPM", bpm); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if tcmp := t.text("TCMP"); tcmp != "" {
+		if m.Compilation, err = parseFlag("compilation flag", "TCMP", tcmp); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	return m, errata
+}
+
+func metadataFromID3v1(t *id3v1Tag, errata []string) (Metadata, []string) {
+	m := Metadata{
+		Title:   clean(t.title),
+		Artist:  clean(t.artist),
+		Album:   clean(t.album),
+		Number:  t.track,
+		Comment: t.comment,
+		Genre:   genreName(int(t.genre)),
+	}
+
+	if t.track == 0 {
+		m.Number = -1
+		errata = append(errata, "no track number (TRCK) in tags")
+	}
+
+	if t.year != "" {
+		m.Date = t.year
+
+		var err error
+		if m.Year, err = parseYear("ID3v1", t.year); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	return m, errata
+}
+
+// pictures reads the APIC frames of a tag, or the PIC frames of a v2.2 tag. Frames that can't be read, and those
+// that only link to an image elsewhere, are left out.
+func (t *id3v2Tag) pictures() []Picture {
+	var pictures []Picture
+	for _, f := range t.framesWithID("APIC") {
+		data, err := f.content(t.version)
+		if err != nil {
+			continue
+		}
+		if t.version == 2 {
+			var ok bool
+			if data, ok = convertPIC(data); !ok {
+				continue
+			}
+		}
+		if p, ok := parseAPIC(data); ok {
+			pictures = append(pictures, p)
+		}
+	}
+	return pictures
+}
+
+// parseAPIC parses the content of an APIC frame: a text encoding, the MIME type, the picture type, a description in
+// the text encoding and then the image.
+func parseAPIC(b []byte) (Picture, bool) {
+	if len(b) < 3 {
+		return Picture{}, false
+	}
+	encoding := b[0]
+	b = b[1:]
+
+	end, next := findNull(b, false)
+	mime := strings.ToLower(decodeLatin1(b[:end]))
+	if mime == "-->" || next >= len(b) {
+		return Picture{}, false
+	}
+	pictureType := int(b[next])
+	b = b[next+1:]
+
+	end, next = findNull(b, encoding == id3EncodingUTF16 || encoding == id3EncodingUTF16BE)
+	return Picture{
+		Type:        pictureType,
+		MIME:        mime,
+		Description: decodeText(encoding, b[:end]),
+		Data:        b[next:],
+	}, true
+}
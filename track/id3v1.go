@@ -0,0 +1,163 @@
+package track
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// id3v1Len is the fixed size of an ID3v1 tag, which lives in the last 128 bytes of a file.
+const id3v1Len = 128
+
+// id3v1Tag is an ID3v1 or ID3v1.1 tag. Track is 0 in a v1.0 tag, which has no room for one.
+type id3v1Tag struct {
+	title   string
+	artist  string
+	album   string
+	year    string
+	comment string
+	track   int
+	genre   byte
+}
+
+// readID3v1Tag reads the ID3v1 tag at the end of a file of the given size, returning nil if there isn't one.
+func readID3v1Tag(r io.ReaderAt, size int64) (*id3v1Tag, error) {
+	if size < id3v1Len {
+		return nil, nil
+	}
+
+	b := make([]byte, id3v1Len)
+	if _, err := r.ReadAt(b, size-id3v1Len); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if string(b[:3]) != "TAG" {
+		return nil, nil
+	}
+
+	t := &id3v1Tag{
+		title:   id3v1String(b[3:33]),
+		artist:  id3v1String(b[33:63]),
+		album:   id3v1String(b[63:93]),
+		year:    id3v1String(b[93:97]),
+		comment: id3v1String(b[97:127]),
+		genre:   b[127],
+	}
+
+	// v1.1 steals the last two bytes of the comment for a zero and the track number
+	if b[125] == 0 && b[126] != 0 {
+		t.comment = id3v1String(b[97:125])
+		t.track = int(b[126])
+	}
+
+	return t, nil
+}
+
+func (t *id3v1Tag) version() string {
+	if t.track != 0 {
+		return "1.1"
+	}
+	return "1.0"
+}
+
+// id3v1String decodes a fixed-width ID3v1 field, which is ISO-8859-1 padded with nulls or spaces.
+func id3v1String(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(decodeLatin1(b), " ")
+}
+
+// genreName returns the name of an ID3v1 genre, or "" if the number isn't one.
+func genreName(n int) string {
+	if n < 0 || n >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[n]
+}
+
+// parseGenre resolves the genre references in a TCON frame.
+//
+// ID3v2.3 refers to ID3v1 genres by number in brackets, optionally followed by a refinement that takes precedence,
+// e.g. "(17)" or "(17)Rock", with "((" escaping a literal bracket. ID3v2.4 uses bare numbers instead, plus the
+// keywords "RX" and "CR". Anything else is a genre name already.
+func parseGenre(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	if n, err := strconv.Atoi(s); err == nil {
+		if name := genreName(n); name != "" {
+			return name
+		}
+		return s
+	}
+
+	switch s {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+
+	var first string
+	for strings.HasPrefix(s, "(") && !strings.HasPrefix(s, "((") {
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			break
+		}
+
+		ref := s[1:end]
+		s = s[end+1:]
+
+		if first != "" {
+			continue
+		}
+		switch ref {
+		case "RX":
+			first = "Remix"
+		case "CR":
+			first = "Cover"
+		default:
+			if n, err := strconv.Atoi(ref); err == nil {
+				first = genreName(n)
+			}
+		}
+	}
+
+	if strings.HasPrefix(s, "((") {
+		s = s[1:]
+	}
+	if s != "" {
+		return s
+	}
+	return first
+}
+
+// id3v1Genres are the genres of ID3v1, including the Winamp extensions, indexed by genre number.
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore Techno", "Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "Jpop", "Synthpop", "Abstract", "Art Rock", "Baroque", "Bhangra",
+	"Big Beat", "Breakbeat", "Chillout", "Downtempo", "Dub", "EBM", "Eclectic", "Electro",
+	"Electroclash", "Emo", "Experimental", "Garage", "Global", "IDM", "Illbient", "Industro-Goth",
+	"Jam Band", "Krautrock", "Leftfield", "Lounge", "Math Rock", "New Romantic", "Nu-Breakz", "Post-Punk",
+	"Post-Rock", "Psytrance", "Shoegaze", "Space Rock", "Trop Rock", "World Music", "Neoclassical", "Audiobook",
+	"Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock", "G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
@@ -0,0 +1,131 @@
+package track
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// fixtureID3v1 builds a file of audio followed by an ID3v1 tag. A track of 0 makes it a v1.0 tag.
+func fixtureID3v1(title, artist, album, year, comment string, track int, genre byte) []byte {
+	b := append(bytes.Repeat([]byte{0xff}, 64), "TAG"...)
+	for _, field := range []struct {
+		value string
+		width int
+	}{{title, 30}, {artist, 30}, {album, 30}, {year, 4}} {
+		b = append(b, fixtureID3v1Field(field.value, field.width)...)
+	}
+
+	if track == 0 {
+		b = append(b, fixtureID3v1Field(comment, 30)...)
+	} else {
+		b = append(b, fixtureID3v1Field(comment, 28)...)
+		b = append(b, 0, byte(track))
+	}
+	return append(b, genre)
+}
+
+// fixtureID3v1Field pads an ISO-8859-1 field with nulls, as most taggers do.
+func fixtureID3v1Field(s string, width int) []byte {
+	b := make([]byte, width)
+	i := 0
+	for _, r := range s {
+		b[i] = byte(r)
+		i++
+	}
+	return b
+}
+
+func TestReadID3v1Tag(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    []byte
+		version string
+		want    Metadata
+		errata  []string
+	}{
+		{
+			name:    "v1.0",
+			file:    fixtureID3v1("Roygbiv", "Boards of Canada", "Music Has the Right", "1998", "a comment filling thirty bytes", 0, 26),
+			version: "1.0",
+			want: Metadata{
+				Title: "Roygbiv", Artist: "Boards of Canada", Album: "Music Has the Right", Number: -1,
+				Year: 1998, Date: "1998", Comment: "a comment filling thirty bytes", Genre: "Ambient",
+			},
+			errata: []string{"no track number (TRCK) in tags"},
+		},
+		{
+			name:    "v1.1",
+			file:    fixtureID3v1("Björk", "Sigur Rós", "Ágætis byrjun", "1999", "comment", 7, 17),
+			version: "1.1",
+			want: Metadata{
+				Title: "Björk", Artist: "Sigur Rós", Album: "Ágætis byrjun", Number: 7,
+				Year: 1999, Date: "1999", Comment: "comment", Genre: "Rock",
+			},
+		},
+		{
+			name:    "unknown genre and bad year",
+			file:    fixtureID3v1("Title", "Artist", "Album", "19x9", "", 1, 255),
+			version: "1.1",
+			want:    Metadata{Title: "Title", Artist: "Artist", Album: "Album", Number: 1, Date: "19x9"},
+			errata:  []string{`invalid year (ID3v1) in tags: "19x9"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, err := readID3v1Tag(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("readID3v1Tag: %s", err)
+			}
+			if tag == nil {
+				t.Fatal("readID3v1Tag found no tag")
+			}
+			if v := tag.version(); v != tt.version {
+				t.Errorf("version = %s, want %s", v, tt.version)
+			}
+
+			m, errata := metadataFromID3v1(tag, nil)
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("metadata =\n%+v\nwant\n%+v", m, tt.want)
+			}
+			if !reflect.DeepEqual(errata, tt.errata) {
+				t.Errorf("errata = %q, want %q", errata, tt.errata)
+			}
+		})
+	}
+}
+
+func TestReadID3v1TagAbsent(t *testing.T) {
+	for _, b := range [][]byte{nil, bytes.Repeat([]byte{0xff}, 200), []byte("TAG")} {
+		tag, err := readID3v1Tag(bytes.NewReader(b), int64(len(b)))
+		if tag != nil || err != nil {
+			t.Errorf("readID3v1Tag found %v, %v in %d bytes without a tag", tag, err, len(b))
+		}
+	}
+}
+
+func TestParseGenre(t *testing.T) {
+	tests := []struct {
+		tcon string
+		want string
+	}{
+		{"", ""},
+		{"Trip-Hop", "Trip-Hop"},
+		{"17", "Rock"},
+		{"(17)", "Rock"},
+		{"(17)Krautrock", "Krautrock"},
+		{"(17)(26)", "Rock"},
+		{"((17) is a number", "(17) is a number"},
+		{"RX", "Remix"},
+		{"(CR)", "Cover"},
+		{"255", "255"},
+		{"(255)", ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseGenre(tt.tcon); got != tt.want {
+			t.Errorf("parseGenre(%q) = %q, want %q", tt.tcon, got, tt.want)
+		}
+	}
+}
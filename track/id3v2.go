@@ -0,0 +1,485 @@
+package track
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+)
+
+// ID3v2 header flags.
+const (
+	id3v2FlagUnsync         = 0x80
+	id3v2FlagExtendedHeader = 0x40
+	id3v2FlagFooter         = 0x10
+
+	// v2.2 used the bit v2.3 gave to the extended header to mark a compressed tag, for which no compression scheme
+	// was ever defined.
+	id3v22FlagCompressed = 0x40
+)
+
+// id3v2HeaderLen is the length of the header at the start of every ID3v2 tag (and of the optional footer).
+const id3v2HeaderLen = 10
+
+// id3v2Tag is an ID3v2 tag, with its frames kept in the order they appeared so it can be written back faithfully.
+type id3v2Tag struct {
+	version  byte // 2, 3 or 4
+	revision byte
+	flags    byte
+	frames   []*id3v2Frame
+
+	// size is the number of bytes the tag took up in the file, including its header, footer and padding.
+	size int64
+}
+
+// id3v2Frame is a single frame. data is exactly what was in the file after the tag-level unsynchronisation was
+// undone, so frames vir doesn't understand can be written back untouched.
+type id3v2Frame struct {
+	id    string
+	flags uint16
+	data  []byte
+}
+
+// Frame format flags. v2.3 and v2.4 use different bits.
+const (
+	id3v23FrameCompressed = 0x0080
+	id3v23FrameEncrypted  = 0x0040
+	id3v23FrameGrouped    = 0x0020
+
+	id3v24FrameGrouped    = 0x0040
+	id3v24FrameCompressed = 0x0008
+	id3v24FrameEncrypted  = 0x0004
+	id3v24FrameUnsync     = 0x0002
+	id3v24FrameDataLength = 0x0001
+)
+
+// readID3v2Tag reads the ID3v2 tag at the start of r, returning nil if there isn't one.
+//
+// If the tag is readable but one of its frames is damaged, both the tag (with the frames before the damage) and an
+// error are returned.
+func readID3v2Tag(r io.Reader) (*id3v2Tag, error) {
+	header := make([]byte, id3v2HeaderLen)
+	if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if string(header[:3]) != "ID3" {
+		return nil, nil
+	}
+
+	t := &id3v2Tag{
+		version:  header[3],
+		revision: header[4],
+		flags:    header[5],
+		size:     id3v2Size(header),
+	}
+
+	if t.version < 2 || t.version > 4 {
+		return nil, fmt.Errorf("unsupported ID3v2 version 2.%d", t.version)
+	}
+
+	body := make([]byte, synchsafe(header[6:10]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("truncated ID3v2 tag: %s", err)
+	}
+
+	// v2.4 unsynchronises each frame individually, rather than the whole tag
+	if t.flags&id3v2FlagUnsync != 0 && t.version < 4 {
+		body = removeUnsync(body)
+	}
+
+	if t.version == 2 && t.flags&id3v22FlagCompressed != 0 {
+		return nil, fmt.Errorf("compressed ID3v2.2 tags are not supported")
+	}
+
+	if t.version > 2 && t.flags&id3v2FlagExtendedHeader != 0 {
+		var err error
+		body, err = skipExtendedHeader(t.version, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a damaged frame still leaves us with the frames before it
+	frames, err := parseID3v2Frames(t.version, body)
+	t.frames = frames
+
+	return t, err
+}
+
+func skipExtendedHeader(version byte, body []byte) ([]byte, error) {
+	if len(body) < 4 {
+		return nil, fmt.Errorf("truncated ID3v2 extended header")
+	}
+
+	var n int
+	if version == 4 {
+		// the size includes itself
+		n = int(synchsafe(body[:4]))
+	} else {
+		n = int(binary.BigEndian.Uint32(body[:4])) + 4
+	}
+
+	if n > len(body) {
+		return nil, fmt.Errorf("truncated ID3v2 extended header")
+	}
+	return body[n:], nil
+}
+
+func parseID3v2Frames(version byte, body []byte) ([]*id3v2Frame, error) {
+	var frames []*id3v2Frame
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for len(body) >= headerLen {
+		// anything after a zero byte where a frame ID should be is padding
+		if body[0] == 0 {
+			break
+		}
+
+		id := string(body[:idLen])
+		if !isValidFrameID(id) {
+			return frames, fmt.Errorf("invalid ID3v2 frame ID %q", id)
+		}
+
+		var size int
+		var flags uint16
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+			flags = binary.BigEndian.Uint16(body[8:10])
+		case 4:
+			size = v24FrameSize(body)
+			flags = binary.BigEndian.Uint16(body[8:10])
+		}
+
+		if headerLen+size > len(body) {
+			return frames, fmt.Errorf("ID3v2 frame %s runs past the end of the tag", id)
+		}
+
+		data := make([]byte, size)
+		copy(data, body[headerLen:headerLen+size])
+		frames = append(frames, &id3v2Frame{id: id, flags: flags, data: data})
+
+		body = body[headerLen+size:]
+	}
+
+	return frames, nil
+}
+
+// v24FrameSize reads the size of a v2.4 frame, which should be synchsafe. Some taggers (notably old versions of
+// iTunes) wrote plain integers instead, so if the synchsafe size doesn't land on another frame or padding but the
+// plain one does, we go with that.
+func v24FrameSize(body []byte) int {
+	size := int(synchsafe(body[4:8]))
+	plain := int(binary.BigEndian.Uint32(body[4:8]))
+	if size == plain || landsOnFrame(body, size) || !landsOnFrame(body, plain) {
+		return size
+	}
+	return plain
+}
+
+func landsOnFrame(body []byte, size int) bool {
+	next := 10 + size
+	if next == len(body) {
+		return true
+	}
+	if next+4 > len(body) {
+		return false
+	}
+	return body[next] == 0 || isValidFrameID(string(body[next:next+4]))
+}
+
+func isValidFrameID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// content returns the frame's payload with any frame-level compression or unsynchronisation undone.
+// Encrypted frames can't be read at all.
+func (f *id3v2Frame) content(version byte) ([]byte, error) {
+	data := f.data
+
+	var compressed bool
+	switch version {
+	case 3:
+		if f.flags&id3v23FrameEncrypted != 0 {
+			return nil, fmt.Errorf("frame %s is encrypted", f.id)
+		}
+		compressed = f.flags&id3v23FrameCompressed != 0
+		if compressed {
+			// decompressed size
+			data = skipBytes(data, 4)
+		}
+		if f.flags&id3v23FrameGrouped != 0 {
+			data = skipBytes(data, 1)
+		}
+	case 4:
+		if f.flags&id3v24FrameEncrypted != 0 {
+			return nil, fmt.Errorf("frame %s is encrypted", f.id)
+		}
+		compressed = f.flags&id3v24FrameCompressed != 0
+		if f.flags&id3v24FrameGrouped != 0 {
+			data = skipBytes(data, 1)
+		}
+		if f.flags&id3v24FrameDataLength != 0 {
+			data = skipBytes(data, 4)
+		}
+		if f.flags&id3v24FrameUnsync != 0 {
+			data = removeUnsync(data)
+		}
+	}
+
+	if !compressed {
+		return data, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("frame %s: %s", f.id, err)
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+func skipBytes(b []byte, n int) []byte {
+	if n > len(b) {
+		return nil
+	}
+	return b[n:]
+}
+
+// framesWithID returns every frame with the given (v2.3/v2.4) ID. Frames in v2.2 tags are matched by their v2.3
+// equivalents.
+func (t *id3v2Tag) framesWithID(id string) []*id3v2Frame {
+	var found []*id3v2Frame
+	for _, f := range t.frames {
+		fid := f.id
+		if t.version == 2 {
+			fid = id3v22FrameIDs[fid]
+		}
+		if fid == id {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// textValues returns the values of the first text frame with the given ID. v2.4 allows several values in one
+// frame, separated by nulls; earlier versions only ever have one.
+func (t *id3v2Tag) textValues(id string) []string {
+	frames := t.framesWithID(id)
+	if len(frames) == 0 {
+		return nil
+	}
+
+	data, err := frames[0].content(t.version)
+	if err != nil || len(data) < 1 {
+		return nil
+	}
+
+	return decodeTextValues(data[0], data[1:])
+}
+
+// text returns the first value of the first text frame with the given ID.
+func (t *id3v2Tag) text(id string) string {
+	values := t.textValues(id)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// userText returns the value of the TXXX frame with the given description, compared case-insensitively.
+func (t *id3v2Tag) userText(description string) string {
+	for _, f := range t.framesWithID("TXXX") {
+		data, err := f.content(t.version)
+		if err != nil || len(data) < 1 {
+			continue
+		}
+		values := decodeTextValues(data[0], data[1:])
+		if len(values) >= 2 && equalFold(values[0], description) {
+			return values[1]
+		}
+	}
+	return ""
+}
+
+// comment returns the text of the first COMM frame without a description, or failing that the first one at all.
+// iTunes stores all sorts of things in described comments, so those are only a last resort.
+func (t *id3v2Tag) comment() string {
+	var fallback string
+	for _, f := range t.framesWithID("COMM") {
+		data, err := f.content(t.version)
+		// encoding, 3-byte language, description, text
+		if err != nil || len(data) < 4 {
+			continue
+		}
+		values := decodeTextValues(data[0], data[4:])
+		if len(values) < 2 {
+			continue
+		}
+		if values[0] == "" {
+			return values[1]
+		}
+		if fallback == "" {
+			fallback = values[1]
+		}
+	}
+	return fallback
+}
+
+// uniqueFileID returns the identifier of the UFID frame with the given owner.
+func (t *id3v2Tag) uniqueFileID(owner string) string {
+	for _, f := range t.framesWithID("UFID") {
+		data, err := f.content(t.version)
+		if err != nil {
+			continue
+		}
+		i := bytes.IndexByte(data, 0)
+		if i >= 0 && string(data[:i]) == owner {
+			return string(data[i+1:])
+		}
+	}
+	return ""
+}
+
+// Text encodings used in ID3v2 frames.
+const (
+	id3EncodingISO88591 = 0
+	id3EncodingUTF16    = 1
+	id3EncodingUTF16BE  = 2
+	id3EncodingUTF8     = 3
+)
+
+// decodeTextValues decodes null-separated strings in the given encoding. A single trailing null is allowed.
+func decodeTextValues(encoding byte, b []byte) []string {
+	var values []string
+
+	wide := encoding == id3EncodingUTF16 || encoding == id3EncodingUTF16BE
+	for {
+		end, next := findNull(b, wide)
+		values = append(values, decodeText(encoding, b[:end]))
+		if next >= len(b) {
+			break
+		}
+		b = b[next:]
+	}
+
+	return values
+}
+
+// findNull finds the end of the first null-terminated string in b, returning where the string ends and where the
+// next one starts. Without a terminator, the string is all of b.
+func findNull(b []byte, wide bool) (int, int) {
+	if !wide {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return i, i + 1
+		}
+		return len(b), len(b)
+	}
+
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] == 0 {
+			return i, i + 2
+		}
+	}
+	return len(b), len(b)
+}
+
+func decodeText(encoding byte, b []byte) string {
+	switch encoding {
+	case id3EncodingUTF16:
+		bigEndian := true
+		if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+			bigEndian = false
+			b = b[2:]
+		} else if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+			b = b[2:]
+		}
+		return decodeUTF16(b, bigEndian)
+	case id3EncodingUTF16BE:
+		return decodeUTF16(b, true)
+	case id3EncodingUTF8:
+		return string(bytes.TrimPrefix(b, []byte{0xef, 0xbb, 0xbf}))
+	default:
+		return decodeLatin1(b)
+	}
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeLatin1(b []byte) string {
+	buf := make([]byte, 0, len(b))
+	for _, c := range b {
+		buf = append(buf, string(rune(c))...)
+	}
+	return string(buf)
+}
+
+// synchsafe decodes a 4-byte synchsafe integer, where only the low 7 bits of each byte are used.
+func synchsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// removeUnsync undoes unsynchronisation, which inserts a zero byte after every 0xff that could be mistaken for an
+// MPEG frame sync.
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+func equalFold(a, b string) bool {
+	return bytes.EqualFold([]byte(a), []byte(b))
+}
+
+// id3v22FrameIDs maps the 3-character frame IDs of ID3v2.2 to their ID3v2.3 equivalents.
+var id3v22FrameIDs = map[string]string{
+	"BUF": "RBUF", "COM": "COMM", "CRA": "AENC", "EQU": "EQUA",
+	"ETC": "ETCO", "GEO": "GEOB", "MCI": "MCDI", "MLL": "MLLT",
+	"PIC": "APIC", "POP": "POPM", "REV": "RVRB", "RVA": "RVAD",
+	"SLT": "SYLT", "STC": "SYTC", "TAL": "TALB", "TBP": "TBPM",
+	"TCM": "TCOM", "TCO": "TCON", "TCP": "TCMP", "TCR": "TCOP",
+	"TDA": "TDAT", "TDY": "TDLY", "TEN": "TENC", "TFT": "TFLT",
+	"TIM": "TIME", "TKE": "TKEY", "TLA": "TLAN", "TLE": "TLEN",
+	"TMT": "TMED", "TOA": "TOPE", "TOF": "TOFN", "TOL": "TOLY",
+	"TOR": "TORY", "TOT": "TOAL", "TP1": "TPE1", "TP2": "TPE2",
+	"TP3": "TPE3", "TP4": "TPE4", "TPA": "TPOS", "TPB": "TPUB",
+	"TRC": "TSRC", "TRD": "TRDA", "TRK": "TRCK", "TSI": "TSIZ",
+	"TSS": "TSSE", "TT1": "TIT1", "TT2": "TIT2", "TT3": "TIT3",
+	"TXT": "TEXT", "TXX": "TXXX", "TYE": "TYER", "UFI": "UFID",
+	"ULT": "USLT", "WAF": "WOAF", "WAR": "WOAR", "WAS": "WOAS",
+	"WCM": "WCOM", "WCP": "WCOP", "WPB": "WPUB", "WXX": "WXXX",
+}
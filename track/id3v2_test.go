@@ -0,0 +1,423 @@
+package track
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodings a fixture's text frames can be written in. utf16LE and utf16BOMBE are both encoding 1, with a byte order
+// mark saying which way round they are.
+const (
+	latin1 = iota
+	utf16LE
+	utf16BOMBE
+	utf16BE
+	utf8
+)
+
+var encodingNames = []string{"ISO-8859-1", "UTF-16 LE with BOM", "UTF-16 BE with BOM", "UTF-16BE", "UTF-8"}
+
+// encodeFixtureText encodes null-separated values the way a tagger would, with the encoding byte in front.
+func encodeFixtureText(enc int, values ...string) []byte {
+	var buf bytes.Buffer
+	switch enc {
+	case latin1:
+		buf.WriteByte(id3EncodingISO88591)
+	case utf16LE, utf16BOMBE:
+		buf.WriteByte(id3EncodingUTF16)
+	case utf16BE:
+		buf.WriteByte(id3EncodingUTF16BE)
+	case utf8:
+		buf.WriteByte(id3EncodingUTF8)
+	}
+
+	for i, v := range values {
+		if i > 0 {
+			buf.Write(fixtureNull(enc))
+		}
+		switch enc {
+		case latin1:
+			for _, r := range v {
+				buf.WriteByte(byte(r))
+			}
+		case utf8:
+			buf.WriteString(v)
+		default:
+			order := binary.ByteOrder(binary.BigEndian)
+			if enc == utf16LE {
+				order = binary.LittleEndian
+			}
+			if enc != utf16BE {
+				bom := make([]byte, 2)
+				order.PutUint16(bom, 0xfeff)
+				buf.Write(bom)
+			}
+			for _, u := range utf16.Encode([]rune(v)) {
+				b := make([]byte, 2)
+				order.PutUint16(b, u)
+				buf.Write(b)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// encodeFixtureComment encodes the payload of a COMM frame, whose language comes between the encoding and the text.
+func encodeFixtureComment(enc int, description, text string) []byte {
+	b := encodeFixtureText(enc, description, text)
+	return append(append([]byte{b[0]}, "eng"...), b[1:]...)
+}
+
+func fixtureNull(enc int) []byte {
+	if enc == utf16LE || enc == utf16BOMBE || enc == utf16BE {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+// fixtureFrame is a frame of a fixture tag, by its v2.3 ID.
+type fixtureFrame struct {
+	id      string
+	payload []byte
+}
+
+// fixtureTag builds an ID3v2 tag of the given version, with the frame IDs translated for v2.2.
+func fixtureTag(version byte, flags byte, extendedHeader bool, frames []fixtureFrame) []byte {
+	v22IDs := make(map[string]string)
+	for v22, v23 := range id3v22FrameIDs {
+		v22IDs[v23] = v22
+	}
+
+	var body bytes.Buffer
+	if extendedHeader {
+		flags |= id3v2FlagExtendedHeader
+		if version == 4 {
+			// synchsafe size including itself, one flag byte, no flags
+			body.Write([]byte{0, 0, 0, 6, 1, 0})
+		} else {
+			// size not including itself, two flag bytes and a padding size
+			body.Write([]byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0})
+		}
+	}
+
+	for _, f := range frames {
+		size := len(f.payload)
+		switch version {
+		case 2:
+			body.WriteString(v22IDs[f.id])
+			body.Write([]byte{byte(size >> 16), byte(size >> 8), byte(size)})
+		case 3:
+			body.WriteString(f.id)
+			binary.Write(&body, binary.BigEndian, uint32(size))
+			body.Write([]byte{0, 0})
+		case 4:
+			body.WriteString(f.id)
+			body.Write(synchsafeBytes(size))
+			body.Write([]byte{0, 0})
+		}
+		body.Write(f.payload)
+	}
+
+	// some padding, as taggers leave
+	body.Write(make([]byte, 16))
+
+	b := body.Bytes()
+	if flags&id3v2FlagUnsync != 0 {
+		b = addUnsync(b)
+	}
+
+	header := []byte{'I', 'D', '3', version, 0, flags}
+	header = append(header, synchsafeBytes(len(b))...)
+	return append(header, b...)
+}
+
+func synchsafeBytes(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+// addUnsync unsynchronises b, putting a zero after every 0xff.
+func addUnsync(b []byte) []byte {
+	var out []byte
+	for _, c := range b {
+		out = append(out, c)
+		if c == 0xff {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+func TestReadID3v2TagVersionsAndEncodings(t *testing.T) {
+	// ÿ is 0xff in ISO-8859-1, which unsynchronisation has to escape
+	title := "Rÿksopp Dünk"
+	comment := strings.Repeat("a long comment to push the frame size past 127 bytes ", 3)
+
+	frames := func(enc int, version byte) []fixtureFrame {
+		date := fixtureFrame{"TYER", encodeFixtureText(enc, "1998")}
+		if version == 4 {
+			date = fixtureFrame{"TDRC", encodeFixtureText(enc, "1998-04-20")}
+		}
+		return []fixtureFrame{
+			{"TIT2", encodeFixtureText(enc, title)},
+			{"TPE1", encodeFixtureText(enc, "Boards of Canada")},
+			{"TALB", encodeFixtureText(enc, "Music Has the Right to Children")},
+			{"TPE2", encodeFixtureText(enc, "Various Artists")},
+			{"TRCK", encodeFixtureText(enc, "3/17")},
+			{"TPOS", encodeFixtureText(enc, "1/2")},
+			date,
+			{"TCON", encodeFixtureText(enc, "(26)")},
+			{"TCOM", encodeFixtureText(enc, "Sandison")},
+			{"TBPM", encodeFixtureText(enc, "94")},
+			{"TCMP", encodeFixtureText(enc, "1")},
+			{"TSRC", encodeFixtureText(enc, "GBAAA9800001")},
+			{"COMM", encodeFixtureComment(enc, "", comment)},
+			{"TXXX", encodeFixtureText(enc, "MusicBrainz Album Id", "0b6e4c5f-2ac4-4f4b-9b2e-2d3f5a0e7d1c")},
+			{"UFID", []byte("http://musicbrainz.org\x00e1b2c3d4-0000-4000-8000-000000000000")},
+		}
+	}
+
+	want := func(version byte) Metadata {
+		m := Metadata{
+			Title:       title,
+			Artist:      "Boards of Canada",
+			Album:       "Music Has the Right to Children",
+			AlbumArtist: "Various Artists",
+			Number:      3,
+			TrackTotal:  17,
+			DiscNumber:  1,
+			DiscTotal:   2,
+			Year:        1998,
+			Date:        "1998",
+			Genre:       "Ambient",
+			Composer:    "Sandison",
+			Comment:     comment,
+			BPM:         94,
+			Compilation: true,
+			ISRC:        "GBAAA9800001",
+			MusicBrainz: MusicBrainzIDs{
+				RecordingID: "e1b2c3d4-0000-4000-8000-000000000000",
+				AlbumID:     "0b6e4c5f-2ac4-4f4b-9b2e-2d3f5a0e7d1c",
+			},
+		}
+		if version == 4 {
+			m.Date = "1998-04-20"
+		}
+		return m
+	}
+
+	for _, version := range []byte{2, 3, 4} {
+		for enc := range encodingNames {
+			if enc == utf16BE || enc == utf8 {
+				if version < 4 {
+					// only v2.4 has these encodings
+					continue
+				}
+			}
+
+			for _, variant := range []struct {
+				name           string
+				flags          byte
+				extendedHeader bool
+			}{
+				{"plain", 0, false},
+				{"unsynchronised", id3v2FlagUnsync, false},
+				{"extended header", 0, true},
+			} {
+				if version == 2 && variant.extendedHeader {
+					// v2.2 used that flag bit for compression instead; see TestReadID3v22Compressed
+					continue
+				}
+				if version == 4 && variant.flags&id3v2FlagUnsync != 0 {
+					// v2.4 unsynchronises frames rather than the whole tag; see TestReadID3v24FrameUnsync
+					continue
+				}
+
+				name := "v2." + string('0'+version) + " " + encodingNames[enc] + " " + variant.name
+				t.Run(name, func(t *testing.T) {
+					b := fixtureTag(version, variant.flags, variant.extendedHeader, frames(enc, version))
+					tag, err := readID3v2Tag(bytes.NewReader(b))
+					if err != nil {
+						t.Fatalf("readID3v2Tag: %s", err)
+					}
+					if tag == nil {
+						t.Fatal("readID3v2Tag found no tag")
+					}
+					if tag.version != version {
+						t.Errorf("version = %d, want %d", tag.version, version)
+					}
+					if tag.size != int64(len(b)) {
+						t.Errorf("size = %d, want %d", tag.size, len(b))
+					}
+
+					m, errata := metadataFromID3v2(tag, nil)
+					if len(errata) > 0 {
+						t.Errorf("errata: %q", errata)
+					}
+					if w := want(version); !reflect.DeepEqual(m, w) {
+						t.Errorf("metadata =\n%+v\nwant\n%+v", m, w)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestReadID3v22Compressed(t *testing.T) {
+	// the flag that means an extended header in later versions; the body is not one
+	b := fixtureTag(2, id3v22FlagCompressed, false, []fixtureFrame{{"TIT2", encodeFixtureText(latin1, "Title")}})
+	tag, err := readID3v2Tag(bytes.NewReader(b))
+	if tag != nil || err == nil || !strings.Contains(err.Error(), "compressed") {
+		t.Errorf("readID3v2Tag = %v, %v; want an error saying the tag is compressed", tag, err)
+	}
+}
+
+func TestEncodeID3v2UpgradesV22(t *testing.T) {
+	b := fixtureTag(2, 0, false, []fixtureFrame{
+		{"TIT2", encodeFixtureText(latin1, "Title")},
+		{"TPE1", encodeFixtureText(utf16LE, "Artíst")},
+		{"TRCK", encodeFixtureText(latin1, "3/12")},
+		{"COMM", encodeFixtureComment(latin1, "", "comment")},
+	})
+	tag, err := readID3v2Tag(bytes.NewReader(b))
+	if err != nil || tag == nil {
+		t.Fatalf("readID3v2Tag = %v, %v", tag, err)
+	}
+	want, _ := metadataFromID3v2(tag, nil)
+
+	tag.upgradeToV23()
+	encoded, err := tag.encode(0)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	reread, err := readID3v2Tag(bytes.NewReader(encoded))
+	if err != nil || reread == nil {
+		t.Fatalf("readID3v2Tag of the encoded tag = %v, %v", reread, err)
+	}
+	if reread.version != 3 {
+		t.Errorf("version = %d, want 3", reread.version)
+	}
+	if reread.size != int64(len(encoded)) {
+		t.Errorf("size = %d, want %d", reread.size, len(encoded))
+	}
+	if m, _ := metadataFromID3v2(reread, nil); !reflect.DeepEqual(m, want) {
+		t.Errorf("metadata =\n%+v\nwant\n%+v", m, want)
+	}
+}
+
+func TestReadID3v24FrameUnsync(t *testing.T) {
+	payload := addUnsync(encodeFixtureText(latin1, "ÿÿ"))
+	b := fixtureTag(4, 0, false, []fixtureFrame{{"TIT2", payload}})
+	// the frame's flags are the two bytes before its payload
+	b[id3v2HeaderLen+8], b[id3v2HeaderLen+9] = 0, id3v24FrameUnsync
+
+	tag, err := readID3v2Tag(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("readID3v2Tag: %s", err)
+	}
+	if got := tag.text("TIT2"); got != "ÿÿ" {
+		t.Errorf("TIT2 = %q, want %q", got, "ÿÿ")
+	}
+}
+
+func TestReadID3v24MultipleValues(t *testing.T) {
+	b := fixtureTag(4, 0, false, []fixtureFrame{{"TCON", encodeFixtureText(utf8, "Electronic", "17", "RX")}})
+	tag, err := readID3v2Tag(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("readID3v2Tag: %s", err)
+	}
+
+	m, _ := metadataFromID3v2(tag, nil)
+	if want := "Electronic; Rock; Remix"; m.Genre != want {
+		t.Errorf("genre = %q, want %q", m.Genre, want)
+	}
+}
+
+func TestReadID3v24ITunesFrameSizes(t *testing.T) {
+	// old iTunes wrote v2.4 frame sizes as plain integers, which only differs from synchsafe from 128 bytes up
+	long := strings.Repeat("x", 200)
+	b := fixtureTag(4, 0, false, []fixtureFrame{
+		{"TIT2", encodeFixtureText(latin1, long)},
+		{"TPE1", encodeFixtureText(latin1, "Artist")},
+	})
+	binary.BigEndian.PutUint32(b[id3v2HeaderLen+4:], uint32(1+len(long)))
+
+	tag, err := readID3v2Tag(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("readID3v2Tag: %s", err)
+	}
+	if got := tag.text("TIT2"); got != long {
+		t.Errorf("TIT2 = %q, want %q", got, long)
+	}
+	if got := tag.text("TPE1"); got != "Artist" {
+		t.Errorf("TPE1 = %q, want %q", got, "Artist")
+	}
+}
+
+func TestReadID3v2DamagedFrame(t *testing.T) {
+	b := fixtureTag(3, 0, false, []fixtureFrame{
+		{"TIT2", encodeFixtureText(latin1, "Title")},
+		{"TPE1", encodeFixtureText(latin1, "Artist")},
+	})
+	// break the second frame's ID
+	copy(b[id3v2HeaderLen+10+6:], "tp!1")
+
+	tag, err := readID3v2Tag(bytes.NewReader(b))
+	if err == nil {
+		t.Fatal("readID3v2Tag didn't report the damaged frame")
+	}
+	if tag == nil || tag.text("TIT2") != "Title" {
+		t.Errorf("the frame before the damage wasn't kept")
+	}
+}
+
+func TestReadID3v2Errata(t *testing.T) {
+	tests := []struct {
+		frames []fixtureFrame
+		errata []string
+	}{
+		{
+			frames: []fixtureFrame{{"TIT2", encodeFixtureText(latin1, "Title")}},
+			errata: []string{"no track number (TRCK) in tags"},
+		},
+		{
+			frames: []fixtureFrame{
+				{"TRCK", encodeFixtureText(latin1, "three")},
+				{"TYER", encodeFixtureText(latin1, "98")},
+				{"TBPM", encodeFixtureText(latin1, "fast")},
+			},
+			errata: []string{"TRCK", "TYER", "TBPM"},
+		},
+	}
+
+	for _, tt := range tests {
+		tag, err := readID3v2Tag(bytes.NewReader(fixtureTag(3, 0, false, tt.frames)))
+		if err != nil {
+			t.Fatalf("readID3v2Tag: %s", err)
+		}
+
+		_, errata := metadataFromID3v2(tag, nil)
+		if len(errata) != len(tt.errata) {
+			t.Errorf("errata = %q, want one for each of %q", errata, tt.errata)
+			continue
+		}
+		for i := range errata {
+			if !strings.Contains(errata[i], tt.errata[i]) {
+				t.Errorf("erratum %q doesn't mention %s", errata[i], tt.errata[i])
+			}
+		}
+	}
+}
+
+func TestReadID3v2NoTag(t *testing.T) {
+	for _, b := range [][]byte{nil, []byte("ID"), []byte("\xff\xfb\x90\x00 not a tag")} {
+		tag, err := readID3v2Tag(bytes.NewReader(b))
+		if tag != nil || err != nil {
+			t.Errorf("readID3v2Tag(%q) = %v, %v; want nil, nil", b, tag, err)
+		}
+	}
+}
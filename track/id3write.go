@@ -0,0 +1,300 @@
+package track
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3v2DefaultPadding is the padding given to a tag that has outgrown the space it had, so that the next small edit
+// by any tagger can be made in place.
+const id3v2DefaultPadding = 1024
+
+// id3v2MaxSize is the largest tag size that fits in the header's 28-bit synchsafe integer.
+const id3v2MaxSize = 1<<28 - 1
+
+// newID3v2Tag returns an empty tag for a file that doesn't have one. We write v2.3 rather than v2.4 because it is
+// still far more widely supported by players.
+func newID3v2Tag() *id3v2Tag {
+	return &id3v2Tag{version: 3}
+}
+
+// upgradeToV23 converts a v2.2 tag to v2.3 in place, since v2.2 can't hold several of the frames we write.
+// Frames without a v2.3 equivalent are dropped.
+func (t *id3v2Tag) upgradeToV23() {
+	if t.version != 2 {
+		return
+	}
+
+	var frames []*id3v2Frame
+	for _, f := range t.frames {
+		id := id3v22FrameIDs[f.id]
+		if id == "" {
+			continue
+		}
+
+		data := f.data
+		if id == "APIC" {
+			var ok bool
+			if data, ok = convertPIC(data); !ok {
+				continue
+			}
+		}
+
+		frames = append(frames, &id3v2Frame{id: id, data: data})
+	}
+
+	t.version, t.revision, t.frames = 3, 0, frames
+}
+
+// convertPIC converts a v2.2 PIC frame, which gives the image format as three characters, to an APIC frame,
+// which uses a MIME type.
+func convertPIC(data []byte) ([]byte, bool) {
+	// encoding, image format, picture type
+	if len(data) < 5 {
+		return nil, false
+	}
+
+	mime := "image/" + strings.ToLower(string(data[1:4]))
+	if mime == "image/jpg" {
+		mime = "image/jpeg"
+	}
+
+	converted := []byte{data[0]}
+	converted = append(converted, mime...)
+	converted = append(converted, 0)
+	return append(converted, data[4:]...), true
+}
+
+// setText replaces the text frame with the given ID, keeping its place in the tag. An empty value removes it.
+func (t *id3v2Tag) setText(id, value string) {
+	if value == "" {
+		t.replaceFrames(id, nil)
+		return
+	}
+
+	t.replaceFrames(id, nil, &id3v2Frame{id: id, data: t.encodeText(value)})
+}
+
+// setComment replaces the comment without a description, leaving any described ones alone.
+func (t *id3v2Tag) setComment(value string) {
+	isPlainComment := func(f *id3v2Frame) bool {
+		data, err := f.content(t.version)
+		if err != nil || len(data) < 4 {
+			return false
+		}
+		values := decodeTextValues(data[0], data[4:])
+		return len(values) >= 1 && values[0] == ""
+	}
+
+	if value == "" {
+		t.replaceFrames("COMM", isPlainComment)
+		return
+	}
+
+	// encoding, language, empty description, text
+	text := t.encodeText("", value)
+	data := append([]byte{text[0]}, "eng"...)
+	data = append(data, text[1:]...)
+
+	t.replaceFrames("COMM", isPlainComment, &id3v2Frame{id: "COMM", data: data})
+}
+
+// setPictures replaces the APIC frames of a picture type with frames for the given pictures, which can be none,
+// leaving pictures of other types alone.
+func (t *id3v2Tag) setPictures(pictureType int, pictures []Picture) {
+	sameType := func(f *id3v2Frame) bool {
+		data, err := f.content(t.version)
+		if err != nil {
+			return false
+		}
+		existing, ok := parseAPIC(data)
+		return ok && existing.Type == pictureType
+	}
+
+	var frames []*id3v2Frame
+	for _, p := range pictures {
+		// encoding, MIME type, picture type, description, image
+		text := t.encodeText(p.Description)
+		data := append([]byte{text[0]}, encodeLatin1(p.MIME)...)
+		data = append(data, 0, byte(pictureType))
+		data = append(data, text[1:]...)
+		if text[0] == id3EncodingUTF16 {
+			data = append(data, 0, 0)
+		} else {
+			data = append(data, 0)
+		}
+		data = append(data, p.Data...)
+		frames = append(frames, &id3v2Frame{id: "APIC", data: data})
+	}
+
+	t.replaceFrames("APIC", sameType, frames...)
+}
+
+// replaceFrames removes every frame with the given ID that match reports true for (or all of them, if match is nil),
+// putting the replacements in the place of the first one. Without any to replace, the replacements go at the end.
+func (t *id3v2Tag) replaceFrames(id string, match func(f *id3v2Frame) bool, replacements ...*id3v2Frame) {
+	var frames []*id3v2Frame
+	replaced := false
+
+	for _, f := range t.frames {
+		if f.id != id || (match != nil && !match(f)) {
+			frames = append(frames, f)
+			continue
+		}
+		if !replaced {
+			frames = append(frames, replacements...)
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		frames = append(frames, replacements...)
+	}
+
+	t.frames = frames
+}
+
+// encodeText encodes an encoding byte followed by null-separated values, choosing the most compact encoding the
+// tag's version allows: ISO-8859-1 when possible, otherwise UTF-8 for v2.4 and UTF-16 for v2.3.
+func (t *id3v2Tag) encodeText(values ...string) []byte {
+	encoding := byte(id3EncodingISO88591)
+	for _, v := range values {
+		if !isLatin1(v) && t.version >= 4 {
+			encoding = id3EncodingUTF8
+		} else if !isLatin1(v) {
+			encoding = id3EncodingUTF16
+		}
+	}
+
+	b := []byte{encoding}
+	for i, v := range values {
+		if i > 0 && encoding == id3EncodingUTF16 {
+			b = append(b, 0, 0)
+		} else if i > 0 {
+			b = append(b, 0)
+		}
+
+		switch encoding {
+		case id3EncodingUTF8:
+			b = append(b, v...)
+		case id3EncodingUTF16:
+			b = append(b, 0xff, 0xfe)
+			for _, u := range utf16.Encode([]rune(v)) {
+				b = append(b, byte(u), byte(u>>8))
+			}
+		default:
+			b = append(b, encodeLatin1(v)...)
+		}
+	}
+
+	return b
+}
+
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeLatin1 encodes s as ISO-8859-1, replacing anything outside it with a question mark.
+func encodeLatin1(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// encode serialises the tag, padding it out to at least minSize bytes so a tag that has room to spare keeps it.
+//
+// The tag is always written without unsynchronisation, an extended header or a footer. Frames are written with the
+// flags and data they were read with, so frame-level compression and unsynchronisation in v2.4 survive untouched.
+func (t *id3v2Tag) encode(minSize int64) ([]byte, error) {
+	var body bytes.Buffer
+
+	for _, f := range t.frames {
+		body.WriteString(f.id)
+
+		var size [4]byte
+		if t.version >= 4 {
+			if len(f.data) > id3v2MaxSize {
+				return nil, fmt.Errorf("frame %s is too large", f.id)
+			}
+			putSynchsafe(size[:], uint32(len(f.data)))
+		} else {
+			binary.BigEndian.PutUint32(size[:], uint32(len(f.data)))
+		}
+		body.Write(size[:])
+
+		var flags [2]byte
+		binary.BigEndian.PutUint16(flags[:], f.flags)
+		body.Write(flags[:])
+
+		body.Write(f.data)
+	}
+
+	size := int64(id3v2HeaderLen + body.Len())
+	padding := int64(id3v2DefaultPadding)
+	if size < minSize {
+		padding = minSize - size
+	}
+	size += padding
+
+	if size-id3v2HeaderLen > id3v2MaxSize {
+		return nil, fmt.Errorf("the tag is too large")
+	}
+
+	header := []byte{'I', 'D', '3', t.version, 0, 0, 0, 0, 0, 0}
+	putSynchsafe(header[6:], uint32(size-id3v2HeaderLen))
+
+	b := make([]byte, 0, size)
+	b = append(b, header...)
+	b = append(b, body.Bytes()...)
+	return append(b, make([]byte, padding)...), nil
+}
+
+func putSynchsafe(b []byte, n uint32) {
+	b[0] = byte(n>>21) & 0x7f
+	b[1] = byte(n>>14) & 0x7f
+	b[2] = byte(n>>7) & 0x7f
+	b[3] = byte(n) & 0x7f
+}
+
+// encode serialises an ID3v1 tag, writing a v1.1 tag if it has a track number. Text that doesn't fit is truncated.
+func (t *id3v1Tag) encode() []byte {
+	b := make([]byte, id3v1Len)
+	copy(b, "TAG")
+	copy(b[3:33], encodeLatin1(t.title))
+	copy(b[33:63], encodeLatin1(t.artist))
+	copy(b[63:93], encodeLatin1(t.album))
+	copy(b[93:97], encodeLatin1(t.year))
+
+	if t.track > 0 && t.track <= 0xff {
+		copy(b[97:125], encodeLatin1(t.comment))
+		b[126] = byte(t.track)
+	} else {
+		copy(b[97:127], encodeLatin1(t.comment))
+	}
+
+	b[127] = t.genre
+	return b
+}
+
+// genreNumber returns the ID3v1 number of a genre, or 255 (no genre) if it doesn't have one.
+func genreNumber(name string) byte {
+	for i, g := range id3v1Genres {
+		if equalFold(g, name) {
+			return byte(i)
+		}
+	}
+	return 0xff
+}
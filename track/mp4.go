@@ -0,0 +1,477 @@
+package track
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// mp4MaxMoovLen limits how much we'll read for the moov atom, which holds all of a file's metadata including its
+// cover art. Anything past this is far more likely to be a broken file.
+const mp4MaxMoovLen = 64 << 20
+
+// Type indicators of the data atoms in an ilst item.
+const (
+	mp4DataUTF8  = 1
+	mp4DataUTF16 = 2
+	mp4DataJPEG  = 13
+	mp4DataPNG   = 14
+	mp4DataBMP   = 27
+)
+
+// mp4Atom is an atom (or "box") of an MP4 file: its four-character type and its content without the header.
+type mp4Atom struct {
+	kind string
+	data []byte
+}
+
+// mp4Data is the value of a data atom in an ilst item.
+type mp4Data struct {
+	dataType uint32
+	value    []byte
+}
+
+// mp4Metadata is everything vir reads from the moov atom of an MP4 file, plus the length of the audio data in its
+// mdat atoms. codec is the type of the first sample description of the sound track, e.g. "mp4a" or "alac".
+//
+// items maps the type of each ilst item to its values; freeform "----" items are keyed as "----:mean:name", e.g.
+// "----:com.apple.iTunes:ISRC". items is nil if the file has no ilst atom.
+type mp4Metadata struct {
+	timescale  int64
+	duration   int64
+	sampleRate int
+	channels   int
+	codec      string
+	audioLen   int64
+	items      map[string][]mp4Data
+	pictures   []Picture
+}
+
+// readMP4 finds the moov atom of an MP4 file, wherever it is among the top-level atoms, and reads the parts of it
+// vir has a use for.
+func readMP4(r io.ReaderAt, size int64) (*mp4Metadata, error) {
+	var moovOffset, moovLen, mdatLen int64
+	found := false
+
+	offset := int64(0)
+	for offset < size {
+		header := make([]byte, 16)
+		n, err := r.ReadAt(header, offset)
+		if n < 8 {
+			return nil, fmt.Errorf("truncated MP4 atom header: %s", err)
+		}
+
+		kind := string(header[4:8])
+		headerLen := int64(8)
+		atomLen := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch atomLen {
+		case 0:
+			// the atom runs to the end of the file
+			atomLen = size - offset
+		case 1:
+			if n < 16 {
+				return nil, fmt.Errorf("truncated MP4 atom header: %s", err)
+			}
+			headerLen = 16
+			atomLen = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if atomLen < headerLen || offset+atomLen > size {
+			return nil, fmt.Errorf("MP4 %q atom runs past the end of the file", kind)
+		}
+
+		switch kind {
+		case "moov":
+			if !found {
+				moovOffset, moovLen, found = offset+headerLen, atomLen-headerLen, true
+			}
+		case "mdat":
+			mdatLen += atomLen - headerLen
+		}
+
+		offset += atomLen
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no moov atom in MP4 file")
+	}
+	if moovLen > mp4MaxMoovLen {
+		return nil, fmt.Errorf("MP4 moov atom is too large")
+	}
+
+	moov := make([]byte, moovLen)
+	if _, err := r.ReadAt(moov, moovOffset); err != nil {
+		return nil, err
+	}
+
+	mm, err := parseMP4Moov(moov)
+	if err != nil {
+		return nil, err
+	}
+	mm.audioLen = mdatLen
+	return mm, nil
+}
+
+// parseMP4Atoms splits the content of an atom into its child atoms.
+func parseMP4Atoms(b []byte) ([]mp4Atom, error) {
+	var atoms []mp4Atom
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, fmt.Errorf("truncated MP4 atom header")
+		}
+
+		kind := string(b[4:8])
+		headerLen := uint64(8)
+		atomLen := uint64(binary.BigEndian.Uint32(b[0:4]))
+		switch atomLen {
+		case 0:
+			atomLen = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, fmt.Errorf("truncated MP4 atom header")
+			}
+			headerLen = 16
+			atomLen = binary.BigEndian.Uint64(b[8:16])
+		}
+		if atomLen < headerLen || atomLen > uint64(len(b)) {
+			return nil, fmt.Errorf("MP4 %q atom runs past the end of its parent", kind)
+		}
+
+		atoms = append(atoms, mp4Atom{kind: kind, data: b[headerLen:atomLen]})
+		b = b[atomLen:]
+	}
+	return atoms, nil
+}
+
+// mp4Child returns the content of the first child of the given type, or nil if there isn't one.
+func mp4Child(atoms []mp4Atom, kind string) []byte {
+	for _, a := range atoms {
+		if a.kind == kind {
+			return a.data
+		}
+	}
+	return nil
+}
+
+// mp4Path follows a path of atom types down from b, returning the content of the last one, or nil if any of them
+// is missing.
+func mp4Path(b []byte, kinds ...string) ([]byte, error) {
+	for _, kind := range kinds {
+		atoms, err := parseMP4Atoms(b)
+		if err != nil {
+			return nil, err
+		}
+		if b = mp4Child(atoms, kind); b == nil {
+			return nil, nil
+		}
+	}
+	return b, nil
+}
+
+func parseMP4Moov(moov []byte) (*mp4Metadata, error) {
+	atoms, err := parseMP4Atoms(moov)
+	if err != nil {
+		return nil, err
+	}
+
+	mm := &mp4Metadata{}
+
+	mvhd := mp4Child(atoms, "mvhd")
+	if mvhd == nil {
+		return nil, fmt.Errorf("no mvhd atom in MP4 file")
+	}
+	if err := mm.parseMovieHeader(mvhd); err != nil {
+		return nil, err
+	}
+
+	for _, a := range atoms {
+		if a.kind != "trak" {
+			continue
+		}
+		found, err := mm.parseAudioTrack(a.data)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			break
+		}
+	}
+
+	meta, err := mp4Path(moov, "udta", "meta")
+	if err != nil || meta == nil {
+		return mm, err
+	}
+
+	// meta is a full atom with a version and flags before its children, except in files written by some
+	// QuickTime-based taggers, where its hdlr child comes straight away
+	if len(meta) >= 4 && (len(meta) < 8 || string(meta[4:8]) != "hdlr") {
+		meta = meta[4:]
+	}
+
+	ilst, err := mp4Path(meta, "ilst")
+	if err != nil || ilst == nil {
+		return mm, err
+	}
+
+	return mm, mm.parseItems(ilst)
+}
+
+// parseMovieHeader reads the timescale and duration from the mvhd atom.
+func (mm *mp4Metadata) parseMovieHeader(mvhd []byte) error {
+	if len(mvhd) < 20 {
+		return fmt.Errorf("truncated MP4 mvhd atom")
+	}
+
+	// after the version and flags come the creation and modification times, then the timescale and duration, all
+	// of which are 64-bit instead of 32-bit in version 1 apart from the timescale
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return fmt.Errorf("truncated MP4 mvhd atom")
+		}
+		mm.timescale = int64(binary.BigEndian.Uint32(mvhd[20:24]))
+		mm.duration = int64(binary.BigEndian.Uint64(mvhd[24:32]))
+	} else {
+		mm.timescale = int64(binary.BigEndian.Uint32(mvhd[12:16]))
+		mm.duration = int64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+
+	return nil
+}
+
+// parseAudioTrack reads the sample rate and channels from the sample description of a trak atom, if it's a sound
+// track. It reports whether it was.
+func (mm *mp4Metadata) parseAudioTrack(trak []byte) (bool, error) {
+	hdlr, err := mp4Path(trak, "mdia", "hdlr")
+	if err != nil || len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+		return false, err
+	}
+
+	stsd, err := mp4Path(trak, "mdia", "minf", "stbl", "stsd")
+	if err != nil || len(stsd) < 8 {
+		return false, err
+	}
+
+	// version, flags and entry count, then the entries themselves
+	entries, err := parseMP4Atoms(stsd[8:])
+	if err != nil || len(entries) == 0 {
+		return false, err
+	}
+
+	// reserved bytes, data reference index and reserved version, revision and vendor, then channels, sample size,
+	// compression ID, packet size and the sample rate as 16.16 fixed point
+	entry := entries[0].data
+	if len(entry) < 28 {
+		return false, fmt.Errorf("truncated MP4 %q sample description", entries[0].kind)
+	}
+	mm.codec = entries[0].kind
+	mm.channels = int(binary.BigEndian.Uint16(entry[16:18]))
+	mm.sampleRate = int(binary.BigEndian.Uint16(entry[24:26]))
+
+	return true, nil
+}
+
+// parseItems reads the items of the ilst atom, and the cover art among them.
+func (mm *mp4Metadata) parseItems(ilst []byte) error {
+	items, err := parseMP4Atoms(ilst)
+	if err != nil {
+		return err
+	}
+
+	mm.items = make(map[string][]mp4Data)
+	for _, item := range items {
+		children, err := parseMP4Atoms(item.data)
+		if err != nil {
+			return fmt.Errorf("MP4 %q item: %s", item.kind, err)
+		}
+
+		key := item.kind
+		if key == "----" {
+			key = "----:" + mp4FreeformString(mp4Child(children, "mean")) + ":" +
+				mp4FreeformString(mp4Child(children, "name"))
+		}
+
+		for _, c := range children {
+			if c.kind != "data" {
+				continue
+			}
+			// a type indicator whose first byte is always 0, then a locale that nobody sets
+			if len(c.data) < 8 {
+				return fmt.Errorf("truncated MP4 %q item", item.kind)
+			}
+			mm.items[key] = append(mm.items[key], mp4Data{
+				dataType: binary.BigEndian.Uint32(c.data[0:4]) & 0xffffff,
+				value:    c.data[8:],
+			})
+		}
+	}
+
+	for _, d := range mm.items["covr"] {
+		mime := ""
+		switch d.dataType {
+		case mp4DataJPEG:
+			mime = "image/jpeg"
+		case mp4DataPNG:
+			mime = "image/png"
+		case mp4DataBMP:
+			mime = "image/bmp"
+		}
+		// covr doesn't say what the picture is of, but it's always treated as the front cover
+		mm.pictures = append(mm.pictures, Picture{Type: PictureFrontCover, MIME: mime, Data: d.value})
+	}
+
+	return nil
+}
+
+// mp4FreeformString reads the content of the mean or name atom of a freeform item, which have a version and flags
+// before the string.
+func mp4FreeformString(b []byte) string {
+	if len(b) < 4 {
+		return ""
+	}
+	return string(b[4:])
+}
+
+// text returns the text values of an item, joined with semicolons if there are several.
+func (mm *mp4Metadata) text(key string) string {
+	var values []string
+	for _, d := range mm.items[key] {
+		switch d.dataType {
+		case mp4DataUTF8:
+			values = append(values, clean(string(d.value)))
+		case mp4DataUTF16:
+			u := make([]uint16, len(d.value)/2)
+			for i := range u {
+				u[i] = binary.BigEndian.Uint16(d.value[i*2:])
+			}
+			values = append(values, clean(string(utf16.Decode(u))))
+		}
+	}
+	return strings.Join(values, "; ")
+}
+
+// value returns the first value of an item, or nil if the file doesn't have it.
+func (mm *mp4Metadata) value(key string) []byte {
+	if values := mm.items[key]; len(values) > 0 {
+		return values[0].value
+	}
+	return nil
+}
+
+// integer returns an item stored as a big-endian integer of any width up to 8 bytes.
+func (mm *mp4Metadata) integer(key string) (int64, bool) {
+	b := mm.value(key)
+	if len(b) == 0 || len(b) > 8 {
+		return 0, false
+	}
+
+	var n int64
+	for _, c := range b {
+		n = n<<8 | int64(c)
+	}
+	return n, true
+}
+
+// readMP4Metadata reads the tags of an MP4 file from its iTunes-style ilst atom, its duration from mvhd and the rest
+// of its stream info from the sample description of its sound track.
+func readMP4Metadata(r io.ReaderAt, size int64, tr *Track) error {
+	mm, err := readMP4(r, size)
+	if err != nil {
+		return err
+	}
+
+	tr.Stream = StreamInfo{
+		Duration:   samplesDuration(mm.duration, int(mm.timescale)),
+		SampleRate: mm.sampleRate,
+		Channels:   mm.channels,
+		Lossless:   mm.codec == "alac",
+	}
+	tr.Stream.Bitrate = averageBitrate(mm.audioLen, tr.Stream.Duration)
+	tr.pictures = mm.pictures
+
+	if mm.items == nil {
+		tr.Number = -1
+		tr.Errata = append(tr.Errata, "no track number (trkn) in tags")
+		return nil
+	}
+
+	tr.Stream.Encoder = mm.text("\xa9too")
+	tr.TagVersion = TagMP4
+	tr.Metadata, tr.Errata = metadataFromMP4(mm, tr.Errata)
+	return nil
+}
+
+// metadataFromMP4 maps the items written by iTunes and other taggers onto Metadata. MusicBrainz IDs and the ISRC
+// come from the freeform items Picard writes.
+func metadataFromMP4(mm *mp4Metadata, errata []string) (Metadata, []string) {
+	m := Metadata{
+		Title:       mm.text("\xa9nam"),
+		Artist:      mm.text("\xa9ART"),
+		Album:       mm.text("\xa9alb"),
+		AlbumArtist: mm.text("aART"),
+		Genre:       mm.text("\xa9gen"),
+		Composer:    mm.text("\xa9wrt"),
+		Comment:     mm.text("\xa9cmt"),
+		ISRC:        mm.text("----:com.apple.iTunes:ISRC"),
+		MusicBrainz: MusicBrainzIDs{
+			RecordingID:    mm.text("----:com.apple.iTunes:MusicBrainz Track Id"),
+			TrackID:        mm.text("----:com.apple.iTunes:MusicBrainz Release Track Id"),
+			AlbumID:        mm.text("----:com.apple.iTunes:MusicBrainz Album Id"),
+			ArtistID:       mm.text("----:com.apple.iTunes:MusicBrainz Artist Id"),
+			AlbumArtistID:  mm.text("----:com.apple.iTunes:MusicBrainz Album Artist Id"),
+			ReleaseGroupID: mm.text("----:com.apple.iTunes:MusicBrainz Release Group Id"),
+		},
+	}
+
+	// iTunes writes standard genres as the ID3v1 genre number plus one instead
+	if m.Genre == "" {
+		if n, ok := mm.integer("gnre"); ok {
+			m.Genre = genreName(int(n) - 1)
+		}
+	}
+
+	var err error
+	if trkn := mm.value("trkn"); trkn == nil {
+		m.Number = -1
+		errata = append(errata, "no track number (trkn) in tags")
+	} else if m.Number, m.TrackTotal, err = parseMP4NumberPair("track number", "trkn", trkn); err != nil {
+		errata = append(errata, err.Error())
+	}
+
+	if disk := mm.value("disk"); disk != nil {
+		if m.DiscNumber, m.DiscTotal, err = parseMP4NumberPair("disc number", "disk", disk); err != nil {
+			errata = append(errata, err.Error())
+			m.DiscNumber = 0
+		}
+	}
+
+	if date := mm.text("\xa9day"); date != "" {
+		m.Date = date
+		if m.Year, err = parseYear("©day", date); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if tmpo, ok := mm.integer("tmpo"); ok {
+		m.BPM = int(tmpo)
+	} else if b := mm.value("tmpo"); b != nil {
+		errata = append(errata, errInvalidValue("BPM", "tmpo", fmt.Sprintf("% x", b)).Error())
+	}
+
+	if cpil, ok := mm.integer("cpil"); ok {
+		m.Compilation = cpil != 0
+	} else if b := mm.value("cpil"); b != nil {
+		errata = append(errata, errInvalidValue("compilation flag", "cpil", fmt.Sprintf("% x", b)).Error())
+	}
+
+	return m, errata
+}
+
+// parseMP4NumberPair parses the binary value of a trkn or disk item: two reserved bytes, then the number and the
+// total as 16-bit integers.
+func parseMP4NumberPair(field, atom string, b []byte) (int, int, error) {
+	if len(b) < 6 {
+		return -1, 0, errInvalidValue(field, atom, fmt.Sprintf("% x", b))
+	}
+	return int(binary.BigEndian.Uint16(b[2:4])), int(binary.BigEndian.Uint16(b[4:6])), nil
+}
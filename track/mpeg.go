@@ -0,0 +1,420 @@
+package track
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MPEG audio versions, as encoded in bits 19-20 of a frame header.
+const (
+	mpegVersion25 = 0
+	mpegVersion2  = 2
+	mpegVersion1  = 3
+)
+
+// MPEG audio layers, as encoded in bits 17-18 of a frame header.
+const (
+	mpegLayer3 = 1
+	mpegLayer2 = 2
+	mpegLayer1 = 3
+)
+
+// mpegBitrates are the bitrates in kbps, indexed by [version is 1][layer][bitrate index].
+var mpegBitrates = [2][4][16]int{
+	// MPEG 2 and 2.5
+	{
+		{},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},
+	},
+	// MPEG 1
+	{
+		{},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1},
+	},
+}
+
+// mpegSampleRates are the sample rates in Hz, indexed by [version][sample rate index].
+var mpegSampleRates = [4][3]int{
+	mpegVersion25: {11025, 12000, 8000},
+	mpegVersion2:  {22050, 24000, 16000},
+	mpegVersion1:  {44100, 48000, 32000},
+}
+
+// mpegFrameHeader is the decoded 4-byte header at the start of every MPEG audio frame.
+type mpegFrameHeader struct {
+	version    int
+	layer      int
+	protected  bool // the header is followed by a CRC-16
+	bitrate    int  // kbps; 0 means "free format", which we don't support
+	sampleRate int
+	padding    bool
+	channels   int
+	frameLen   int
+}
+
+// samplesPerFrame returns how many samples each channel of a frame decodes to.
+func (h mpegFrameHeader) samplesPerFrame() int {
+	switch {
+	case h.layer == mpegLayer1:
+		return 384
+	case h.layer == mpegLayer3 && h.version != mpegVersion1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// sideInfoLen returns the length of the side information that follows the header (and CRC) of a layer III frame.
+func (h mpegFrameHeader) sideInfoLen() int {
+	switch {
+	case h.version == mpegVersion1 && h.channels == 1:
+		return 17
+	case h.version == mpegVersion1:
+		return 32
+	case h.channels == 1:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// parseMPEGFrameHeader decodes a frame header, reporting false if b doesn't start with a valid one.
+func parseMPEGFrameHeader(b []byte) (mpegFrameHeader, bool) {
+	if len(b) < 4 || !isMPEGFrameSync(b) {
+		return mpegFrameHeader{}, false
+	}
+
+	h := mpegFrameHeader{
+		version:   int(b[1]>>3) & 0x3,
+		layer:     int(b[1]>>1) & 0x3,
+		protected: b[1]&0x1 == 0,
+		padding:   b[2]&0x2 != 0,
+		channels:  2,
+	}
+
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int(b[2]>>2) & 0x3
+	if h.version == 1 || h.layer == 0 || sampleRateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+		return mpegFrameHeader{}, false
+	}
+
+	isV1 := 0
+	if h.version == mpegVersion1 {
+		isV1 = 1
+	}
+	h.bitrate = mpegBitrates[isV1][h.layer][bitrateIndex]
+	h.sampleRate = mpegSampleRates[h.version][sampleRateIndex]
+
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+
+	pad := 0
+	if h.padding {
+		pad = 1
+	}
+
+	switch {
+	case h.layer == mpegLayer1:
+		h.frameLen = (12*h.bitrate*1000/h.sampleRate + pad) * 4
+	case h.layer == mpegLayer3 && h.version != mpegVersion1:
+		h.frameLen = 72*h.bitrate*1000/h.sampleRate + pad
+	default:
+		h.frameLen = 144*h.bitrate*1000/h.sampleRate + pad
+	}
+
+	return h, true
+}
+
+// id3v2Size returns the total size of the ID3v2 tag at the start of b, including its header and any footer,
+// or 0 if b doesn't start with one.
+func id3v2Size(b []byte) int64 {
+	if len(b) < 10 || string(b[:3]) != "ID3" {
+		return 0
+	}
+
+	size := int64(b[6]&0x7f)<<21 | int64(b[7]&0x7f)<<14 | int64(b[8]&0x7f)<<7 | int64(b[9]&0x7f)
+	size += 10
+	if b[5]&0x10 != 0 {
+		// footer present
+		size += 10
+	}
+
+	return size
+}
+
+// mp3AudioRegion returns the byte range of an MP3 file that holds the audio, leaving out the ID3v2 tag at the
+// start and the ID3v1, APEv2 and Lyrics3v2 tags at the end.
+func mp3AudioRegion(f io.ReaderAt, fileSize int64) (int64, int64, error) {
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+
+	start := id3v2Size(header)
+	end := fileSize
+
+	if end-start >= 128 {
+		trailer := make([]byte, 3)
+		if _, err := f.ReadAt(trailer, end-128); err != nil {
+			return 0, 0, err
+		}
+		if string(trailer) == "TAG" {
+			end -= 128
+		}
+	}
+
+	if start > end {
+		start = end
+	}
+
+	end, err := trimTrailingTags(f, start, end)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// trimTrailingTags moves the end of an MP3 file's audio back past an APEv2 or Lyrics3v2 tag, which some taggers put
+// between the audio and the ID3v1 tag.
+func trimTrailingTags(r io.ReaderAt, start, end int64) (int64, error) {
+	for {
+		footer := make([]byte, 32)
+		if end-start < int64(len(footer)) {
+			return end, nil
+		}
+		if _, err := r.ReadAt(footer, end-32); err != nil {
+			return 0, err
+		}
+
+		switch {
+		case string(footer[:8]) == "APETAGEX":
+			// the size includes the footer but not the header, which the flags say whether there is
+			size := int64(footer[12]) | int64(footer[13])<<8 | int64(footer[14])<<16 | int64(footer[15])<<24
+			if footer[23]&0x80 != 0 {
+				size += 32
+			}
+			if size > end-start {
+				return end, nil
+			}
+			end -= size
+		case string(footer[23:32]) == "LYRICS200":
+			// the size is six digits before the marker, and leaves out the size and the marker
+			var size int64
+			if _, err := fmt.Sscanf(string(footer[17:23]), "%06d", &size); err != nil || size+15 > end-start {
+				return end, nil
+			}
+			end -= size + 15
+		default:
+			return end, nil
+		}
+	}
+}
+
+// mpegProbeLen is how far past the ID3v2 tag we look for the first frame before giving up.
+const mpegProbeLen = 64 * 1024
+
+// findFirstMPEGFrame finds the first frame header in buf that is followed by another valid frame header (or the end
+// of buf), so that stray sync bits in junk data aren't mistaken for audio.
+func findFirstMPEGFrame(buf []byte) (int, mpegFrameHeader, bool) {
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := parseMPEGFrameHeader(buf[i:])
+		if !ok || h.frameLen <= 0 {
+			continue
+		}
+
+		next := i + h.frameLen
+		if next+4 > len(buf) {
+			return i, h, true
+		}
+		if _, ok := parseMPEGFrameHeader(buf[next:]); ok {
+			return i, h, true
+		}
+	}
+
+	return 0, mpegFrameHeader{}, false
+}
+
+// mpegVBRHeader is the Xing, Info or VBRI header some encoders write in place of the first frame's audio, which
+// describes the whole stream. frames and bytes are 0 if the header leaves them out.
+//
+// encoderDelay and padding come from the LAME tag that follows a Xing or Info header, and are the number of samples
+// the encoder added at the start and end of the stream.
+type mpegVBRHeader struct {
+	frames       int64
+	bytes        int64
+	vbr          bool
+	encoder      string
+	encoderDelay int
+	padding      int
+}
+
+// Xing header flags, saying which of the optional fields follow.
+const (
+	xingFlagFrames  = 0x1
+	xingFlagBytes   = 0x2
+	xingFlagTOC     = 0x4
+	xingFlagQuality = 0x8
+)
+
+// parseXingHeader parses the Xing or Info header in the first frame of a stream, if there is one. LAME writes "Info"
+// for CBR streams and "Xing" otherwise.
+func parseXingHeader(frame []byte, h mpegFrameHeader) (mpegVBRHeader, bool) {
+	if h.layer != mpegLayer3 {
+		return mpegVBRHeader{}, false
+	}
+
+	offset := 4 + h.sideInfoLen()
+	if h.protected {
+		offset += 2
+	}
+	if len(frame) < offset+8 {
+		return mpegVBRHeader{}, false
+	}
+
+	b := frame[offset:]
+	magic := string(b[:4])
+	if magic != "Xing" && magic != "Info" {
+		return mpegVBRHeader{}, false
+	}
+
+	vh := mpegVBRHeader{vbr: magic == "Xing"}
+	flags := binary.BigEndian.Uint32(b[4:8])
+	b = b[8:]
+
+	if flags&xingFlagFrames != 0 && len(b) >= 4 {
+		vh.frames = int64(binary.BigEndian.Uint32(b))
+		b = b[4:]
+	}
+	if flags&xingFlagBytes != 0 && len(b) >= 4 {
+		vh.bytes = int64(binary.BigEndian.Uint32(b))
+		b = b[4:]
+	}
+	if flags&xingFlagTOC != 0 && len(b) >= 100 {
+		b = b[100:]
+	}
+	if flags&xingFlagQuality != 0 && len(b) >= 4 {
+		b = b[4:]
+	}
+
+	// the LAME tag starts with a 9 character encoder version, e.g. "LAME3.100"; ffmpeg writes the same structure
+	// with its own name in place of LAME's
+	if len(b) >= 24 && (bytes.HasPrefix(b, []byte("LAME")) || bytes.HasPrefix(b, []byte("Lavc"))) {
+		vh.encoder = string(bytes.TrimRight(b[:9], "\x00 "))
+		vh.encoderDelay = int(b[21])<<4 | int(b[22])>>4
+		vh.padding = int(b[22]&0x0f)<<8 | int(b[23])
+	}
+
+	return vh, true
+}
+
+// parseVBRIHeader parses the VBRI header that the Fraunhofer encoder writes at a fixed offset in the first frame.
+func parseVBRIHeader(frame []byte) (mpegVBRHeader, bool) {
+	const offset = 4 + 32
+	if len(frame) < offset+18 || string(frame[offset:offset+4]) != "VBRI" {
+		return mpegVBRHeader{}, false
+	}
+
+	// version, delay and quality, then the byte and frame counts
+	b := frame[offset:]
+	return mpegVBRHeader{
+		bytes:  int64(binary.BigEndian.Uint32(b[10:14])),
+		frames: int64(binary.BigEndian.Uint32(b[14:18])),
+		vbr:    true,
+	}, true
+}
+
+// readMPEGStream works out the properties of the audio stream of an MP3 file.
+//
+// The duration and bitrate are exact if the encoder wrote a Xing, Info or VBRI header with a frame count. Without
+// one, the stream is assumed to be CBR unless the frames at the start of it say otherwise, in which case the
+// bitrate and duration are estimated from those frames alone.
+func readMPEGStream(r io.ReaderAt, size int64) (StreamInfo, error) {
+	start, end, err := mp3AudioRegion(r, size)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+
+	probeLen := end - start
+	if probeLen > mpegProbeLen {
+		probeLen = mpegProbeLen
+	}
+
+	buf := make([]byte, probeLen)
+	if _, err := r.ReadAt(buf, start); err != nil && err != io.EOF {
+		return StreamInfo{}, err
+	}
+
+	offset, h, ok := findFirstMPEGFrame(buf)
+	if !ok {
+		return StreamInfo{}, fmt.Errorf("no MPEG audio frames found")
+	}
+
+	si := StreamInfo{SampleRate: h.sampleRate, Channels: h.channels}
+	audioLen := end - start - int64(offset)
+
+	vh, found := parseXingHeader(buf[offset:], h)
+	if !found {
+		vh, found = parseVBRIHeader(buf[offset:])
+	}
+
+	if found && vh.frames > 0 {
+		samples := vh.frames * int64(h.samplesPerFrame())
+		if trimmed := samples - int64(vh.encoderDelay+vh.padding); trimmed > 0 {
+			samples = trimmed
+		}
+		if vh.bytes > 0 {
+			audioLen = vh.bytes
+		}
+
+		si.Duration = samplesDuration(samples, h.sampleRate)
+		si.Bitrate = averageBitrate(audioLen, si.Duration)
+		si.VBR = vh.vbr
+		si.Encoder = vh.encoder
+		return si, nil
+	}
+
+	// no usable header, so go by the frames we have
+	frames, totalBitrate := 0, 0
+	for i := offset; i+4 <= len(buf); {
+		fh, ok := parseMPEGFrameHeader(buf[i:])
+		if !ok || fh.frameLen <= 0 {
+			break
+		}
+		if fh.bitrate != h.bitrate {
+			si.VBR = true
+		}
+		frames++
+		totalBitrate += fh.bitrate
+		i += fh.frameLen
+	}
+
+	si.Bitrate = totalBitrate / frames
+	si.Duration = time.Duration(float64(audioLen) * 8 / float64(si.Bitrate*1000) * float64(time.Second))
+	si.Encoder = vh.encoder
+	return si, nil
+}
+
+// readMP3Metadata reads the tags of an MP3 file and the properties of its audio stream. A stream that can't be read
+// doesn't stop the tags from loading.
+func readMP3Metadata(r io.ReaderAt, size int64, tr *Track) error {
+	if err := readID3Metadata(r, size, tr); err != nil {
+		return err
+	}
+
+	si, err := readMPEGStream(r, size)
+	if err != nil {
+		tr.Errata = append(tr.Errata, "can't read audio stream: "+err.Error())
+		return nil
+	}
+
+	tr.Stream = si
+	return nil
+}
@@ -0,0 +1,204 @@
+package track
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// oggPageHeaderLen is the length of the fixed part of an Ogg page header, before the segment table.
+const oggPageHeaderLen = 27
+
+// oggMaxHeaderPacketLen limits how much we'll read for a single header packet. Comment packets can be large when
+// they embed cover art, but anything past this is far more likely to be a broken file.
+const oggMaxHeaderPacketLen = 64 << 20
+
+// oggTailLen is how far from the end of the file we look for the last page, which is at most about 64KB long.
+const oggTailLen = 65307
+
+// Ogg page header flags.
+const (
+	oggFlagContinued = 0x01
+	oggFlagFirst     = 0x02
+)
+
+// oggPage is a single page of an Ogg stream.
+type oggPage struct {
+	flags    byte
+	granule  int64
+	serial   uint32
+	segments []byte
+	data     []byte
+}
+
+// readOggPage reads the next page from r.
+func readOggPage(r io.Reader) (*oggPage, error) {
+	header := make([]byte, oggPageHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return nil, fmt.Errorf("invalid Ogg page")
+	}
+
+	p := &oggPage{
+		flags:    header[5],
+		granule:  int64(binary.LittleEndian.Uint64(header[6:14])),
+		serial:   binary.LittleEndian.Uint32(header[14:18]),
+		segments: make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return nil, err
+	}
+
+	dataLen := 0
+	for _, s := range p.segments {
+		dataLen += int(s)
+	}
+	p.data = make([]byte, dataLen)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// readOggHeaderPackets reassembles the first n packets of the first logical stream in r, which for every codec we
+// care about are its identification and comment headers. Pages of any other streams multiplexed with it are skipped.
+// It also returns the offset of the end of the page the last of them finished on.
+func readOggHeaderPackets(r io.Reader, n int) (uint32, [][]byte, int64, error) {
+	br := bufio.NewReader(r)
+
+	first, err := readOggPage(br)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if first.flags&oggFlagFirst == 0 {
+		return 0, nil, 0, fmt.Errorf("the first Ogg page doesn't start a stream")
+	}
+
+	var packets [][]byte
+	var current []byte
+	var end int64
+	page := first
+
+	for {
+		end += int64(oggPageHeaderLen + len(page.segments) + len(page.data))
+		if page.serial == first.serial {
+			offset := 0
+			for _, s := range page.segments {
+				current = append(current, page.data[offset:offset+int(s)]...)
+				offset += int(s)
+
+				if len(current) > oggMaxHeaderPacketLen {
+					return 0, nil, 0, fmt.Errorf("Ogg header packet is too large")
+				}
+
+				// a segment shorter than 255 bytes ends the packet
+				if s < 255 {
+					packets = append(packets, current)
+					current = nil
+					if len(packets) == n {
+						return first.serial, packets, end, nil
+					}
+				}
+			}
+		}
+
+		page, err = readOggPage(br)
+		if err == io.EOF {
+			return 0, nil, 0, fmt.Errorf("the Ogg stream ends before its headers do")
+		} else if err != nil {
+			return 0, nil, 0, err
+		}
+	}
+}
+
+// lastOggGranule finds the granule position of the last page of the given stream that has one, which is the
+// number of samples in the whole stream (plus the pre-skip, for Opus).
+func lastOggGranule(r io.ReaderAt, size int64, serial uint32) (int64, error) {
+	start := size - oggTailLen
+	if start < 0 {
+		start = 0
+	}
+
+	tail := make([]byte, size-start)
+	if _, err := r.ReadAt(tail, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+oggPageHeaderLen > len(tail) || tail[i+4] != 0 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(tail[i+14:i+18]) != serial {
+			continue
+		}
+		// -1 means no packet finishes on this page
+		if granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14])); granule >= 0 {
+			return granule, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no final Ogg page found")
+}
+
+// readOggMetadata reads the tags of an Ogg Vorbis or Opus file from its comment header, and works out its duration
+// from the granule position of its last page.
+func readOggMetadata(r io.ReaderAt, size int64, tr *Track) error {
+	serial, packets, headersEnd, err := readOggHeaderPackets(io.NewSectionReader(r, 0, size), 2)
+	if err != nil {
+		return err
+	}
+	ident, comment := packets[0], packets[1]
+
+	var preSkip int64
+	switch {
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 28:
+		// version, then channels, sample rate and the maximum, nominal and minimum bitrates, which are all the same
+		// for a CBR stream and mostly left unset otherwise
+		tr.Stream.Channels = int(ident[11])
+		tr.Stream.SampleRate = int(binary.LittleEndian.Uint32(ident[12:16]))
+		maxBitrate := int32(binary.LittleEndian.Uint32(ident[16:20]))
+		minBitrate := int32(binary.LittleEndian.Uint32(ident[24:28]))
+		tr.Stream.VBR = maxBitrate <= 0 || maxBitrate != minBitrate
+		if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			return fmt.Errorf("missing Vorbis comment header")
+		}
+		comment = comment[7:]
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 19:
+		// Opus always decodes at 48kHz, whatever rate it was encoded from
+		tr.Stream.Channels = int(ident[9])
+		tr.Stream.SampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		if !bytes.HasPrefix(comment, []byte("OpusTags")) {
+			return fmt.Errorf("missing OpusTags header")
+		}
+		comment = comment[8:]
+	default:
+		tr.Number = -1
+		tr.Errata = append(tr.Errata, "can't read tags from Ogg streams that aren't Vorbis or Opus")
+		return nil
+	}
+
+	// a missing or broken last page shouldn't stop us reading the tags
+	if granule, err := lastOggGranule(r, size, serial); err == nil {
+		tr.Stream.Duration = samplesDuration(granule-preSkip, tr.Stream.SampleRate)
+		tr.Stream.Bitrate = averageBitrate(size-headersEnd, tr.Stream.Duration)
+	} else {
+		tr.Errata = append(tr.Errata, "can't work out duration: "+err.Error())
+	}
+
+	c, err := parseVorbisComment(comment)
+	if err != nil {
+		return err
+	}
+
+	tr.Stream.Encoder = c.vendor
+	tr.pictures = c.pictures()
+	tr.TagVersion = TagVorbisComment
+	tr.Metadata, tr.Errata = metadataFromVorbisComment(c, tr.Errata)
+	return nil
+}
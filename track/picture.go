@@ -0,0 +1,154 @@
+package track
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+
+	// register the formats image.DecodeConfig can work out the dimensions of
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// PictureFrontCover is the picture type of a front cover.
+const PictureFrontCover = 3
+
+// pictureTypeNames are the names of the picture types defined by ID3v2.
+var pictureTypeNames = []string{
+	"other", "file icon", "other file icon", "front cover", "back cover", "leaflet page", "media", "lead artist",
+	"artist", "conductor", "band", "composer", "lyricist", "recording location", "during recording",
+	"during performance", "video capture", "a bright coloured fish", "illustration", "band logotype",
+	"publisher logotype",
+}
+
+// DescribePictureType names a picture type, e.g. "front cover".
+func DescribePictureType(t int) string {
+	if t < 0 || t >= len(pictureTypeNames) {
+		return fmt.Sprintf("picture type %d", t)
+	}
+	return pictureTypeNames[t]
+}
+
+// Picture is an image embedded in a file's tags.
+//
+// Type uses the numbering shared by ID3v2 APIC frames and FLAC PICTURE blocks, where PictureFrontCover is the front
+// cover. Width and Height are 0 if they couldn't be worked out from the image or the tag.
+type Picture struct {
+	Type        int
+	MIME        string
+	Description string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// PictureInfo describes an embedded picture without its data, which is all the index keeps.
+// Hash is the hex-encoded SHA-1 of the data, so that tracks with the same picture can be found.
+type PictureInfo struct {
+	Type   int
+	MIME   string
+	Width  int
+	Height int
+	Size   int
+	Hash   string
+}
+
+// Info describes the picture.
+func (p Picture) Info() PictureInfo {
+	sum := sha1.Sum(p.Data)
+	return PictureInfo{
+		Type:   p.Type,
+		MIME:   p.MIME,
+		Width:  p.Width,
+		Height: p.Height,
+		Size:   len(p.Data),
+		Hash:   hex.EncodeToString(sum[:]),
+	}
+}
+
+// Extension returns the usual file extension for the picture's MIME type, including the dot, or "" if it isn't
+// one vir knows.
+func (p Picture) Extension() string {
+	switch p.MIME {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/bmp":
+		return ".bmp"
+	default:
+		return ""
+	}
+}
+
+// FrontCover returns the front cover from a track's pictures, or failing that the first of them.
+func FrontCover(pictures []Picture) (Picture, bool) {
+	if i := frontCoverIndex(len(pictures), func(i int) int { return pictures[i].Type }); i >= 0 {
+		return pictures[i], true
+	}
+	return Picture{}, false
+}
+
+// FrontCover describes the track's front cover, or failing that its first picture.
+func (t *Track) FrontCover() (PictureInfo, bool) {
+	if i := frontCoverIndex(len(t.Pictures), func(i int) int { return t.Pictures[i].Type }); i >= 0 {
+		return t.Pictures[i], true
+	}
+	return PictureInfo{}, false
+}
+
+func frontCoverIndex(n int, pictureType func(i int) int) int {
+	for i := 0; i < n; i++ {
+		if pictureType(i) == PictureFrontCover {
+			return i
+		}
+	}
+	if n > 0 {
+		return 0
+	}
+	return -1
+}
+
+// finishPictures checks the dimensions and MIME type of a track's pictures against the images themselves, which win
+// over what the tags say: taggers often leave them out or get them wrong.
+func finishPictures(pictures []Picture) {
+	for i := range pictures {
+		p := &pictures[i]
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(p.Data))
+		if err != nil {
+			continue
+		}
+		p.Width, p.Height = cfg.Width, cfg.Height
+		p.MIME = "image/" + format
+	}
+}
+
+// ReadPictures reads the pictures embedded in the tags of a file, whatever its format.
+func ReadPictures(fullPath string) ([]Picture, virErrors.ScopedError) {
+	_, pictures, err := loadTrack("vir/track.ReadPictures", fullPath)
+	return pictures, err
+}
+
+// LoadPicture reads an image file as a front cover to embed in tracks. Its MIME type and dimensions are only set if
+// the image is in a format vir can decode.
+func LoadPicture(fullPath string) (Picture, virErrors.ScopedError) {
+	data, err := ioutil.ReadFile(fullPath)
+	if err != nil && os.IsPermission(err) {
+		return Picture{}, virErrors.ErrPermissionDenied("vir/track.LoadPicture", fullPath, err)
+	} else if err != nil {
+		return Picture{}, virErrors.ErrPictureReadFailed("vir/track.LoadPicture", fullPath, err)
+	}
+
+	pictures := []Picture{{Type: PictureFrontCover, Data: data}}
+	finishPictures(pictures)
+	return pictures[0], nil
+}
@@ -0,0 +1,89 @@
+package track
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// Shrink scales a picture down so that neither side is longer than maxSize pixels, re-encoding it as a JPEG of the
+// given quality (1-100). Pictures that already fit are returned unchanged, as are those vir can't decode; the bool
+// reports whether the picture was shrunk.
+//
+// The scaling averages every source pixel that falls in each new one, which is slow next to the filters an image
+// library would use but gives much the same result when shrinking, and needs nothing outside the standard library.
+func (p Picture) Shrink(maxSize, quality int) (Picture, bool, error) {
+	if maxSize <= 0 || p.Width <= 0 || p.Height <= 0 || (p.Width <= maxSize && p.Height <= maxSize) {
+		return p, false, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		return p, false, err
+	}
+
+	bounds := src.Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), maxSize)
+
+	// JPEG has no transparency, so anything transparent ends up white rather than black
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.ZP, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, boxScale(flat, width, height), &jpeg.Options{Quality: quality}); err != nil {
+		return p, false, err
+	}
+
+	p.MIME = "image/jpeg"
+	p.Width, p.Height = width, height
+	p.Data = buf.Bytes()
+	return p, true, nil
+}
+
+// fitWithin scales the given dimensions down to fit in a square of the given size, keeping the aspect ratio.
+func fitWithin(width, height, size int) (int, int) {
+	if width >= height {
+		return size, maxInt(1, (height*size+width/2)/width)
+	}
+	return maxInt(1, (width*size+height/2)/height), size
+}
+
+// boxScale shrinks an image to the given size, making each pixel the average of the source pixels it covers.
+func boxScale(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, maxInt((y+1)*sh/height, y*sh/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, maxInt((x+1)*sw/width, x*sw/width+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,37 @@
+package track
+
+import (
+	"time"
+)
+
+// StreamInfo describes the audio stream of a track, as far as it can be worked out without decoding it.
+// Anything that couldn't be worked out is zero.
+//
+// Bitrate is the average over the whole stream in kbps. VBR is only set when the stream says its bitrate varies,
+// which lossless formats and some lossy ones never do. Encoder is whatever the encoder wrote about itself, e.g.
+// "LAME3.100" from the LAME tag of an MP3 or the vendor string of a Vorbis comment.
+type StreamInfo struct {
+	Duration   time.Duration
+	Bitrate    int
+	SampleRate int
+	Channels   int
+	VBR        bool
+	Lossless   bool
+	Encoder    string
+}
+
+// samplesDuration converts a number of samples at the given rate to a duration.
+func samplesDuration(samples int64, sampleRate int) time.Duration {
+	if samples <= 0 || sampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+}
+
+// averageBitrate works out the bitrate in kbps of a stream of the given length and duration.
+func averageBitrate(bytes int64, d time.Duration) int {
+	if bytes <= 0 || d <= 0 {
+		return 0
+	}
+	return int(float64(bytes)*8/d.Seconds()/1000 + 0.5)
+}
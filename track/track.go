@@ -2,102 +2,242 @@ package track
 
 import (
 	"fmt"
-
-	"github.com/ceralena/vir/virErrors"
-
-	"github.com/casept/id3-go"
+	"io"
+	"math"
+	"os"
 	"strconv"
 	"strings"
+
+	"github.com/ceralena/vir/virErrors"
 )
 
-// Metadata represents the metadata of a track from its id3 tag.
+// Metadata represents the metadata of a track from its tags.
+//
+// Number is -1 if the tag has no usable track number. TrackTotal, DiscNumber, DiscTotal, Year and BPM are 0 if the
+// tag doesn't have them. Date is the release date as written in the tag, which may be anything from just a year to
+// a full timestamp.
 type Metadata struct {
-	Title  string
-	Artist string
-	Album  string
-	Number int
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Number      int
+	TrackTotal  int
+	DiscNumber  int
+	DiscTotal   int
+	Year        int
+	Date        string
+	Genre       string
+	Composer    string
+	Comment     string
+	BPM         int
+	Compilation bool
+	ISRC        string
+	MusicBrainz MusicBrainzIDs
+}
+
+// MusicBrainzIDs are the MusicBrainz identifiers written by taggers such as Picard.
+type MusicBrainzIDs struct {
+	RecordingID    string `json:",omitempty"`
+	TrackID        string `json:",omitempty"`
+	AlbumID        string `json:",omitempty"`
+	ArtistID       string `json:",omitempty"`
+	AlbumArtistID  string `json:",omitempty"`
+	ReleaseGroupID string `json:",omitempty"`
 }
 
-// Track represents a track with the metadata read and parsed from its id3 tag.
+// Track represents a track with the metadata read and parsed from its tags.
+//
+// TagVersion is the kind of tag the metadata came from: the version of an id3 tag, e.g. "1.0" or "2.3.0",
+// TagVorbisComment or TagMP4. It is empty if the file has no tags at all. Pictures describes the images embedded in
+// the tags; ReadPictures reads the images themselves.
 type Track struct {
-	FullPath string
+	FullPath   string
+	Format     Format
+	TagVersion string
 	Metadata
-	Errata []string
+	Stream   StreamInfo
+	Pictures []PictureInfo `json:",omitempty"`
+	Errata   []string
+
+	// pictures holds the images themselves while the track is loading
+	pictures []Picture
 }
 
-// LoadTrackFromPath loads a track from its id3 data given the full path to a file.
-func LoadTrackFromPath(fullPath string) (*Track, virErrors.ScopedError) {
-	var errata []string
+// TagVorbisComment is the TagVersion of tracks whose metadata came from Vorbis comments.
+const TagVorbisComment = "Vorbis"
 
-	f, err := id3.Open(fullPath)
+// TagMP4 is the TagVersion of tracks whose metadata came from the iTunes-style items of an MP4 file.
+const TagMP4 = "MP4"
+
+// LoadTrackFromPath loads a track from the tags of the file at the given full path, whatever its format.
+//
+// Values in the tags that can't be parsed don't stop the track from loading; they are left empty and described in
+// the track's Errata instead. So is a file in a format vir can't read tags from yet.
+func LoadTrackFromPath(fullPath string) (*Track, virErrors.ScopedError) {
+	tr, _, err := loadTrack("vir/track.LoadTrackFromPath", fullPath)
+	return tr, err
+}
 
+// loadTrack loads a track along with the pictures embedded in its tags, which the track itself only describes.
+func loadTrack(scope, fullPath string) (*Track, []Picture, virErrors.ScopedError) {
+	format, err := DetectFormat(fullPath)
 	if err != nil {
-		return nil, virErrors.ErrTrackID3MetadataLoadFailed("vir/track.LoadTrackFromPath", fullPath, err)
+		return nil, nil, err
 	}
 
-	defer func() {
-		e := f.Close()
-		if e != nil {
-			// FIXME(cera) - what can we do here?
-			panic(err)
-		}
-	}()
+	f, openErr := os.Open(fullPath)
+	if openErr != nil && os.IsPermission(openErr) {
+		return nil, nil, virErrors.ErrPermissionDenied(scope, fullPath, openErr)
+	} else if openErr != nil {
+		return nil, nil, virErrors.ErrTrackMetadataLoadFailed(scope, fullPath, openErr)
+	}
+	defer f.Close()
 
-	trackNum, err := parseTrackNumber(f)
+	fi, statErr := f.Stat()
+	if statErr != nil {
+		return nil, nil, virErrors.ErrTrackMetadataLoadFailed(scope, fullPath, statErr)
+	}
 
-	if err != nil {
-		// we consider this a warning, not an error
-		errata = append(errata, err.Error())
+	tr := &Track{FullPath: fullPath, Format: format}
+
+	read, ok := metadataReaders[format]
+	if !ok {
+		tr.Number = -1
+		tr.Errata = append(tr.Errata, unreadableTagsErratum(format))
+		return tr, nil, nil
 	}
 
-	metadata := Metadata{
-		Title:  clean(f.Tagger.Title()),
-		Artist: clean(f.Tagger.Artist()),
-		Album:  clean(f.Tagger.Album()),
-		Number: trackNum,
+	r, size, prefixErr := skipID3v2Prefix(f, format, fi.Size())
+	if prefixErr != nil {
+		return nil, nil, virErrors.ErrTrackMetadataLoadFailed(scope, fullPath, prefixErr)
 	}
 
-	return &Track{
-		FullPath: fullPath,
-		Metadata: metadata,
-		Errata:   errata,
-	}, nil
+	if readErr := read(r, size, tr); readErr != nil {
+		return nil, nil, virErrors.ErrTrackMetadataLoadFailed(scope, fullPath, readErr)
+	}
 
+	// the track may be kept for a long time, like in an index, so it shouldn't hold on to the image data
+	pictures := tr.pictures
+	tr.pictures = nil
+	finishPictures(pictures)
+	for _, p := range pictures {
+		tr.Pictures = append(tr.Pictures, p.Info())
+	}
+
+	return tr, pictures, nil
+}
+
+// metadataReader fills in the tag version, metadata, stream info and errata of a track from an open file of one
+// format, and collects the pictures embedded in its tags.
+type metadataReader func(r io.ReaderAt, size int64, tr *Track) error
+
+// metadataReaders are the readers for every format vir can read tags from.
+var metadataReaders = map[Format]metadataReader{
+	FormatMP3:  readMP3Metadata,
+	FormatFLAC: readFLACMetadata,
+	FormatOgg:  readOggMetadata,
+	FormatMP4:  readMP4Metadata,
+	FormatWAV:  readWAVMetadata,
 }
 
-func parseTrackNumber(tagger id3.Tagger) (int, error) {
-	trackFrame := tagger.Frame("TRCK")
-	if trackFrame == nil {
-		return -1, fmt.Errorf("no track number (TRCK) in tags")
+// skipID3v2Prefix returns the part of a file after any ID3v2 tag at its start, for the formats that don't have one
+// of their own, and the size of that part. MP3 files are returned whole, since their readers deal with the tag.
+func skipID3v2Prefix(f io.ReaderAt, format Format, size int64) (io.ReaderAt, int64, error) {
+	if format == FormatMP3 {
+		return f, size, nil
 	}
 
-	// get a clean frame string
-	frameStr := clean(trackFrame.String())
+	offset, err := id3v2PrefixSize(f)
+	if err != nil || offset == 0 {
+		return f, size, err
+	}
+	return io.NewSectionReader(f, offset, size-offset), size - offset, nil
+}
 
-	// now check for forward slashes
-	// this is to handle a syntax like this:
-	// 1/8
-	if strings.ContainsRune(frameStr, '/') {
-		spl := strings.SplitN(frameStr, "/", 2)
-		if len(spl) != 2 {
-			return -1, fmt.Errorf("invalid or unrecognised track number format in tags: %s", frameStr)
-		}
-		frameStr = spl[0]
+// unreadableTagsErratum is the erratum for a file in a format vir can't read tags from.
+func unreadableTagsErratum(f Format) string {
+	return fmt.Sprintf("can't read tags from %s files", describeFormat(f))
+}
+
+// errInvalidValue is the erratum for a value in a tag that can't be parsed.
+func errInvalidValue(field, frameID, value string) error {
+	return fmt.Errorf("invalid %s (%s) in tags: %q", field, frameID, value)
+}
+
+// parseNumberPair parses a position like a track or disc number, which may be followed by a total, as in "1/8".
+// If only the total is bad, the number is still returned along with the error.
+func parseNumberPair(field, frameID, value string) (int, int, error) {
+	numStr, totalStr := value, ""
+	if i := strings.IndexByte(value, '/'); i >= 0 {
+		numStr, totalStr = value[:i], value[i+1:]
 	}
 
-	trackNum, err := strconv.Atoi(frameStr)
+	num, err := strconv.Atoi(strings.TrimSpace(numStr))
+	if err != nil || num < 0 {
+		return -1, 0, errInvalidValue(field, frameID, value)
+	}
 
-	if err != nil {
-		return -1, fmt.Errorf("invalid track number format in tags: %s: %s", frameStr, err)
+	totalStr = strings.TrimSpace(totalStr)
+	if totalStr == "" {
+		return num, 0, nil
+	}
+
+	total, err := strconv.Atoi(totalStr)
+	if err != nil || total < 0 {
+		return num, 0, errInvalidValue(field+" total", frameID, value)
+	}
+
+	return num, total, nil
+}
+
+// parseYear takes the year from the start of a date, which is all that TYER and the ID3v1 year hold but could be
+// followed by the rest of an ISO 8601 timestamp in TDRC.
+func parseYear(frameID, date string) (int, error) {
+	date = strings.TrimSpace(date)
+	if len(date) < 4 {
+		return 0, errInvalidValue("year", frameID, date)
+	}
+
+	year, err := strconv.Atoi(date[:4])
+	if err != nil || year <= 0 || (len(date) > 4 && date[4] != '-' && date[4] != 'T') {
+		return 0, errInvalidValue("year", frameID, date)
 	}
 
-	return trackNum, nil
+	return year, nil
+}
 
+// parseCount parses a number that stands on its own, like a track total in a field of its own.
+func parseCount(field, frameID, value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return 0, errInvalidValue(field, frameID, value)
+	}
+	return n, nil
+}
+
+// parseBPM parses a tempo. It should be an integer, but some taggers write fractional tempos.
+func parseBPM(frameID, value string) (int, error) {
+	bpm, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || bpm < 0 {
+		return 0, errInvalidValue("BPM", frameID, value)
+	}
+	return int(math.Floor(bpm + 0.5)), nil
+}
+
+// parseFlag parses a boolean frame, which is "1" for true and "0" for false.
+func parseFlag(field, frameID, value string) (bool, error) {
+	switch strings.TrimSpace(value) {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	default:
+		return false, errInvalidValue(field, frameID, value)
+	}
 }
 
+// clean strips the nulls that some taggers leave in text frames.
 func clean(elem string) string {
-	a := strings.Replace(elem, "\u0000", "", -1)
-	b := strings.Replace(a, "\u0026", "", -1)
-	c := strings.Replace(b, "\x00", "", -1)
-	return c
+	return strings.Replace(elem, "\x00", "", -1)
 }
@@ -0,0 +1,267 @@
+package track
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// StreamIssueKind is the kind of damage VerifyStream found in an audio stream.
+type StreamIssueKind string
+
+// The kinds of damage VerifyStream looks for.
+const (
+	// IssueSyncError is a stream that stops looking like audio somewhere before its end and never recovers.
+	IssueSyncError StreamIssueKind = "sync-error"
+	// IssueJunk is data between two frames that isn't part of either, usually from a damaged or spliced file.
+	IssueJunk StreamIssueKind = "junk"
+	// IssueTruncated is a last frame that is cut short, from an interrupted rip or download.
+	IssueTruncated StreamIssueKind = "truncated"
+	// IssueHeaderMismatch is a frame whose MPEG version, layer, sample rate or channels differ from the first frame.
+	IssueHeaderMismatch StreamIssueKind = "header-mismatch"
+	// IssueCRC is a frame whose CRC doesn't match its contents.
+	IssueCRC StreamIssueKind = "crc-mismatch"
+)
+
+// StreamIssue is one kind of damage found in a stream. Offset and Message describe the first occurrence, and Count
+// says how many there were in all.
+type StreamIssue struct {
+	Kind    StreamIssueKind
+	Offset  int64
+	Count   int
+	Message string
+}
+
+// Verification is the result of walking every frame of a track's audio stream.
+// Issues are in the order they were first found, and empty if the stream is intact.
+type Verification struct {
+	Frames int
+	Issues []StreamIssue `json:",omitempty"`
+}
+
+// OK reports whether the stream was found to be intact.
+func (v *Verification) OK() bool {
+	return len(v.Issues) == 0
+}
+
+// add records an occurrence of an issue, merging it with any earlier ones of the same kind.
+func (v *Verification) add(kind StreamIssueKind, offset int64, format string, args ...interface{}) {
+	for i := range v.Issues {
+		if v.Issues[i].Kind == kind {
+			v.Issues[i].Count++
+			return
+		}
+	}
+	v.Issues = append(v.Issues, StreamIssue{Kind: kind, Offset: offset, Count: 1, Message: fmt.Sprintf(format, args...)})
+}
+
+// CanVerifyStream reports whether VerifyStream supports files of the format. Only MP3 is supported so far.
+func (f Format) CanVerifyStream() bool {
+	return f == FormatMP3
+}
+
+// mpegMaxFrameLen is longer than any MPEG audio frame, so that the buffer used to walk a stream can always hold a
+// whole frame and the header of the next one.
+const mpegMaxFrameLen = 8 * 1024
+
+// VerifyStream walks every frame of the audio stream in an MP3 file, looking for damage.
+//
+// Damage is described in the Verification rather than returned as an error; errors are only for files that can't be
+// read at all, or that aren't in a format VerifyStream supports.
+func VerifyStream(fullPath string) (*Verification, virErrors.ScopedError) {
+	format, scopedErr := DetectFormat(fullPath)
+	if scopedErr != nil {
+		return nil, scopedErr
+	}
+	if !format.CanVerifyStream() {
+		return nil, virErrors.ErrTrackStreamReadFailed("vir/track.VerifyStream", fullPath,
+			fmt.Errorf("verifying %s files is not supported", describeFormat(format)))
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil && os.IsPermission(err) {
+		return nil, virErrors.ErrPermissionDenied("vir/track.VerifyStream", fullPath, err)
+	} else if err != nil {
+		return nil, virErrors.ErrTrackStreamReadFailed("vir/track.VerifyStream", fullPath, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, virErrors.ErrTrackStreamReadFailed("vir/track.VerifyStream", fullPath, err)
+	}
+
+	start, end, err := mp3AudioRegion(f, fi.Size())
+	if err != nil {
+		return nil, virErrors.ErrTrackStreamReadFailed("vir/track.VerifyStream", fullPath, err)
+	}
+
+	v, err := verifyMPEGStream(io.NewSectionReader(f, start, end-start), start)
+	if err != nil {
+		return nil, virErrors.ErrTrackStreamReadFailed("vir/track.VerifyStream", fullPath, err)
+	}
+	return v, nil
+}
+
+// verifyMPEGStream walks the frames of a stream of MPEG audio, reporting offsets relative to base.
+func verifyMPEGStream(r io.Reader, base int64) (*Verification, error) {
+	br := bufio.NewReaderSize(r, 2*mpegMaxFrameLen)
+	v := &Verification{}
+	pos := base
+
+	var first mpegFrameHeader
+	for {
+		header, err := br.Peek(4)
+		if len(header) == 0 && err == io.EOF {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		h, ok := parseMPEGFrameHeader(header)
+		if !ok || h.frameLen <= 0 || (v.Frames == 0 && !followedByFrame(br, h)) {
+			skipped, found, err := resyncMPEG(br)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				if v.Frames == 0 {
+					v.add(IssueSyncError, pos, "no MPEG audio frames found")
+				} else {
+					v.add(IssueSyncError, pos, "lost sync %d bytes before the end of the stream", skipped)
+				}
+				break
+			}
+			v.add(IssueJunk, pos, "%d bytes of junk before the frame at offset %d", skipped, pos+int64(skipped))
+			pos += int64(skipped)
+			continue
+		}
+
+		frame, err := br.Peek(h.frameLen)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(frame) < h.frameLen {
+			v.add(IssueTruncated, pos, "last frame is %d bytes short of its %d", h.frameLen-len(frame), h.frameLen)
+			break
+		}
+
+		if v.Frames == 0 {
+			first = h
+		} else if h.version != first.version || h.layer != first.layer || h.sampleRate != first.sampleRate ||
+			h.channels != first.channels {
+			v.add(IssueHeaderMismatch, pos, "frame at offset %d is %s, unlike the %s before it", pos,
+				describeMPEGFrame(h), describeMPEGFrame(first))
+		}
+
+		// the Xing or VBRI frame at the start of a stream doesn't always have a valid CRC
+		if h.protected && !(v.Frames == 0 && hasVBRHeader(frame, h)) && !mpegCRCMatches(frame, h) {
+			v.add(IssueCRC, pos, "CRC of the frame at offset %d doesn't match", pos)
+		}
+
+		v.Frames++
+		if _, err := br.Discard(h.frameLen); err != nil {
+			return nil, err
+		}
+		pos += int64(h.frameLen)
+	}
+
+	return v, nil
+}
+
+// followedByFrame reports whether the frame with the given header, at the front of br, is followed by another frame
+// header or by the end of the stream, as a check that its header isn't a coincidence.
+func followedByFrame(br *bufio.Reader, h mpegFrameHeader) bool {
+	b, _ := br.Peek(h.frameLen + 4)
+	if len(b) == h.frameLen {
+		return true
+	}
+	if len(b) < h.frameLen+4 {
+		return false
+	}
+	_, ok := parseMPEGFrameHeader(b[h.frameLen:])
+	return ok
+}
+
+// resyncMPEG discards bytes from br until it finds a frame that is followed by another, returning how many bytes it
+// skipped and whether it found one.
+func resyncMPEG(br *bufio.Reader) (int, bool, error) {
+	skipped := 0
+	for {
+		if _, err := br.Discard(1); err == io.EOF {
+			return skipped, false, nil
+		} else if err != nil {
+			return skipped, false, err
+		}
+		skipped++
+
+		header, err := br.Peek(4)
+		if len(header) < 4 {
+			if err == io.EOF {
+				skipped += len(header)
+				return skipped, false, nil
+			}
+			return skipped, false, err
+		}
+
+		if h, ok := parseMPEGFrameHeader(header); ok && h.frameLen > 0 && followedByFrame(br, h) {
+			return skipped, true, nil
+		}
+	}
+}
+
+// hasVBRHeader reports whether a frame holds a Xing, Info or VBRI header instead of audio.
+func hasVBRHeader(frame []byte, h mpegFrameHeader) bool {
+	if _, ok := parseXingHeader(frame, h); ok {
+		return true
+	}
+	_, ok := parseVBRIHeader(frame)
+	return ok
+}
+
+// mpegCRCMatches checks the CRC-16 of a protected layer III frame, which covers the last two bytes of the header and
+// the side information. Only layer III is checked; for the other layers the CRC covers fields that can only be found
+// by decoding the frame, so frames of those layers always match.
+func mpegCRCMatches(frame []byte, h mpegFrameHeader) bool {
+	if h.layer != mpegLayer3 {
+		return true
+	}
+
+	end := 6 + h.sideInfoLen()
+	if len(frame) < end {
+		return false
+	}
+
+	crc := crc16(0xffff, frame[2:4])
+	crc = crc16(crc, frame[6:end])
+	return crc == uint16(frame[4])<<8|uint16(frame[5])
+}
+
+// crc16 continues a CRC-16 with the polynomial MPEG audio uses, 0x8005.
+func crc16(crc uint16, b []byte) uint16 {
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// describeMPEGFrame describes the properties of a frame that should be the same throughout a stream.
+func describeMPEGFrame(h mpegFrameHeader) string {
+	version := map[int]string{mpegVersion1: "1", mpegVersion2: "2", mpegVersion25: "2.5"}[h.version]
+	layer := map[int]string{mpegLayer1: "I", mpegLayer2: "II", mpegLayer3: "III"}[h.layer]
+	channels := "stereo"
+	if h.channels == 1 {
+		channels = "mono"
+	}
+	return fmt.Sprintf("MPEG-%s layer %s %d Hz %s", version, layer, h.sampleRate, channels)
+}
@@ -0,0 +1,163 @@
+package track
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// vorbisComment is a Vorbis comment block, the tag format used by FLAC, Ogg Vorbis and Opus.
+// Field names are case-insensitive, so they are kept upper-cased; a field may appear more than once.
+type vorbisComment struct {
+	vendor string
+	fields map[string][]string
+}
+
+// parseVorbisComment parses a Vorbis comment block, without the framing bit Ogg Vorbis adds after it.
+func parseVorbisComment(b []byte) (*vorbisComment, error) {
+	vendor, b, err := vorbisString(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < 4 {
+		return nil, fmt.Errorf("truncated Vorbis comment")
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+
+	c := &vorbisComment{vendor: vendor, fields: make(map[string][]string)}
+	for i := uint32(0); i < count; i++ {
+		var field string
+		field, b, err = vorbisString(b)
+		if err != nil {
+			return nil, err
+		}
+
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			// not a field at all; other readers ignore these too
+			continue
+		}
+		name := strings.ToUpper(field[:eq])
+		c.fields[name] = append(c.fields[name], field[eq+1:])
+	}
+
+	return c, nil
+}
+
+// vorbisString reads a length-prefixed UTF-8 string, returning what follows it.
+func vorbisString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, fmt.Errorf("truncated Vorbis comment")
+	}
+
+	n := binary.LittleEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return "", nil, fmt.Errorf("truncated Vorbis comment")
+	}
+
+	return string(b[4 : 4+n]), b[4+n:], nil
+}
+
+// get returns the value of the first of the named fields that is present. Several values of the same field are
+// joined with semicolons.
+func (c *vorbisComment) get(names ...string) string {
+	for _, name := range names {
+		if values := c.fields[name]; len(values) > 0 {
+			return strings.Join(values, "; ")
+		}
+	}
+	return ""
+}
+
+// pictures decodes the METADATA_BLOCK_PICTURE fields, which hold base64-encoded FLAC PICTURE blocks. Ones that can't
+// be decoded are left out.
+func (c *vorbisComment) pictures() []Picture {
+	var pictures []Picture
+	for _, field := range c.fields["METADATA_BLOCK_PICTURE"] {
+		block, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			continue
+		}
+		if p, err := parseFLACPicture(block); err == nil {
+			pictures = append(pictures, p)
+		}
+	}
+	return pictures
+}
+
+// metadataFromVorbisComment maps the fields written by common taggers (and MusicBrainz Picard in particular) onto
+// Metadata.
+func metadataFromVorbisComment(c *vorbisComment, errata []string) (Metadata, []string) {
+	m := Metadata{
+		Title:       c.get("TITLE"),
+		Artist:      c.get("ARTIST"),
+		Album:       c.get("ALBUM"),
+		AlbumArtist: c.get("ALBUMARTIST", "ALBUM ARTIST"),
+		Genre:       c.get("GENRE"),
+		Composer:    c.get("COMPOSER"),
+		Comment:     c.get("COMMENT", "DESCRIPTION"),
+		ISRC:        c.get("ISRC"),
+		MusicBrainz: MusicBrainzIDs{
+			RecordingID:    c.get("MUSICBRAINZ_TRACKID"),
+			TrackID:        c.get("MUSICBRAINZ_RELEASETRACKID"),
+			AlbumID:        c.get("MUSICBRAINZ_ALBUMID"),
+			ArtistID:       c.get("MUSICBRAINZ_ARTISTID"),
+			AlbumArtistID:  c.get("MUSICBRAINZ_ALBUMARTISTID"),
+			ReleaseGroupID: c.get("MUSICBRAINZ_RELEASEGROUPID"),
+		},
+	}
+
+	var err error
+	if trackNumber := c.get("TRACKNUMBER"); trackNumber == "" {
+		m.Number = -1
+		errata = append(errata, "no track number (TRACKNUMBER) in tags")
+	} else if m.Number, m.TrackTotal, err = parseNumberPair("track number", "TRACKNUMBER", trackNumber); err != nil {
+		errata = append(errata, err.Error())
+	}
+
+	// the total usually has a field of its own rather than following the number
+	if total := c.get("TRACKTOTAL", "TOTALTRACKS"); total != "" && m.TrackTotal == 0 {
+		if m.TrackTotal, err = parseCount("track total", "TRACKTOTAL", total); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if discNumber := c.get("DISCNUMBER"); discNumber != "" {
+		if m.DiscNumber, m.DiscTotal, err = parseNumberPair("disc number", "DISCNUMBER", discNumber); err != nil {
+			errata = append(errata, err.Error())
+		}
+		if m.DiscNumber < 0 {
+			m.DiscNumber = 0
+		}
+	}
+
+	if total := c.get("DISCTOTAL", "TOTALDISCS"); total != "" && m.DiscTotal == 0 {
+		if m.DiscTotal, err = parseCount("disc total", "DISCTOTAL", total); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if date := c.get("DATE", "YEAR"); date != "" {
+		m.Date = date
+		if m.Year, err = parseYear("DATE", date); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if bpm := c.get("BPM"); bpm != "" {
+		if m.BPM, err = parseBPM("BPM", bpm); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	if compilation := c.get("COMPILATION"); compilation != "" {
+		if m.Compilation, err = parseFlag("compilation flag", "COMPILATION", compilation); err != nil {
+			errata = append(errata, err.Error())
+		}
+	}
+
+	return m, errata
+}
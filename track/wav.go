@@ -0,0 +1,85 @@
+package track
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// WAVE format codes of the uncompressed encodings.
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xfffe
+)
+
+// readWAVStream works out the properties of the audio stream of a WAV file from its fmt and data chunks.
+func readWAVStream(r io.ReaderAt, size int64) (StreamInfo, error) {
+	var si StreamInfo
+	var byteRate int64
+	sawFmt := false
+
+	// after the RIFF header and the WAVE form type, chunks are an ID and a little-endian length, padded to an even
+	// length
+	offset := int64(12)
+	for offset+8 <= size {
+		header := make([]byte, 8)
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return StreamInfo{}, err
+		}
+		id := string(header[0:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			if length < 16 {
+				return StreamInfo{}, fmt.Errorf("truncated WAV fmt chunk")
+			}
+			b := make([]byte, 16)
+			if _, err := r.ReadAt(b, offset); err != nil {
+				return StreamInfo{}, err
+			}
+			// format, channels, sample rate, byte rate, block alignment and bits per sample
+			switch binary.LittleEndian.Uint16(b[0:2]) {
+			case wavFormatPCM, wavFormatFloat, wavFormatExtensible:
+				si.Lossless = true
+			}
+			si.Channels = int(binary.LittleEndian.Uint16(b[2:4]))
+			si.SampleRate = int(binary.LittleEndian.Uint32(b[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(b[8:12]))
+			si.Bitrate = int(byteRate * 8 / 1000)
+			sawFmt = true
+		case "data":
+			if !sawFmt {
+				return StreamInfo{}, fmt.Errorf("WAV data chunk comes before the fmt chunk")
+			}
+			// a length past the end of the file usually means the recording was cut short
+			if offset+length > size {
+				length = size - offset
+			}
+			// a byte rate is as good as a sample rate for this
+			si.Duration = samplesDuration(length, int(byteRate))
+			return si, nil
+		}
+
+		offset += length + length%2
+	}
+
+	return StreamInfo{}, fmt.Errorf("no data chunk in WAV file")
+}
+
+// readWAVMetadata reads the stream info of a WAV file. vir doesn't read the tags some taggers add to WAV files.
+func readWAVMetadata(r io.ReaderAt, size int64, tr *Track) error {
+	tr.Number = -1
+	tr.Errata = append(tr.Errata, unreadableTagsErratum(FormatWAV))
+
+	si, err := readWAVStream(r, size)
+	if err != nil {
+		tr.Errata = append(tr.Errata, "can't read audio stream: "+err.Error())
+		return nil
+	}
+
+	tr.Stream = si
+	return nil
+}
@@ -0,0 +1,296 @@
+package track
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ceralena/vir/virErrors"
+)
+
+// UpdateMetadata writes the given fields of m to the tags of a file, leaving everything else in them as it was.
+// Fields that are empty in m are removed from the tags.
+//
+// Only MP3 files can be written so far. The ID3v2 tag is updated, or created as a v2.3 tag if the file doesn't
+// have one; a v2.2 tag is upgraded to v2.3 on the way. Older ID3v2 tags stacked behind it are removed. If the file
+// also has an ID3v1 tag, it is updated to match so the two don't contradict each other.
+//
+// The new file is written alongside the old one and renamed over it, so a crash or a full disk can't leave a
+// truncated file behind.
+func UpdateMetadata(fullPath string, m Metadata, fields []Field) virErrors.ScopedError {
+	format, err := DetectFormat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !format.CanWriteTags() {
+		return virErrors.ErrTrackTagWriteUnsupported("vir/track.UpdateMetadata", fullPath, string(format))
+	}
+
+	writeErr := editID3(fullPath, func(v2Tag *id3v2Tag, v1Tag *id3v1Tag) {
+		for _, f := range fields {
+			setID3v2Field(v2Tag, m, f)
+			if v1Tag != nil {
+				setID3v1Field(v1Tag, m, f)
+			}
+		}
+	})
+	if writeErr != nil && os.IsPermission(writeErr) {
+		return virErrors.ErrPermissionDenied("vir/track.UpdateMetadata", fullPath, writeErr)
+	} else if writeErr != nil {
+		return virErrors.ErrTrackTagWriteFailed("vir/track.UpdateMetadata", fullPath, writeErr)
+	}
+
+	return nil
+}
+
+// ReplacePictures replaces the pictures of a type in the tags of a file with the given ones, or removes them if
+// there are none, leaving pictures of other types alone. It writes the file the same way as UpdateMetadata, and
+// supports the same formats.
+func ReplacePictures(fullPath string, pictureType int, pictures []Picture) virErrors.ScopedError {
+	format, err := DetectFormat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !format.CanWriteTags() {
+		return virErrors.ErrTrackTagWriteUnsupported("vir/track.ReplacePictures", fullPath, string(format))
+	}
+
+	writeErr := editID3(fullPath, func(v2Tag *id3v2Tag, _ *id3v1Tag) {
+		v2Tag.setPictures(pictureType, pictures)
+	})
+	if writeErr != nil && os.IsPermission(writeErr) {
+		return virErrors.ErrPermissionDenied("vir/track.ReplacePictures", fullPath, writeErr)
+	} else if writeErr != nil {
+		return virErrors.ErrTrackTagWriteFailed("vir/track.ReplacePictures", fullPath, writeErr)
+	}
+
+	return nil
+}
+
+// CanWriteTags reports whether UpdateMetadata and ReplacePictures can write to files of the format.
+func (f Format) CanWriteTags() bool {
+	return f == FormatMP3
+}
+
+func setID3v2Field(t *id3v2Tag, m Metadata, f Field) {
+	switch f {
+	case FieldTitle:
+		t.setText("TIT2", m.Title)
+	case FieldArtist:
+		t.setText("TPE1", m.Artist)
+	case FieldAlbum:
+		t.setText("TALB", m.Album)
+	case FieldAlbumArtist:
+		t.setText("TPE2", m.AlbumArtist)
+	case FieldTrack:
+		t.setText("TRCK", m.Get(FieldTrack))
+	case FieldDisc:
+		t.setText("TPOS", m.Get(FieldDisc))
+	case FieldYear:
+		// TDRC only exists from v2.4, and v2.3's TYER only holds the year. The reader prefers TDRC, so it has to
+		// go from v2.3 tags that have it anyway rather than contradict what we write.
+		if t.version >= 4 {
+			t.setText("TDRC", m.Get(FieldYear))
+			t.setText("TYER", "")
+		} else {
+			t.setText("TYER", positiveInt(m.Year))
+			t.setText("TDRC", "")
+		}
+	case FieldGenre:
+		t.setText("TCON", m.Genre)
+	case FieldComposer:
+		t.setText("TCOM", m.Composer)
+	case FieldComment:
+		t.setComment(m.Comment)
+	case FieldBPM:
+		t.setText("TBPM", m.Get(FieldBPM))
+	case FieldCompilation:
+		t.setText("TCMP", m.Get(FieldCompilation))
+	case FieldISRC:
+		t.setText("TSRC", m.ISRC)
+	}
+}
+
+// setID3v1Field updates the fields an ID3v1 tag has room for; the others are ignored.
+func setID3v1Field(t *id3v1Tag, m Metadata, f Field) {
+	switch f {
+	case FieldTitle:
+		t.title = m.Title
+	case FieldArtist:
+		t.artist = m.Artist
+	case FieldAlbum:
+		t.album = m.Album
+	case FieldTrack:
+		t.track = 0
+		if m.Number > 0 && m.Number <= 0xff {
+			t.track = m.Number
+		}
+	case FieldYear:
+		t.year = ""
+		if m.Year > 0 && m.Year <= 9999 {
+			t.year = strconv.Itoa(m.Year)
+		}
+	case FieldGenre:
+		t.genre = genreNumber(m.Genre)
+	case FieldComment:
+		t.comment = m.Comment
+	}
+}
+
+// seedFromID3v1 copies the fields of an ID3v1 tag to a new ID3v2 tag.
+func seedFromID3v1(v2Tag *id3v2Tag, v1Tag *id3v1Tag) {
+	m, _ := metadataFromID3v1(v1Tag, nil)
+	for _, f := range []Field{FieldTitle, FieldArtist, FieldAlbum, FieldTrack, FieldYear, FieldGenre, FieldComment} {
+		setID3v2Field(v2Tag, m, f)
+	}
+}
+
+// editID3 rewrites the ID3 tags of an MP3 file after passing them to edit. v1Tag is nil if the file has no ID3v1
+// tag; one is never added.
+//
+// Only the first of several ID3v2 tags stacked at the start of the file is read, as it is when loading a track, so the
+// others are dropped: left in place, they would go on holding the old values for any reader that looks past the
+// first. A file with an ID3v2 tag appended at its end is refused for the same reason, as is a tag with a damaged
+// frame, since everything after the damage would be lost in the rewrite.
+func editID3(fullPath string, edit func(v2Tag *id3v2Tag, v1Tag *id3v1Tag)) error {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	v2Tag, err := readID3v2Tag(f)
+	if err != nil && v2Tag != nil {
+		return fmt.Errorf("refusing to rewrite a damaged tag: %s", err)
+	} else if err != nil {
+		return err
+	}
+
+	v1Tag, err := readID3v1Tag(f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	// the audio starts after every ID3v2 tag at the start of the file, not just the first
+	audioStart, err := id3v2PrefixSize(f)
+	if err != nil {
+		return err
+	}
+
+	var oldSize int64
+	if v2Tag != nil {
+		oldSize = v2Tag.size
+	} else {
+		v2Tag = newID3v2Tag()
+		if v1Tag != nil {
+			// the new tag takes precedence when reading, so it has to start out with everything in the old one
+			seedFromID3v1(v2Tag, v1Tag)
+		}
+	}
+	v2Tag.upgradeToV23()
+
+	audioEnd := fi.Size()
+	if v1Tag != nil {
+		audioEnd -= id3v1Len
+	}
+	if audioEnd < audioStart {
+		return fmt.Errorf("the ID3v2 tag runs past the end of the file")
+	}
+
+	appended, err := hasAppendedID3v2Tag(f, audioStart, audioEnd)
+	if err != nil {
+		return err
+	} else if appended {
+		return fmt.Errorf("refusing to rewrite a file with an ID3v2 tag appended at its end")
+	}
+
+	edit(v2Tag, v1Tag)
+
+	encoded, err := v2Tag.encode(oldSize)
+	if err != nil {
+		return err
+	}
+
+	return rewriteFile(fullPath, fi.Mode(), func(w io.Writer) error {
+		if _, err := w.Write(encoded); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(f, audioStart, audioEnd-audioStart)); err != nil {
+			return err
+		}
+		// Windows can't rename over a file that is still open
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if v1Tag != nil {
+			_, err := w.Write(v1Tag.encode())
+			return err
+		}
+		return nil
+	})
+}
+
+// hasAppendedID3v2Tag reports whether an ID3v2 tag ends at the end of the audio, or behind any APEv2 or Lyrics3v2 tags
+// there. Only v2.4 tags can be appended, and they have a footer saying so.
+func hasAppendedID3v2Tag(r io.ReaderAt, start, end int64) (bool, error) {
+	trimmed, err := trimTrailingTags(r, start, end)
+	if err != nil {
+		return false, err
+	}
+
+	for _, tagEnd := range []int64{end, trimmed} {
+		if tagEnd-start < id3v2HeaderLen {
+			continue
+		}
+		footer := make([]byte, id3v2HeaderLen)
+		if _, err := r.ReadAt(footer, tagEnd-id3v2HeaderLen); err != nil {
+			return false, err
+		}
+		if string(footer[:3]) == "3DI" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rewriteFile atomically replaces the contents of a file with whatever write produces: the new contents go to a
+// temporary file in the same directory, which is synced to disk and then renamed over the original.
+func rewriteFile(fullPath string, mode os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".vir-")
+	if err != nil {
+		return err
+	}
+
+	// on success the rename means there's nothing left to remove
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriter(tmp)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fullPath)
+}
@@ -0,0 +1,132 @@
+package track
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fixtureMP3 builds an MP3 file of a few silent MPEG-1 layer III frames, behind the given ID3v2 tags and followed by
+// the given ID3v1 tag, either of which can be left out.
+func fixtureMP3(v2Tag, v1Tag []byte) []byte {
+	frame := append([]byte{0xff, 0xfb, 0x90, 0x00}, make([]byte, 413)...)
+	b := append([]byte(nil), v2Tag...)
+	for i := 0; i < 8; i++ {
+		b = append(b, frame...)
+	}
+	return append(b, v1Tag...)
+}
+
+// writeFixture writes a fixture file to a new temporary directory, which the returned function removes.
+func writeFixture(t *testing.T, name string, data []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "vir-track-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullPath := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fullPath, data, 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return fullPath, func() { os.RemoveAll(dir) }
+}
+
+func TestUpdateMetadataKeepsAmpersands(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"no tags", fixtureMP3(nil, nil)},
+		{"ID3v2 tag", fixtureMP3(fixtureTag(3, 0, false, []fixtureFrame{{"TIT2", encodeFixtureText(latin1, "Old")}}), nil)},
+		{"ID3v1 tag only", fixtureMP3(nil, fixtureID3v1("Old", "", "", "", "", 1, 255))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fullPath, cleanup := writeFixture(t, "track.mp3", tt.file)
+			defer cleanup()
+
+			m := Metadata{Title: "The Boxer", Artist: "Simon & Garfunkel", Album: "Bridge over Troubled Water"}
+			if err := UpdateMetadata(fullPath, m, []Field{FieldTitle, FieldArtist, FieldAlbum}); err != nil {
+				t.Fatalf("UpdateMetadata: %s", err)
+			}
+
+			tr, err := LoadTrackFromPath(fullPath)
+			if err != nil {
+				t.Fatalf("LoadTrackFromPath: %s", err)
+			}
+			if tr.Title != m.Title || tr.Artist != m.Artist || tr.Album != m.Album {
+				t.Errorf("read back %q / %q / %q, want %q / %q / %q", tr.Title, tr.Artist, tr.Album, m.Title, m.Artist, m.Album)
+			}
+		})
+	}
+}
+
+func TestUpdateMetadataSeedsFromID3v1(t *testing.T) {
+	// the first ID3v2 tag written to a file with only an ID3v1 tag starts out with everything in it, ampersands and all
+	fullPath, cleanup := writeFixture(t, "track.mp3", fixtureMP3(nil, fixtureID3v1("Cecilia", "Simon & Garfunkel", "", "1970", "", 4, 255)))
+	defer cleanup()
+
+	if err := UpdateMetadata(fullPath, Metadata{Title: "Cecilia (Live)"}, []Field{FieldTitle}); err != nil {
+		t.Fatalf("UpdateMetadata: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := readID3v2Tag(bytes.NewReader(data))
+	if err != nil || tag == nil {
+		t.Fatalf("readID3v2Tag = %v, %v", tag, err)
+	}
+	m, _ := metadataFromID3v2(tag, nil)
+	if m.Title != "Cecilia (Live)" || m.Artist != "Simon & Garfunkel" || m.Year != 1970 || m.Number != 4 {
+		t.Errorf("new ID3v2 tag has %+v", m)
+	}
+}
+
+func TestUpdateMetadataStackedID3v2Tags(t *testing.T) {
+	stale := fixtureTag(3, 0, false, []fixtureFrame{{"TIT2", encodeFixtureText(latin1, "Stale")}})
+	current := fixtureTag(3, 0, false, []fixtureFrame{{"TIT2", encodeFixtureText(latin1, "Current")}})
+	fullPath, cleanup := writeFixture(t, "track.mp3", fixtureMP3(append(current, stale...), nil))
+	defer cleanup()
+
+	if err := UpdateMetadata(fullPath, Metadata{Title: "New"}, []Field{FieldTitle}); err != nil {
+		t.Fatalf("UpdateMetadata: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := readID3v2Tag(bytes.NewReader(data))
+	if err != nil || tag == nil {
+		t.Fatalf("readID3v2Tag = %v, %v", tag, err)
+	}
+	if got := tag.text("TIT2"); got != "New" {
+		t.Errorf("title = %q, want New", got)
+	}
+	if prefix, _ := id3v2PrefixSize(bytes.NewReader(data)); prefix != tag.size {
+		t.Errorf("ID3v2 tags take up %d bytes, want just the new one's %d", prefix, tag.size)
+	}
+	if want := fixtureMP3(nil, nil); !bytes.Equal(data[tag.size:], want) {
+		t.Errorf("the audio after the tag is %d bytes, want the %d bytes of the original", len(data)-int(tag.size), len(want))
+	}
+}
+
+func TestUpdateMetadataRefusesAppendedID3v2Tag(t *testing.T) {
+	appended := fixtureTag(4, id3v2FlagFooter, false, []fixtureFrame{{"TIT2", encodeFixtureText(latin1, "Appended")}})
+	appended = append(appended, append([]byte("3DI"), appended[3:id3v2HeaderLen]...)...)
+	file := append(fixtureMP3(nil, nil), appended...)
+	fullPath, cleanup := writeFixture(t, "track.mp3", file)
+	defer cleanup()
+
+	if err := UpdateMetadata(fullPath, Metadata{Title: "New"}, []Field{FieldTitle}); err == nil {
+		t.Error("UpdateMetadata rewrote a file with an appended ID3v2 tag")
+	}
+	if data, _ := ioutil.ReadFile(fullPath); !bytes.Equal(data, file) {
+		t.Error("the refused file was changed")
+	}
+}
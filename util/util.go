@@ -2,6 +2,9 @@
 package util
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
 	"os"
 )
 
@@ -38,3 +41,19 @@ func DirExists(dirPath string) (bool, error) {
 	}
 	return true, nil
 }
+
+// HashFile returns the hex-encoded SHA-1 of the contents of a file.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
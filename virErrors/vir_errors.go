@@ -3,6 +3,7 @@ package virErrors
 
 import (
 	"fmt"
+	"strings"
 )
 
 func scopedErr(scope, msg string) ScopedError {
@@ -49,6 +50,54 @@ func ErrCacheOperationFailed(scope, op, key string, err error) ScopedError {
 	return scopedErr(scope, fmt.Sprintf("cache operation %s for key %s failed: %s", op, key, err))
 }
 
+// ErrConfigReadFailed is used when we fail to read vir's config file.
+func ErrConfigReadFailed(scope, path string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not read config file %s: %s", path, err))
+}
+
+// ErrInvalidConfig is used when vir's config file can't be parsed, or has settings that don't make sense.
+func ErrInvalidConfig(scope, path string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("invalid config file %s: %s", path, err))
+}
+
+// ErrConfigWriteFailed is used when we fail to write vir's config file.
+func ErrConfigWriteFailed(scope, path string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not write config file %s: %s", path, err))
+}
+
+// ErrInvalidArguments is used when a command is given the wrong arguments.
+func ErrInvalidArguments(scope, msg string) ScopedError {
+	return scopedErr(scope, msg)
+}
+
+// ErrUnknownSetting is used when the user asks for a setting vir's config file can't have.
+func ErrUnknownSetting(scope, key string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("unknown setting %s; run vir config set --help for the settings there are", key))
+}
+
+// ErrInvalidSetting is used when the user gives a setting a value that doesn't make sense for it.
+func ErrInvalidSetting(scope, key string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("invalid value for %s: %s", key, err))
+}
+
+// ErrSettingNotSet is used when the user asks for a setting that isn't in vir's config file and has no default.
+func ErrSettingNotSet(scope, key string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("%s is not set", key))
+}
+
+// ErrUnknownLibrary is used when the user asks for a library that isn't in vir's config file.
+func ErrUnknownLibrary(scope, name string, known []string) ScopedError {
+	if len(known) == 0 {
+		return scopedErr(scope, fmt.Sprintf("unknown library %q; no libraries are configured", name))
+	}
+	return scopedErr(scope, fmt.Sprintf("unknown library %q; expected one of %s", name, strings.Join(known, ", ")))
+}
+
+// ErrMusicLibraryRootNotGiven is used when vir isn't told which music library to work on.
+func ErrMusicLibraryRootNotGiven(scope string) ScopedError {
+	return scopedErr(scope, "no music library given; use --music-root or --library, or set a default library in ~/.vir/config")
+}
+
 // ErrMusicLibraryRootDoesNotExist is used when vir is asked to index a music library root directory that does not exist.
 func ErrMusicLibraryRootDoesNotExist(scope, path string) ScopedError {
 	return scopedErr(scope, "music library root dir does not exist: "+path)
@@ -64,9 +113,190 @@ func ErrMusicLibraryWalkError(scope string, err error) ScopedError {
 	return scopedErr(scope, "encountered an error while walking the music library: "+err.Error())
 }
 
-// ErrTrackID3MetadataLoadFailed is used when we fail to read id3 metadata for a file.
-func ErrTrackID3MetadataLoadFailed(scope, fullPath string, err error) ScopedError {
-	return scopedErr(scope, fmt.Sprintf("encountered an error while loading id3 metadata for %s: %s", fullPath, err))
+// ErrTrackMetadataLoadFailed is used when we fail to read the tags of a file.
+func ErrTrackMetadataLoadFailed(scope, fullPath string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("encountered an error while loading metadata for %s: %s", fullPath, err))
+}
+
+// ErrTrackFormatDetectionFailed is used when we can't read enough of a file to tell whether it is audio.
+func ErrTrackFormatDetectionFailed(scope, fullPath string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not detect the format of %s: %s", fullPath, err))
+}
+
+// ErrTrackStreamReadFailed is used when we fail to read the audio stream of a file.
+func ErrTrackStreamReadFailed(scope, fullPath string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not read the audio stream of %s: %s", fullPath, err))
+}
+
+// ErrTrackNoAudioFrames is used when we can't find any audio frames in a file that should have them.
+func ErrTrackNoAudioFrames(scope, fullPath string) ScopedError {
+	return scopedErr(scope, "could not find any audio frames in "+fullPath)
+}
+
+// ErrTrackTagWriteFailed is used when we fail to write updated tags to a file.
+func ErrTrackTagWriteFailed(scope, fullPath string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not write tags to %s: %s", fullPath, err))
+}
+
+// ErrPictureReadFailed is used when we fail to read an image file to embed in tracks.
+func ErrPictureReadFailed(scope, fullPath string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not read picture from %s: %s", fullPath, err))
+}
+
+// ErrTrackTagWriteUnsupported is used when vir is asked to write tags to a file in a format it can only read.
+func ErrTrackTagWriteUnsupported(scope, fullPath, format string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("writing tags to %s files is not supported: %s", format, fullPath))
+}
+
+// ErrInvalidTagValue is used when the user gives vir a tag value it can't parse.
+func ErrInvalidTagValue(scope, field, value string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("invalid value %q for %s: %s", value, field, err))
+}
+
+// ErrInvalidTrackSelection is used when the user doesn't tell vir which tracks to work on, or what to do with them.
+func ErrInvalidTrackSelection(scope, msg string) ScopedError {
+	return scopedErr(scope, msg)
+}
+
+// ErrInvalidQuery is used when the user gives vir a query it can't parse.
+func ErrInvalidQuery(scope, query string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("invalid query %q: %s", query, err))
+}
+
+// ErrInvalidOutputFormat is used when the user asks for an output format vir doesn't know, or a template it can't parse.
+func ErrInvalidOutputFormat(scope, format string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("invalid output format %q: %s", format, err))
+}
+
+// ErrOutputWriteFailed is used when we fail to write a command's output.
+func ErrOutputWriteFailed(scope string, err error) ScopedError {
+	return scopedErr(scope, "could not write output: "+err.Error())
+}
+
+// ErrInvalidGlobPattern is used when the user gives vir a malformed include or exclude pattern.
+func ErrInvalidGlobPattern(scope, pattern string) ScopedError {
+	return scopedErr(scope, "invalid glob pattern: "+pattern)
+}
+
+// ErrInvalidOrganiseTemplate is used when the user gives vir a path template it can't parse.
+func ErrInvalidOrganiseTemplate(scope, tmpl string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("invalid template %q: %s", tmpl, err))
+}
+
+// ErrFileMoveFailed is used when vir fails to move a file within the music library.
+func ErrFileMoveFailed(scope, from, to string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not move %s to %s: %s", from, to, err))
+}
+
+// ErrFileWriteFailed is used when vir fails to write a file within the music library, or to put it back.
+func ErrFileWriteFailed(scope, path string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not write %s: %s", path, err))
+}
+
+// ErrUnknownFileOp is used when a plan or journal holds a kind of file operation this version of vir doesn't know.
+func ErrUnknownFileOp(scope, kind string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("unknown kind of file operation %q", kind))
+}
+
+// ErrJournalEncodeFailed is used when we fail to serialise the journal of file operations.
+func ErrJournalEncodeFailed(scope string, err error) ScopedError {
+	return scopedErr(scope, "could not encode the vir journal: "+err.Error())
+}
+
+// ErrJournalDecodeFailed is used when the stored journal of file operations can't be read back.
+func ErrJournalDecodeFailed(scope string, err error) ScopedError {
+	return scopedErr(scope, "could not decode the vir journal: "+err.Error())
+}
+
+// ErrJournalBackupMissing is used when the copy of a replaced file that undoing its replacement needs is gone.
+func ErrJournalBackupMissing(scope, hash string) ScopedError {
+	return scopedErr(scope, "the backup with hash "+hash+" is missing from the vir state directory")
+}
+
+// ErrNothingToUndo is used when the user asks to undo the last batch of file operations, but there isn't one.
+func ErrNothingToUndo(scope string) ScopedError {
+	return scopedErr(scope, "there is nothing in the journal to undo")
+}
+
+// ErrJournalBatchNotFound is used when the user asks for a batch of file operations that isn't in the journal.
+func ErrJournalBatchNotFound(scope, id string) ScopedError {
+	return scopedErr(scope, "no batch in the journal with id "+id)
+}
+
+// ErrJournalBatchAlreadyUndone is used when the user asks to undo a batch of file operations twice.
+func ErrJournalBatchAlreadyUndone(scope, id string) ScopedError {
+	return scopedErr(scope, "batch "+id+" has already been undone")
+}
+
+// ErrUndoConflict is used when a batch of file operations can't be undone because files have changed since.
+func ErrUndoConflict(scope, id string, conflicts []string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("can't undo batch %s without losing changes:\n  %s", id, strings.Join(conflicts, "\n  ")))
+}
+
+// ErrIndexNotBuilt is used when a command needs the persisted index but it has not been built yet.
+func ErrIndexNotBuilt(scope string) ScopedError {
+	return scopedErr(scope, "the vir index has not been built yet; run vir rebuild-index first")
+}
+
+// ErrIndexEncodeFailed is used when we fail to serialise the index for storage.
+func ErrIndexEncodeFailed(scope string, err error) ScopedError {
+	return scopedErr(scope, "could not encode the vir index: "+err.Error())
+}
+
+// ErrIndexDecodeFailed is used when the stored index can't be read back.
+func ErrIndexDecodeFailed(scope string, err error) ScopedError {
+	return scopedErr(scope, "could not decode the stored vir index; run vir rebuild-index: "+err.Error())
+}
+
+// ErrIndexVersionUnsupported is used when the stored index was written by a state version we can't migrate from.
+func ErrIndexVersionUnsupported(scope, version string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("stored vir index has unsupported version %q; run vir rebuild-index", version))
+}
+
+// ErrIndexRootMismatch is used when the stored index was built for a different music library root.
+func ErrIndexRootMismatch(scope, storedRoot, musicLibraryRoot string) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("stored vir index was built for %s, not %s; run vir rebuild-index", storedRoot, musicLibraryRoot))
+}
+
+// ErrFileHashFailed is used when we fail to hash the contents of a file.
+func ErrFileHashFailed(scope, fullPath string, err error) ScopedError {
+	return scopedErr(scope, fmt.Sprintf("could not hash the contents of %s: %s", fullPath, err))
+}
+
+type permissionDeniedError struct {
+	scopedError
+}
+
+// ErrPermissionDenied is used when vir is not allowed to access a file or directory.
+func ErrPermissionDenied(scope, path string, err error) ScopedError {
+	return permissionDeniedError{scopedError{scope, fmt.Sprintf("permission denied for %s: %s", path, err)}}
+}
+
+// IsPermissionDenied reports whether err was produced by ErrPermissionDenied.
+func IsPermissionDenied(err error) bool {
+	_, ok := err.(permissionDeniedError)
+	return ok
+}
+
+type completedWithProblemsError struct {
+	scopedError
+}
+
+// ErrCompletedWithProblems is used when an operation ran to completion, but had to skip some files along the way.
+// The files and what went wrong with them should already have been reported to the user.
+func ErrCompletedWithProblems(scope string, count int) ScopedError {
+	return completedWithProblemsError{scopedError{scope, fmt.Sprintf("completed with problems in %d file(s)", count)}}
+}
+
+// IsCompletedWithProblems reports whether err was produced by ErrCompletedWithProblems.
+func IsCompletedWithProblems(err error) bool {
+	_, ok := err.(completedWithProblemsError)
+	return ok
+}
+
+// ErrInterrupted is used when an operation was cancelled before it could finish, e.g. by the user hitting Ctrl-C.
+func ErrInterrupted(scope string) ScopedError {
+	return scopedErr(scope, "interrupted")
 }
 
 // ErrFatal is used when we encounter an unexpected I/O error or some other kind of fatal error that is very difficult